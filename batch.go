@@ -0,0 +1,258 @@
+package gzap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// batchQueueSize is the number of batches waiting to be sent, beyond which
+// batches are dropped rather than blocking the logger.
+const batchQueueSize = 16
+
+// batcher gathers items in batches, sent in the background one at a time,
+// in order, once size items or bytes are pending, every flushInterval, and
+// on sync and Close.
+type batcher struct {
+	name          string
+	size          int
+	bytes         int
+	flushInterval time.Duration
+	send          func(items []interface{}) error
+
+	mu           sync.Mutex
+	pending      []interface{}
+	pendingBytes int
+	closed       bool
+	err          error
+
+	// queued is the number of batches queued or being sent, idle being
+	// signaled once it drops to zero.
+	queue  chan []interface{}
+	queued int
+	idle   *sync.Cond
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newBatcher starts a batcher sending batches of up to size items, or of
+// about bytes bytes if not 0, with send. Errors are prefixed with name.
+func newBatcher(name string, size int, bytes int, flushInterval time.Duration, send func(items []interface{}) error) *batcher {
+	b := &batcher{
+		name:          name,
+		size:          size,
+		bytes:         bytes,
+		flushInterval: flushInterval,
+		send:          send,
+		queue:         make(chan []interface{}, batchQueueSize),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	b.idle = sync.NewCond(&b.mu)
+	go b.run()
+
+	return b
+}
+
+func (b *batcher) run() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case items := <-b.queue:
+			b.sendBatch(items)
+		case <-ticker.C:
+			b.flush()
+		case <-b.done:
+			for {
+				select {
+				case items := <-b.queue:
+					b.sendBatch(items)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// add adds an item of about size bytes to the pending batch, which is
+// queued once full.
+func (b *batcher) add(item interface{}, size int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("%s: closed", b.name)
+	}
+
+	b.pending = append(b.pending, item)
+	b.pendingBytes += size
+	if len(b.pending) >= b.size || b.bytes > 0 && b.pendingBytes >= b.bytes {
+		return b.enqueueLocked()
+	}
+
+	return nil
+}
+
+func (b *batcher) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.enqueueLocked()
+}
+
+// enqueueLocked queues the pending batch, or drops it when the queue is full.
+func (b *batcher) enqueueLocked() error {
+	items := b.pending
+	if len(items) == 0 {
+		return nil
+	}
+	b.pending = nil
+	b.pendingBytes = 0
+
+	select {
+	case b.queue <- items:
+		b.queued++
+		return nil
+	default:
+		return fmt.Errorf("%s: queue full, dropped %d entries", b.name, len(items))
+	}
+}
+
+// sendBatch sends a batch, and records the error if it fails.
+func (b *batcher) sendBatch(items []interface{}) {
+	err := b.send(items)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		b.err = fmt.Errorf("%s: %v", b.name, err)
+	}
+	b.queued--
+	if b.queued == 0 {
+		b.idle.Broadcast()
+	}
+}
+
+// sync sends the pending items, waits for every queued batch to be sent,
+// and returns the last error since the previous sync.
+func (b *batcher) sync() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.enqueueLocked()
+	for b.queued > 0 {
+		b.idle.Wait()
+	}
+
+	if err == nil {
+		err = b.err
+	}
+	b.err = nil
+
+	return err
+}
+
+// Close sends the pending items and stops the batcher.
+func (b *batcher) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	err := b.enqueueLocked()
+	b.mu.Unlock()
+
+	close(b.done)
+	<-b.stopped
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		err = b.err
+	}
+
+	return err
+}
+
+// httpRetry retries requests failing with a 429, a 5xx or a network error,
+// waiting from minBackoff up to maxBackoff in between, or the delay asked by
+// a Retry-After header.
+type httpRetry struct {
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// do sends the requests built by newRequest until one succeeds, and returns
+// the body of its response.
+func (r httpRetry) do(client *http.Client, newRequest func() (*http.Request, error)) ([]byte, error) {
+	backoff := r.minBackoff
+	for attempt := 0; ; attempt++ {
+		body, retryAfter, err := doHTTP(client, newRequest)
+		if err == nil {
+			return body, nil
+		}
+		if retryAfter < 0 || attempt >= r.retries {
+			return nil, err
+		}
+
+		if retryAfter == 0 {
+			retryAfter = backoff
+		}
+		if retryAfter > r.maxBackoff {
+			retryAfter = r.maxBackoff
+		}
+		time.Sleep(retryAfter)
+
+		if backoff *= 2; backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// doHTTP sends a request. On failure, it returns how long to wait before
+// retrying: 0 for the default backoff, or -1 when the request must not be
+// retried.
+func doHTTP(client *http.Client, newRequest func() (*http.Request, error)) ([]byte, time.Duration, error) {
+	req, err := newRequest()
+	if err != nil {
+		return nil, -1, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		body, err := ioutil.ReadAll(resp.Body)
+		return body, 0, err
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode/100 != 5 {
+		return nil, -1, err
+	}
+
+	if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+		return nil, time.Duration(seconds) * time.Second, err
+	}
+
+	return nil, 0, err
+}
@@ -5,13 +5,20 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	graylog "github.com/Devatoria/go-graylog"
+	"go.uber.org/zap/zapcore"
 )
 
 const tlsTransport = "tls"
 
+const (
+	defaultGraylogPort       = 12201
+	defaultGraylogTLSTimeout = time.Second * 3
+)
+
 // Config is an interface representing all the logging configurations accessible
 // via environment
 type Config interface {
@@ -26,136 +33,647 @@ type Config interface {
 	getIsTestEnv() bool
 	useTLS() bool
 	useColoredConsolelogs() bool
+	getLevel() zapcore.Level
+	getLoggerLevels() map[string]zapcore.Level
+	getStaticFields() map[string]string
+	getRedactRules() []string
+	getConsoleOptions() consoleOptions
+	getFileOptions() fileOptions
+	getSyslogOptions() syslogOptions
+	getHTTPBodyOptions() httpBodyOptions
+	getSinks() []sinkConfig
 }
 
 // EnvConfig represents all the logger configurations available
 // when instaniating a new Logger.
-type EnvConfig struct{}
+//
+// An EnvConfig is an immutable snapshot: the environment is read and validated
+// once by NewEnvConfig, and the getters only return the resolved values. This
+// keeps environment lookups (and configuration errors) off the logging hot path.
+type EnvConfig struct {
+	jsonFormatter        bool
+	graylogAppName       string
+	graylogHandlerType   graylog.Transport
+	graylogHost          string
+	graylogPort          uint
+	graylogTLSTimeout    time.Duration
+	graylogLogEnvName    string
+	graylogSkipTLSVerify bool
+	isTestEnv            bool
+	coloredConsoleLogs   bool
+	level                zapcore.Level
+	levelConfigured      bool
+	loggerLevels         map[string]zapcore.Level
+	staticFields         map[string]string
+	redactRules          []string
+	consoleOptions       consoleOptions
+	fileOptions          fileOptions
+	syslogOptions        syslogOptions
+	httpBodyOptions      httpBodyOptions
+	sinks                []sinkConfig
+	configFile           string
+	source               configSource
+	report               ConfigReport
+}
 
-func (e *EnvConfig) enableJSONFormatter() bool {
-	jsonFormatter := os.Getenv("ENABLE_DATADOG_JSON_FORMATTER")
-	if jsonFormatter == "true" {
-		return true
+// NewEnvConfig reads the logger configuration from the environment and
+// validates it.
+//
+// When GZAP_CONFIG names a JSON configuration file (see FileConfig) it is read
+// as well, with environment variables taking precedence over its values.
+//
+// Every problem found is reported together in a single *ConfigError. The
+// returned EnvConfig is never nil: invalid values are replaced by their
+// defaults, so it can still be used to build a console-only logger.
+func NewEnvConfig() (*EnvConfig, error) {
+	return newEnvConfig(configSource{env: true}.resolver())
+}
+
+// newInitConfig reads the configuration of the global Logger built when gzap
+// is initialized. Sinks whose scheme is not registered yet are skipped rather
+// than reported, see RegisterSink.
+func newInitConfig() (*EnvConfig, error) {
+	r := configSource{env: true}.resolver()
+	r.skipUnknownSinks = true
+
+	return newEnvConfig(r)
+}
+
+// newEnvConfig resolves an EnvConfig, looking up every variable with r.
+func newEnvConfig(r *configResolver) (*EnvConfig, error) {
+	errs := r.errs
+
+	cfg := &EnvConfig{
+		configFile:           r.get("GZAP_CONFIG"),
+		source:               r.source,
+		jsonFormatter:        r.get("ENABLE_DATADOG_JSON_FORMATTER") == "true",
+		graylogHost:          r.get("GRAYLOG_HOST"),
+		graylogAppName:       r.get("GRAYLOG_APP_NAME"),
+		graylogLogEnvName:    r.get("GRAYLOG_ENV"),
+		graylogSkipTLSVerify: r.get("GRAYLOG_SKIP_TLS_VERIFY") == "true",
+		isTestEnv:            flag.Lookup("test.v") != nil,
+		staticFields:         r.getMap("GZAP_FIELDS"),
+		redactRules:          r.getList("GZAP_REDACT"),
+	}
+
+	cfg.coloredConsoleLogs = parseConsoleColor(r, errs)
+	cfg.consoleOptions = parseConsoleOptions(r, errs, cfg.jsonFormatter)
+	cfg.fileOptions = parseFileOptions(r, errs)
+	cfg.syslogOptions = parseSyslogOptions(r, errs)
+	cfg.httpBodyOptions = parseHTTPBodyOptions(r, errs, cfg.redactRules)
+	cfg.graylogHandlerType = parseGraylogHandlerType(r, errs)
+	cfg.graylogPort = parseGraylogPort(r, errs, cfg.graylogHandlerType)
+	cfg.graylogTLSTimeout = parseGraylogTLSTimeout(r, errs)
+	cfg.sinks = parseSinks(r, errs)
+	cfg.level, cfg.levelConfigured = parseLevel(r, errs)
+	cfg.loggerLevels = parseLoggerLevels(r, errs)
+
+	// The remaining settings are only needed once Graylog is enabled.
+	if cfg.graylogEnabled() {
+		if cfg.graylogAppName == "" {
+			errs.add("GRAYLOG_APP_NAME", "not set")
+		}
+
+		if cfg.graylogLogEnvName == "" {
+			errs.add("GRAYLOG_ENV", "not set")
+		}
 	}
-	return false
+
+	cfg.report = r.report()
+
+	return cfg, errs.orNil()
 }
 
-func (e EnvConfig) getGraylogAppName() string {
-	appName := os.Getenv("GRAYLOG_APP_NAME")
-	if appName == "" {
-		panic("GRAYLOG_APP_NAME env not set")
+func parseConsoleColor(r *configResolver, errs *ConfigError) bool {
+	switch color := r.get("GZAP_CONSOLE_COLOR"); color {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "auto":
+		return isTerminal(os.Stdout)
+	case "":
+	default:
+		errs.add("GZAP_CONSOLE_COLOR", fmt.Sprintf("expected \"true\", \"false\" or \"auto\", got %q", color))
+		return false
+	}
+
+	// Colored logs are the default in the dev environment, and when writing
+	// to a terminal outside of any environment.
+	if level := r.get("THEMUSE_ENV_LEVEL"); level != "" {
+		return level == "0"
 	}
 
-	return appName
+	return isTerminal(os.Stdout)
 }
 
-func (e *EnvConfig) getGraylogHandlerType() graylog.Transport {
-	defaultHandlerType := tlsTransport
-	handlerType := os.Getenv("GRAYLOG_HANDLER_TYPE")
+// isTerminal reports whether f is a character device, such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-	var transportType graylog.Transport
-	if handlerType == tlsTransport {
-		transportType = graylog.TCP
+func parseConsoleOptions(r *configResolver, errs *ConfigError, jsonFormatter bool) consoleOptions {
+	opts := defaultConsoleOptions()
+	if jsonFormatter {
+		opts.format = consoleFormatDatadog
 	}
 
-	if graylog.Transport(handlerType) == graylog.UDP {
-		transportType = graylog.UDP
+	if format := r.get("GZAP_CONSOLE_FORMAT"); format != "" {
+		if err := validateFormat(format); err != nil {
+			errs.add("GZAP_CONSOLE_FORMAT", err.Error())
+		} else {
+			opts.format = format
+		}
 	}
 
-	// If no transport type is set use tls by default.
-	if transportType == "" {
-		transportType = graylog.Transport(defaultHandlerType)
+	opts.datadog = parseDatadogOptions(r)
+
+	if timeFormat := r.get("GZAP_CONSOLE_TIME_FORMAT"); timeFormat != "" {
+		if _, ok := namedTimeEncoders[timeFormat]; !ok && !isTimeLayout(timeFormat) {
+			errs.add("GZAP_CONSOLE_TIME_FORMAT", fmt.Sprintf("%q is neither a known format nor a Go time layout", timeFormat))
+		} else {
+			opts.timeFormat = timeFormat
+		}
 	}
 
-	return transportType
-}
+	if timeZone := r.get("GZAP_CONSOLE_TIME_ZONE"); timeZone != "" {
+		location, err := time.LoadLocation(timeZone)
+		if err != nil {
+			errs.add("GZAP_CONSOLE_TIME_ZONE", err.Error())
+		} else {
+			opts.timeZone = location
+		}
+	}
 
-func (e *EnvConfig) getGraylogHost() string {
-	graylogHost := os.Getenv("GRAYLOG_HOST")
-	return graylogHost
+	switch caller := r.get("GZAP_CONSOLE_CALLER"); caller {
+	case "":
+	case consoleCallerShort, consoleCallerFull, consoleCallerNone:
+		opts.caller = caller
+	default:
+		errs.add("GZAP_CONSOLE_CALLER", fmt.Sprintf("unknown caller format %q, expected %q, %q or %q", caller, consoleCallerShort, consoleCallerFull, consoleCallerNone))
+	}
+
+	switch text := r.get("GZAP_CONSOLE_STDERR_LEVEL"); text {
+	case "":
+	case "none":
+		opts.stderrLevel = noStderrLevel
+	default:
+		if err := opts.stderrLevel.UnmarshalText([]byte(text)); err != nil {
+			errs.add("GZAP_CONSOLE_STDERR_LEVEL", err.Error())
+		}
+	}
+
+	return opts
 }
 
-func (e *EnvConfig) getGraylogPort() uint {
-	portString := "12201"
+func parseGraylogHandlerType(r *configResolver, errs *ConfigError) graylog.Transport {
+	handlerType := r.get("GRAYLOG_HANDLER_TYPE")
+
+	switch handlerType {
+	// If no transport type is set use tls by default.
+	case "", tlsTransport:
+		return graylog.TCP
+	case string(graylog.UDP):
+		return graylog.UDP
+	}
+
+	errs.add("GRAYLOG_HANDLER_TYPE", fmt.Sprintf("unknown handler type %q, expected %q or %q", handlerType, tlsTransport, graylog.UDP))
+	return graylog.TCP
+}
 
-	if e.getGraylogHandlerType() == graylog.UDP {
-		portString = os.Getenv("GRAYLOG_UDP_PORT")
+func parseGraylogPort(r *configResolver, errs *ConfigError, handlerType graylog.Transport) uint {
+	name := "GRAYLOG_TLS_PORT"
+	if handlerType == graylog.UDP {
+		name = "GRAYLOG_UDP_PORT"
 	}
 
-	if e.getGraylogHandlerType() == graylog.TCP {
-		portString = os.Getenv("GRAYLOG_TLS_PORT")
+	portString := r.get(name)
+	if portString == "" {
+		return defaultGraylogPort
 	}
 
-	port, err := strconv.ParseUint(portString, 10, 32)
-	if err != nil {
-		panic(fmt.Errorf("could not properly parse Graylog port: %s", portString))
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil || port == 0 {
+		errs.add(name, fmt.Sprintf("could not properly parse Graylog port: %s", portString))
+		return defaultGraylogPort
 	}
 
 	return uint(port)
 }
 
-func (e *EnvConfig) getGraylogTLSTimeout() time.Duration {
-	defaultTimeout := time.Second * 3
-
-	timeoutString := os.Getenv("GRAYLOG_TLS_TIMEOUT_SECS")
+func parseGraylogTLSTimeout(r *configResolver, errs *ConfigError) time.Duration {
+	timeoutString := r.get("GRAYLOG_TLS_TIMEOUT_SECS")
 	if timeoutString == "" {
-		return defaultTimeout
+		return defaultGraylogTLSTimeout
 	}
 
 	timeoutSeconds, err := strconv.ParseInt(timeoutString, 10, 32)
-	if err != nil {
-		panic("invalid GRAYLOG_TLS_TIMEOUT_SECS could not parse int")
+	if err != nil || timeoutSeconds < 0 {
+		errs.add("GRAYLOG_TLS_TIMEOUT_SECS", fmt.Sprintf("could not parse %q as a number of seconds", timeoutString))
+		return defaultGraylogTLSTimeout
 	}
 
 	return time.Second * time.Duration(timeoutSeconds)
 }
 
-func (e *EnvConfig) getGraylogLogEnvName() string {
-	envName := os.Getenv("GRAYLOG_ENV")
-	if envName == "" {
-		panic("GRAYLOG_ENV not set")
+// parseDatadogOptions reads the unified service tags of Datadog. The service
+// defaults to the app name, and the host to the name of the machine.
+func parseDatadogOptions(r *configResolver) datadogOptions {
+	opts := datadogOptions{
+		service: r.get("DD_SERVICE"),
+		env:     r.get("DD_ENV"),
+		version: r.get("DD_VERSION"),
+		host:    r.get("DD_HOSTNAME"),
 	}
 
-	return envName
+	if opts.service == "" {
+		opts.service = r.get("GRAYLOG_APP_NAME")
+	}
+	if opts.host == "" {
+		opts.host, _ = os.Hostname()
+	}
+
+	return opts
 }
 
-func (e *EnvConfig) getGraylogSkipInsecureSkipVerify() bool {
-	skipInsecure := os.Getenv("GRAYLOG_SKIP_TLS_VERIFY")
-	if skipInsecure == "true" {
-		return true
+func parseFileOptions(r *configResolver, errs *ConfigError) fileOptions {
+	opts := fileOptions{
+		path:       r.get("GZAP_FILE_PATH"),
+		maxSize:    defaultFileMaxSize,
+		maxBackups: defaultFileMaxBackups,
+		compress:   r.get("GZAP_FILE_COMPRESS") == "true",
+		mode:       defaultFileMode,
+		format:     consoleFormatJSON,
+	}
+
+	if text := r.get("GZAP_FILE_MAX_SIZE"); text != "" {
+		size, err := parseSize(text)
+		if err != nil {
+			errs.add("GZAP_FILE_MAX_SIZE", err.Error())
+		} else {
+			opts.maxSize = size
+		}
 	}
 
-	return false
+	if text := r.get("GZAP_FILE_MAX_AGE"); text != "" {
+		age, err := parseMaxAge(text)
+		if err != nil {
+			errs.add("GZAP_FILE_MAX_AGE", err.Error())
+		} else {
+			opts.maxAge = age
+		}
+	}
+
+	if text := r.get("GZAP_FILE_MAX_BACKUPS"); text != "" {
+		backups, err := parseMaxBackups(text)
+		if err != nil {
+			errs.add("GZAP_FILE_MAX_BACKUPS", err.Error())
+		} else {
+			opts.maxBackups = backups
+		}
+	}
+
+	if text := r.get("GZAP_FILE_MODE"); text != "" {
+		mode, err := parseFileMode(text)
+		if err != nil {
+			errs.add("GZAP_FILE_MODE", err.Error())
+		} else {
+			opts.mode = mode
+		}
+	}
+
+	if format := r.get("GZAP_FILE_FORMAT"); format != "" {
+		if err := validateFormat(format); err != nil {
+			errs.add("GZAP_FILE_FORMAT", err.Error())
+		} else {
+			opts.format = format
+		}
+	}
+
+	return opts
 }
 
-func (e *EnvConfig) getIsTestEnv() bool {
-	// If we're running test return test logger env.
-	if flag.Lookup("test.v") != nil {
-		return true
+func parseMaxAge(text string) (time.Duration, error) {
+	age, err := time.ParseDuration(text)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("could not parse %q as a duration, e.g. \"24h\"", text)
 	}
 
-	return false
+	return age, nil
 }
 
-func (e *EnvConfig) useTLS() bool {
-	handlerType := os.Getenv("GRAYLOG_HANDLER_TYPE")
-	if handlerType == "" {
-		panic("GRAYLOG_HANDLER_TYPE env not set")
+func parseMaxBackups(text string) (int, error) {
+	backups, err := strconv.Atoi(text)
+	if err != nil || backups < 0 {
+		return 0, fmt.Errorf("could not parse %q as a number of files", text)
 	}
 
-	if handlerType == tlsTransport {
-		return true
+	return backups, nil
+}
+
+func parseFileMode(text string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(text, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("could not parse %q as octal permissions, e.g. \"0640\"", text)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// validateFormat checks the format of an encoder, see newConsoleEncoder.
+func validateFormat(format string) error {
+	switch format {
+	case consoleFormatConsole, consoleFormatJSON, consoleFormatLogfmt, consoleFormatDatadog:
+		return nil
+	}
+
+	return fmt.Errorf("unknown format %q, expected %q, %q, %q or %q", format, consoleFormatConsole, consoleFormatJSON, consoleFormatLogfmt, consoleFormatDatadog)
+}
+
+func parseSyslogOptions(r *configResolver, errs *ConfigError) syslogOptions {
+	opts := syslogOptions{
+		facility: syslogFacilities["user"],
+		sdID:     defaultSyslogSDID,
 	}
 
-	return false
+	if text := r.get("GZAP_SYSLOG_ADDRESS"); text != "" {
+		network, address, err := parseSyslogAddress(text)
+		if err != nil {
+			errs.add("GZAP_SYSLOG_ADDRESS", err.Error())
+		} else {
+			opts.network, opts.address = network, address
+		}
+	}
+
+	if name := r.get("GZAP_SYSLOG_FACILITY"); name != "" {
+		facility, err := parseSyslogFacility(name)
+		if err != nil {
+			errs.add("GZAP_SYSLOG_FACILITY", err.Error())
+		} else {
+			opts.facility = facility
+		}
+	}
+
+	if sdID := r.get("GZAP_SYSLOG_SD_ID"); sdID != "" {
+		if err := validateSyslogSDID(sdID); err != nil {
+			errs.add("GZAP_SYSLOG_SD_ID", err.Error())
+		} else {
+			opts.sdID = sdID
+		}
+	}
+
+	return opts
+}
+
+func parseSyslogFacility(name string) (int, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown facility %q, e.g. \"user\" or \"local0\"", name)
+	}
+
+	return facility, nil
+}
+
+func validateSyslogSDID(sdID string) error {
+	if syslogParamName(sdID) != sdID || !strings.Contains(sdID, "@") {
+		return fmt.Errorf("%q is not a private SD-ID, e.g. \"fields@32473\"", sdID)
+	}
+
+	return nil
+}
+
+// parseSinks parses the URLs of GZAP_SINKS, see RegisterSink.
+func parseSinks(r *configResolver, errs *ConfigError) []sinkConfig {
+	var sinks []sinkConfig
+	for _, text := range r.getList("GZAP_SINKS") {
+		sink, err := parseSink(text)
+		if _, ok := err.(unknownSchemeError); ok && r.skipUnknownSinks {
+			continue
+		}
+		if err != nil {
+			errs.add("GZAP_SINKS", err.Error())
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks
+}
+
+// parseSize parses a number of bytes, with an optional KB, MB or GB suffix.
+func parseSize(text string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"G", 1 << 30},
+		{"M", 1 << 20},
+		{"K", 1 << 10},
+		{"B", 1},
+	}
+
+	number, unit := strings.ToUpper(strings.TrimSpace(text)), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(number, u.suffix) {
+			number, unit = strings.TrimSpace(strings.TrimSuffix(number, u.suffix)), u.size
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("could not parse %q as a size, e.g. \"100MB\"", text)
+	}
+
+	return size * unit, nil
+}
+
+// parseLevel defaults to debug. Unless GZAP_LEVEL is set, Graylog still only
+// receives info and above, see graylogLevelCore.
+func parseLevel(r *configResolver, errs *ConfigError) (zapcore.Level, bool) {
+	lvl := zapcore.DebugLevel
+
+	text := r.get("GZAP_LEVEL")
+	if text == "" {
+		return lvl, false
+	}
+
+	if err := lvl.UnmarshalText([]byte(text)); err != nil {
+		errs.add("GZAP_LEVEL", err.Error())
+		return zapcore.DebugLevel, false
+	}
+
+	return lvl, true
+}
+
+// levelConfigured reports whether the global level of cfg was set with
+// GZAP_LEVEL, rather than being the default one.
+func levelConfigured(cfg Config) bool {
+	envConfig, ok := cfg.(*EnvConfig)
+	return ok && envConfig.levelConfigured
+}
+
+func parseLoggerLevels(r *configResolver, errs *ConfigError) map[string]zapcore.Level {
+	levels := map[string]zapcore.Level{}
+	for name, text := range r.getMap("GZAP_LOGGER_LEVELS") {
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(text)); err != nil {
+			errs.add("GZAP_LOGGER_LEVELS", fmt.Sprintf("logger %s: %v", name, err))
+			continue
+		}
+		levels[name] = lvl
+	}
+
+	return levels
+}
+
+// Report describes where every setting came from, and the problems found
+// while resolving them.
+func (e *EnvConfig) Report() ConfigReport {
+	return e.report
+}
+
+func (e *EnvConfig) enableJSONFormatter() bool {
+	return e.jsonFormatter
+}
+
+func (e *EnvConfig) getGraylogAppName() string {
+	return e.graylogAppName
+}
+
+func (e *EnvConfig) getGraylogHandlerType() graylog.Transport {
+	return e.graylogHandlerType
+}
+
+func (e *EnvConfig) getGraylogHost() string {
+	return e.graylogHost
+}
+
+func (e *EnvConfig) getGraylogPort() uint {
+	return e.graylogPort
+}
+
+func (e *EnvConfig) getGraylogTLSTimeout() time.Duration {
+	return e.graylogTLSTimeout
+}
+
+func (e *EnvConfig) getGraylogLogEnvName() string {
+	return e.graylogLogEnvName
+}
+
+func (e *EnvConfig) getGraylogSkipInsecureSkipVerify() bool {
+	return e.graylogSkipTLSVerify
+}
+
+func (e *EnvConfig) getIsTestEnv() bool {
+	return e.isTestEnv
+}
+
+func (e *EnvConfig) useTLS() bool {
+	return e.graylogHandlerType == graylog.TCP
 }
 
 func (e *EnvConfig) useColoredConsolelogs() bool {
-	envLevel := os.Getenv("THEMUSE_ENV_LEVEL")
-	// If the env level is not set use colored logs.
-	if envLevel == "0" {
-		return true
+	return e.coloredConsoleLogs
+}
+
+func (e *EnvConfig) getLevel() zapcore.Level {
+	return e.level
+}
+
+func (e *EnvConfig) getLoggerLevels() map[string]zapcore.Level {
+	return e.loggerLevels
+}
+
+func (e *EnvConfig) getStaticFields() map[string]string {
+	return e.staticFields
+}
+
+func (e *EnvConfig) getRedactRules() []string {
+	return e.redactRules
+}
+
+func (e *EnvConfig) getConsoleOptions() consoleOptions {
+	return e.consoleOptions
+}
+
+func (e *EnvConfig) getFileOptions() fileOptions {
+	return e.fileOptions
+}
+
+func (e *EnvConfig) getSyslogOptions() syslogOptions {
+	return e.syslogOptions
+}
+
+func (e *EnvConfig) getHTTPBodyOptions() httpBodyOptions {
+	return e.httpBodyOptions
+}
+
+func (e *EnvConfig) getSinks() []sinkConfig {
+	return e.sinks
+}
+
+// graylogEnabled reports whether entries are shipped to Graylog, either by
+// GRAYLOG_HOST or by a GELF sink.
+func (e *EnvConfig) graylogEnabled() bool {
+	if len(e.sinks) > 0 {
+		for _, sink := range e.sinks {
+			if isGelfSink(sink) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return e.graylogHost != ""
+}
+
+// ConfigProblem describes a single invalid or missing configuration variable.
+type ConfigProblem struct {
+	Var    string
+	Reason string
+}
+
+// ConfigError aggregates every problem found while resolving the logger
+// configuration, so that a misconfigured service can be fixed in one pass.
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *ConfigError) add(name string, reason string) {
+	e.Problems = append(e.Problems, ConfigProblem{Var: name, Reason: reason})
+}
+
+// orNil returns nil when no problems were recorded, so that callers never
+// receive a typed nil error.
+func (e *ConfigError) orNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Vars returns the names of the offending configuration variables.
+func (e *ConfigError) Vars() []string {
+	vars := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		vars = append(vars, p.Var)
+	}
+
+	return vars
+}
+
+func (e *ConfigError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		problems = append(problems, fmt.Sprintf("%s: %s", p.Var, p.Reason))
 	}
 
-	return false
+	return fmt.Sprintf("gzap: invalid configuration (%s)", strings.Join(problems, "; "))
 }
@@ -0,0 +1,478 @@
+package gzap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// FileConfig is the schema of the JSON configuration file pointed at by the
+// GZAP_CONFIG environment variable. Every setting can be overridden by its
+// environment variable, noted next to it.
+//
+//	{
+//	  "level": "info",
+//	  "loggers": {"db": "debug"},
+//	  "fields": {"team": "search"},
+//	  "redact": ["password", "*token*"],
+//	  "console": {"format": "logfmt", "time_zone": "UTC"},
+//	  "graylog": {"host": "graylog.example.com", "app_name": "app", "env": "3"}
+//	}
+type FileConfig struct {
+	Level   string            `json:"level,omitempty"`   // GZAP_LEVEL
+	Loggers map[string]string `json:"loggers,omitempty"` // GZAP_LOGGER_LEVELS
+	Fields  map[string]string `json:"fields,omitempty"`  // GZAP_FIELDS
+	Redact  []string          `json:"redact,omitempty"`  // GZAP_REDACT
+	Sinks   []string          `json:"sinks,omitempty"`   // GZAP_SINKS
+
+	Console ConsoleFileConfig `json:"console"`
+	File    FileSinkConfig    `json:"file"`
+	Syslog  SyslogFileConfig  `json:"syslog"`
+	Datadog DatadogFileConfig `json:"datadog"`
+	Graylog GraylogFileConfig `json:"graylog"`
+
+	HTTPBody HTTPBodyFileConfig `json:"http_body"`
+}
+
+// ConsoleFileConfig configures the console sink in a FileConfig.
+type ConsoleFileConfig struct {
+	JSON        *bool  `json:"json,omitempty"`         // ENABLE_DATADOG_JSON_FORMATTER
+	Color       *bool  `json:"color,omitempty"`        // GZAP_CONSOLE_COLOR
+	Format      string `json:"format,omitempty"`       // GZAP_CONSOLE_FORMAT
+	TimeFormat  string `json:"time_format,omitempty"`  // GZAP_CONSOLE_TIME_FORMAT
+	TimeZone    string `json:"time_zone,omitempty"`    // GZAP_CONSOLE_TIME_ZONE
+	Caller      string `json:"caller,omitempty"`       // GZAP_CONSOLE_CALLER
+	StderrLevel string `json:"stderr_level,omitempty"` // GZAP_CONSOLE_STDERR_LEVEL
+}
+
+// FileSinkConfig configures the rotating file sink in a FileConfig.
+type FileSinkConfig struct {
+	Path       string `json:"path,omitempty"`        // GZAP_FILE_PATH
+	MaxSize    string `json:"max_size,omitempty"`    // GZAP_FILE_MAX_SIZE
+	MaxAge     string `json:"max_age,omitempty"`     // GZAP_FILE_MAX_AGE
+	MaxBackups *int   `json:"max_backups,omitempty"` // GZAP_FILE_MAX_BACKUPS
+	Compress   *bool  `json:"compress,omitempty"`    // GZAP_FILE_COMPRESS
+	Mode       string `json:"mode,omitempty"`        // GZAP_FILE_MODE
+	Format     string `json:"format,omitempty"`      // GZAP_FILE_FORMAT
+}
+
+// SyslogFileConfig configures the syslog sink in a FileConfig.
+type SyslogFileConfig struct {
+	Address  string `json:"address,omitempty"`  // GZAP_SYSLOG_ADDRESS
+	Facility string `json:"facility,omitempty"` // GZAP_SYSLOG_FACILITY
+	SDID     string `json:"sd_id,omitempty"`    // GZAP_SYSLOG_SD_ID
+}
+
+// DatadogFileConfig holds the service tags of the datadog format in a
+// FileConfig.
+type DatadogFileConfig struct {
+	Service string `json:"service,omitempty"`  // DD_SERVICE
+	Env     string `json:"env,omitempty"`      // DD_ENV
+	Version string `json:"version,omitempty"`  // DD_VERSION
+	Host    string `json:"hostname,omitempty"` // DD_HOSTNAME
+}
+
+// GraylogFileConfig configures the Graylog sink in a FileConfig.
+type GraylogFileConfig struct {
+	Host           string `json:"host,omitempty"`             // GRAYLOG_HOST
+	AppName        string `json:"app_name,omitempty"`         // GRAYLOG_APP_NAME
+	Env            string `json:"env,omitempty"`              // GRAYLOG_ENV
+	HandlerType    string `json:"handler_type,omitempty"`     // GRAYLOG_HANDLER_TYPE
+	Port           uint   `json:"port,omitempty"`             // GRAYLOG_TLS_PORT or GRAYLOG_UDP_PORT
+	TLSTimeoutSecs *int   `json:"tls_timeout_secs,omitempty"` // GRAYLOG_TLS_TIMEOUT_SECS
+	SkipTLSVerify  *bool  `json:"skip_tls_verify,omitempty"`  // GRAYLOG_SKIP_TLS_VERIFY
+}
+
+// HTTPBodyFileConfig configures the capture of request and response bodies
+// by DatadogRequestLoggerMiddleware in a FileConfig.
+type HTTPBodyFileConfig struct {
+	MaxBytes string   `json:"max_bytes,omitempty"` // GZAP_HTTP_BODY_MAX_BYTES
+	Statuses []string `json:"statuses,omitempty"`  // GZAP_HTTP_BODY_STATUSES
+	Redact   []string `json:"redact,omitempty"`    // GZAP_HTTP_BODY_REDACT
+}
+
+// configLayer is one source of configuration values. Layers are looked up in
+// order, the first one defining a variable wins.
+type configLayer struct {
+	source string
+	vars   map[string]string
+
+	// maps and lists hold the structured values of a configuration file, which
+	// the environment expresses as comma separated strings.
+	maps  map[string]map[string]string
+	lists map[string][]string
+}
+
+func envLayer() configLayer {
+	vars := map[string]string{}
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	return configLayer{source: "env", vars: vars}
+}
+
+func envFileLayer(path string) (configLayer, error) {
+	vars, err := readEnvFile(path)
+	if err != nil {
+		return configLayer{}, err
+	}
+
+	return configLayer{source: "env file " + path, vars: vars}, nil
+}
+
+// NewConfig resolves and validates a configuration from fc alone, ignoring the
+// environment. It is meant for loggers built by New, and tests.
+func NewConfig(fc FileConfig) (*EnvConfig, error) {
+	return newEnvConfig(newConfigResolver(fileConfigLayer("config", fc)))
+}
+
+// LoadConfigFile resolves and validates the configuration file at path alone,
+// ignoring the environment. It is meant for loggers built by New.
+func LoadConfigFile(path string) (*EnvConfig, error) {
+	return newEnvConfig(configSource{file: path}.resolver())
+}
+
+// configSource is where a configuration was read from, so that Handle.Watch
+// can read it again. Configurations built by NewConfig have none.
+type configSource struct {
+	// env is set for configurations read from the environment, along with
+	// the GZAP_CONFIG file it names.
+	env bool
+
+	// file is the configuration file read by LoadConfigFile.
+	file string
+}
+
+// resolver reads the source again. The extra layers, e.g. the env file of
+// WatchOptions, take precedence over a configuration file but not over the
+// environment.
+func (s configSource) resolver(extra ...configLayer) *configResolver {
+	if s.env {
+		r := newConfigResolver(append([]configLayer{envLayer()}, extra...)...)
+		r.source = s
+		return r
+	}
+
+	layer, err := fileLayer(s.file)
+	if err != nil {
+		r := newConfigResolver(extra...)
+		r.errs.add("GZAP_CONFIG", err.Error())
+		r.source = s
+		return r
+	}
+
+	r := newConfigResolver(append(extra, layer)...)
+	r.source = s
+	return r
+}
+
+// fileLayer reads the configuration file at path.
+func fileLayer(path string) (configLayer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return configLayer{}, err
+	}
+
+	var fc FileConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return configLayer{}, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+
+	return fileConfigLayer("file "+path, fc), nil
+}
+
+// fileConfigLayer maps the settings of fc onto their environment variables.
+func fileConfigLayer(source string, fc FileConfig) configLayer {
+	layer := configLayer{
+		source: source,
+		vars:   map[string]string{},
+		maps:   map[string]map[string]string{},
+		lists:  map[string][]string{},
+	}
+
+	set := func(name string, value string) {
+		if value != "" {
+			layer.vars[name] = value
+		}
+	}
+	setBool := func(name string, value *bool) {
+		if value != nil {
+			layer.vars[name] = strconv.FormatBool(*value)
+		}
+	}
+
+	set("GZAP_LEVEL", fc.Level)
+	if fc.Loggers != nil {
+		layer.maps["GZAP_LOGGER_LEVELS"] = fc.Loggers
+	}
+	if fc.Fields != nil {
+		layer.maps["GZAP_FIELDS"] = fc.Fields
+	}
+	if fc.Redact != nil {
+		layer.lists["GZAP_REDACT"] = fc.Redact
+	}
+	if fc.Sinks != nil {
+		layer.lists["GZAP_SINKS"] = fc.Sinks
+	}
+
+	setBool("ENABLE_DATADOG_JSON_FORMATTER", fc.Console.JSON)
+	setBool("GZAP_CONSOLE_COLOR", fc.Console.Color)
+	set("GZAP_CONSOLE_FORMAT", fc.Console.Format)
+	set("GZAP_CONSOLE_TIME_FORMAT", fc.Console.TimeFormat)
+	set("GZAP_CONSOLE_TIME_ZONE", fc.Console.TimeZone)
+	set("GZAP_CONSOLE_CALLER", fc.Console.Caller)
+	set("GZAP_CONSOLE_STDERR_LEVEL", fc.Console.StderrLevel)
+
+	set("GZAP_FILE_PATH", fc.File.Path)
+	set("GZAP_FILE_MAX_SIZE", fc.File.MaxSize)
+	set("GZAP_FILE_MAX_AGE", fc.File.MaxAge)
+	if fc.File.MaxBackups != nil {
+		set("GZAP_FILE_MAX_BACKUPS", strconv.Itoa(*fc.File.MaxBackups))
+	}
+	setBool("GZAP_FILE_COMPRESS", fc.File.Compress)
+	set("GZAP_FILE_MODE", fc.File.Mode)
+	set("GZAP_FILE_FORMAT", fc.File.Format)
+
+	set("GZAP_SYSLOG_ADDRESS", fc.Syslog.Address)
+	set("GZAP_SYSLOG_FACILITY", fc.Syslog.Facility)
+	set("GZAP_SYSLOG_SD_ID", fc.Syslog.SDID)
+
+	set("DD_SERVICE", fc.Datadog.Service)
+	set("DD_ENV", fc.Datadog.Env)
+	set("DD_VERSION", fc.Datadog.Version)
+	set("DD_HOSTNAME", fc.Datadog.Host)
+
+	set("GRAYLOG_HOST", fc.Graylog.Host)
+	set("GRAYLOG_APP_NAME", fc.Graylog.AppName)
+	set("GRAYLOG_ENV", fc.Graylog.Env)
+	set("GRAYLOG_HANDLER_TYPE", fc.Graylog.HandlerType)
+	if fc.Graylog.Port != 0 {
+		set("GRAYLOG_TLS_PORT", strconv.FormatUint(uint64(fc.Graylog.Port), 10))
+		set("GRAYLOG_UDP_PORT", strconv.FormatUint(uint64(fc.Graylog.Port), 10))
+	}
+	if fc.Graylog.TLSTimeoutSecs != nil {
+		set("GRAYLOG_TLS_TIMEOUT_SECS", strconv.Itoa(*fc.Graylog.TLSTimeoutSecs))
+	}
+	setBool("GRAYLOG_SKIP_TLS_VERIFY", fc.Graylog.SkipTLSVerify)
+
+	set("GZAP_HTTP_BODY_MAX_BYTES", fc.HTTPBody.MaxBytes)
+	if fc.HTTPBody.Statuses != nil {
+		layer.lists["GZAP_HTTP_BODY_STATUSES"] = fc.HTTPBody.Statuses
+	}
+	if fc.HTTPBody.Redact != nil {
+		layer.lists["GZAP_HTTP_BODY_REDACT"] = fc.HTTPBody.Redact
+	}
+
+	return layer
+}
+
+// configResolver looks up configuration variables through its layers, and
+// records where every value came from.
+type configResolver struct {
+	layers   []configLayer
+	errs     *ConfigError
+	settings []ConfigSetting
+
+	// skipUnknownSinks drops the sinks whose scheme is not registered
+	// instead of reporting them, see newInitConfig.
+	skipUnknownSinks bool
+
+	// source is where the layers were read from, see configSource.
+	source configSource
+}
+
+// newConfigResolver adds the configuration file named by GZAP_CONFIG, if any,
+// below the given layers.
+func newConfigResolver(layers ...configLayer) *configResolver {
+	r := &configResolver{layers: layers, errs: &ConfigError{}}
+
+	if path, _ := r.lookup("GZAP_CONFIG"); path != "" {
+		layer, err := fileLayer(path)
+		if err != nil {
+			r.errs.add("GZAP_CONFIG", err.Error())
+		} else {
+			r.layers = append(r.layers, layer)
+		}
+	}
+
+	return r
+}
+
+func (r *configResolver) record(name string, value string, source string) {
+	switch {
+	case isSecretVar(name) && value != "":
+		value = "******"
+	case name == "GZAP_SINKS":
+		value = redactSinkURLs(value)
+	}
+
+	r.settings = append(r.settings, ConfigSetting{Var: name, Value: value, Source: source})
+}
+
+// lookup returns the value of a variable and its source, without recording
+// it in the report.
+func (r *configResolver) lookup(name string) (string, string) {
+	for _, layer := range r.layers {
+		if value := layer.vars[name]; value != "" {
+			return value, layer.source
+		}
+	}
+
+	return "", "default"
+}
+
+// get returns the value of a variable, or "" when no layer sets it.
+func (r *configResolver) get(name string) string {
+	value, source := r.lookup(name)
+	r.record(name, value, source)
+
+	return value
+}
+
+// getMap returns a map variable, set either as a comma separated list of
+// key=value pairs, or as an object in a configuration file.
+func (r *configResolver) getMap(name string) map[string]string {
+	for _, layer := range r.layers {
+		if m, ok := layer.maps[name]; ok {
+			r.record(name, formatMap(m), layer.source)
+			return m
+		}
+
+		if value := layer.vars[name]; value != "" {
+			r.record(name, value, layer.source)
+			m, err := parseMap(value)
+			if err != nil {
+				r.errs.add(name, err.Error())
+			}
+			return m
+		}
+	}
+
+	r.record(name, "", "default")
+	return map[string]string{}
+}
+
+// getList returns a list variable, set either as a comma separated list, or
+// as an array in a configuration file.
+func (r *configResolver) getList(name string) []string {
+	for _, layer := range r.layers {
+		if list, ok := layer.lists[name]; ok {
+			r.record(name, strings.Join(list, ","), layer.source)
+			return list
+		}
+
+		if value := layer.vars[name]; value != "" {
+			r.record(name, value, layer.source)
+			return parseList(value)
+		}
+	}
+
+	r.record(name, "", "default")
+	return nil
+}
+
+func (r *configResolver) report() ConfigReport {
+	return ConfigReport{
+		Settings: r.settings,
+		Problems: r.errs.Problems,
+	}
+}
+
+func parseList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func parseMap(value string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range parseList(value) {
+		i := strings.IndexByte(pair, '=')
+		if i <= 0 {
+			return m, fmt.Errorf("expected a comma separated list of key=value pairs, got %q", pair)
+		}
+		m[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+
+	return m, nil
+}
+
+func formatMap(m map[string]string) string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// isSecretVar reports whether the value of a variable must not be displayed.
+func isSecretVar(name string) bool {
+	for _, marker := range []string{"TOKEN", "PASSWORD", "SECRET", "API_KEY", "DSN"} {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConfigSetting describes the resolved value of a configuration variable, and
+// where it came from: "env", "env file <path>", "file <path>" or "default".
+type ConfigSetting struct {
+	Var    string
+	Value  string
+	Source string
+}
+
+// ConfigReport describes how a configuration was resolved, for debugging.
+// Secret values are masked.
+type ConfigReport struct {
+	Settings []ConfigSetting
+	Problems []ConfigProblem
+}
+
+// values maps every variable to its value.
+func (r ConfigReport) values() map[string]string {
+	values := make(map[string]string, len(r.Settings))
+	for _, s := range r.Settings {
+		values[s.Var] = s.Value
+	}
+
+	return values
+}
+
+// String formats the report as a table, followed by its problems.
+func (r ConfigReport) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "VARIABLE\tVALUE\tSOURCE")
+	for _, s := range r.Settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Var, s.Value, s.Source)
+	}
+	w.Flush()
+
+	for _, p := range r.Problems {
+		fmt.Fprintf(&buf, "invalid %s: %s\n", p.Var, p.Reason)
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,426 @@
+package gzap
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	graylog "github.com/Devatoria/go-graylog"
+	"go.uber.org/zap/zapcore"
+)
+
+var configEnvVars = []string{
+	"DD_ENV",
+	"DD_HOSTNAME",
+	"DD_SERVICE",
+	"DD_VERSION",
+	"ENABLE_DATADOG_JSON_FORMATTER",
+	"GRAYLOG_APP_NAME",
+	"GRAYLOG_ENV",
+	"GRAYLOG_HANDLER_TYPE",
+	"GRAYLOG_HOST",
+	"GRAYLOG_SKIP_TLS_VERIFY",
+	"GRAYLOG_TLS_PORT",
+	"GRAYLOG_TLS_TIMEOUT_SECS",
+	"GRAYLOG_UDP_PORT",
+	"GZAP_CONFIG",
+	"GZAP_CONSOLE_CALLER",
+	"GZAP_CONSOLE_COLOR",
+	"GZAP_CONSOLE_FORMAT",
+	"GZAP_CONSOLE_STDERR_LEVEL",
+	"GZAP_CONSOLE_TIME_FORMAT",
+	"GZAP_CONSOLE_TIME_ZONE",
+	"GZAP_FIELDS",
+	"GZAP_FILE_COMPRESS",
+	"GZAP_FILE_FORMAT",
+	"GZAP_FILE_MAX_AGE",
+	"GZAP_FILE_MAX_BACKUPS",
+	"GZAP_FILE_MAX_SIZE",
+	"GZAP_FILE_MODE",
+	"GZAP_FILE_PATH",
+	"GZAP_HTTP_BODY_MAX_BYTES",
+	"GZAP_HTTP_BODY_REDACT",
+	"GZAP_HTTP_BODY_STATUSES",
+	"GZAP_SYSLOG_ADDRESS",
+	"GZAP_SYSLOG_FACILITY",
+	"GZAP_SYSLOG_SD_ID",
+	"GZAP_LEVEL",
+	"GZAP_LOGGER_LEVELS",
+	"GZAP_REDACT",
+	"GZAP_SINKS",
+	"THEMUSE_ENV_LEVEL",
+}
+
+// setEnv replaces the gzap configuration variables with env for the duration
+// of a test, and returns a func restoring the previous values.
+func setEnv(env map[string]string) func() {
+	previous := map[string]string{}
+	for _, name := range configEnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			previous[name] = value
+		}
+		os.Unsetenv(name)
+	}
+
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+
+	return func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+		for name, value := range previous {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+func TestNewEnvConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantVars []string
+		check    func(t *testing.T, cfg *EnvConfig)
+	}{
+		{
+			"NewEnvConfig should succeed without Graylog configured",
+			map[string]string{},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getGraylogHandlerType(), graylog.TCP)
+				expect(t, cfg.getGraylogPort(), uint(defaultGraylogPort))
+				expect(t, cfg.getGraylogTLSTimeout(), defaultGraylogTLSTimeout)
+			},
+		},
+		{
+			"NewEnvConfig should resolve a complete UDP configuration",
+			map[string]string{
+				"GRAYLOG_HOST":         "graylog.example.com",
+				"GRAYLOG_APP_NAME":     "app",
+				"GRAYLOG_ENV":          "3",
+				"GRAYLOG_HANDLER_TYPE": "udp",
+				"GRAYLOG_UDP_PORT":     "5555",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getGraylogHandlerType(), graylog.UDP)
+				expect(t, cfg.getGraylogPort(), uint(5555))
+				expect(t, cfg.getGraylogAppName(), "app")
+				expect(t, cfg.useTLS(), false)
+			},
+		},
+		{
+			"NewEnvConfig should resolve a complete TLS configuration",
+			map[string]string{
+				"GRAYLOG_HOST":             "graylog.example.com",
+				"GRAYLOG_APP_NAME":         "app",
+				"GRAYLOG_ENV":              "2",
+				"GRAYLOG_HANDLER_TYPE":     "tls",
+				"GRAYLOG_TLS_PORT":         "12202",
+				"GRAYLOG_TLS_TIMEOUT_SECS": "10",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getGraylogPort(), uint(12202))
+				expect(t, cfg.getGraylogTLSTimeout(), time.Second*10)
+				expect(t, cfg.useTLS(), true)
+			},
+		},
+		{
+			"NewEnvConfig should resolve the console options",
+			map[string]string{
+				"ENABLE_DATADOG_JSON_FORMATTER": "true",
+				"GZAP_CONSOLE_FORMAT":           "logfmt",
+				"GZAP_CONSOLE_TIME_FORMAT":      "15:04:05",
+				"GZAP_CONSOLE_TIME_ZONE":        "UTC",
+				"GZAP_CONSOLE_CALLER":           "none",
+				"GZAP_CONSOLE_STDERR_LEVEL":     "none",
+				"GZAP_CONSOLE_COLOR":            "false",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				opts := cfg.getConsoleOptions()
+				expect(t, opts.format, consoleFormatLogfmt)
+				expect(t, opts.timeFormat, "15:04:05")
+				expect(t, opts.timeZone, time.UTC)
+				expect(t, opts.caller, consoleCallerNone)
+				expect(t, opts.stderrLevel, noStderrLevel)
+				expect(t, cfg.useColoredConsolelogs(), false)
+			},
+		},
+		{
+			"NewEnvConfig should report invalid console options",
+			map[string]string{
+				"GZAP_CONSOLE_FORMAT":       "xml",
+				"GZAP_CONSOLE_TIME_FORMAT":  "iso",
+				"GZAP_CONSOLE_TIME_ZONE":    "Mars/Olympus_Mons",
+				"GZAP_CONSOLE_CALLER":       "long",
+				"GZAP_CONSOLE_STDERR_LEVEL": "loud",
+				"GZAP_CONSOLE_COLOR":        "sometimes",
+			},
+			[]string{
+				"GZAP_CONSOLE_COLOR",
+				"GZAP_CONSOLE_FORMAT",
+				"GZAP_CONSOLE_TIME_FORMAT",
+				"GZAP_CONSOLE_TIME_ZONE",
+				"GZAP_CONSOLE_CALLER",
+				"GZAP_CONSOLE_STDERR_LEVEL",
+			},
+			func(t *testing.T, cfg *EnvConfig) {
+				want := defaultConsoleOptions()
+				want.datadog.host, _ = os.Hostname()
+				expect(t, cfg.getConsoleOptions(), want)
+			},
+		},
+		{
+			"NewEnvConfig should default to the datadog format when ENABLE_DATADOG_JSON_FORMATTER is set",
+			map[string]string{
+				"ENABLE_DATADOG_JSON_FORMATTER": "true",
+				"GRAYLOG_APP_NAME":              "my-app",
+				"DD_ENV":                        "prod",
+				"DD_VERSION":                    "1.2.3",
+				"DD_HOSTNAME":                   "web-1",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				opts := cfg.getConsoleOptions()
+				expect(t, opts.format, consoleFormatDatadog)
+				expect(t, opts.datadog, datadogOptions{service: "my-app", env: "prod", version: "1.2.3", host: "web-1"})
+			},
+		},
+		{
+			"NewEnvConfig should prefer DD_SERVICE over the app name",
+			map[string]string{
+				"GRAYLOG_APP_NAME": "my-app",
+				"DD_SERVICE":       "my-service",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getConsoleOptions().datadog.service, "my-service")
+			},
+		},
+		{
+			"NewEnvConfig should resolve the file options",
+			map[string]string{
+				"GZAP_FILE_PATH":        "/var/log/app/app.log",
+				"GZAP_FILE_MAX_SIZE":    "10MB",
+				"GZAP_FILE_MAX_AGE":     "24h",
+				"GZAP_FILE_MAX_BACKUPS": "3",
+				"GZAP_FILE_COMPRESS":    "true",
+				"GZAP_FILE_MODE":        "0640",
+				"GZAP_FILE_FORMAT":      "logfmt",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getFileOptions(), fileOptions{
+					path:       "/var/log/app/app.log",
+					maxSize:    10 * 1024 * 1024,
+					maxAge:     24 * time.Hour,
+					maxBackups: 3,
+					compress:   true,
+					mode:       0640,
+					format:     consoleFormatLogfmt,
+				})
+			},
+		},
+		{
+			"NewEnvConfig should report invalid file options",
+			map[string]string{
+				"GZAP_FILE_PATH":        "/var/log/app/app.log",
+				"GZAP_FILE_MAX_SIZE":    "huge",
+				"GZAP_FILE_MAX_AGE":     "a week",
+				"GZAP_FILE_MAX_BACKUPS": "-1",
+				"GZAP_FILE_MODE":        "rw-r--r--",
+				"GZAP_FILE_FORMAT":      "xml",
+			},
+			[]string{
+				"GZAP_FILE_MAX_SIZE",
+				"GZAP_FILE_MAX_AGE",
+				"GZAP_FILE_MAX_BACKUPS",
+				"GZAP_FILE_MODE",
+				"GZAP_FILE_FORMAT",
+			},
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getFileOptions(), fileOptions{
+					path:       "/var/log/app/app.log",
+					maxSize:    defaultFileMaxSize,
+					maxBackups: defaultFileMaxBackups,
+					mode:       defaultFileMode,
+					format:     consoleFormatJSON,
+				})
+			},
+		},
+		{
+			"NewEnvConfig should resolve the syslog options",
+			map[string]string{
+				"GZAP_SYSLOG_ADDRESS":  "tcp://rsyslog.example.com",
+				"GZAP_SYSLOG_FACILITY": "local3",
+				"GZAP_SYSLOG_SD_ID":    "gzap@12345",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getSyslogOptions(), syslogOptions{
+					network:  "tcp",
+					address:  "rsyslog.example.com:601",
+					facility: 19,
+					sdID:     "gzap@12345",
+				})
+			},
+		},
+		{
+			"NewEnvConfig should report invalid syslog options",
+			map[string]string{
+				"GZAP_SYSLOG_ADDRESS":  "http://rsyslog.example.com",
+				"GZAP_SYSLOG_FACILITY": "local9",
+				"GZAP_SYSLOG_SD_ID":    "fields",
+			},
+			[]string{
+				"GZAP_SYSLOG_ADDRESS",
+				"GZAP_SYSLOG_FACILITY",
+				"GZAP_SYSLOG_SD_ID",
+			},
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getSyslogOptions(), syslogOptions{facility: 1, sdID: defaultSyslogSDID})
+			},
+		},
+		{
+			"NewEnvConfig should disable the body capture by default",
+			map[string]string{},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getHTTPBodyOptions().maxBytes, 0)
+			},
+		},
+		{
+			"NewEnvConfig should resolve the body capture options",
+			map[string]string{
+				"GZAP_HTTP_BODY_MAX_BYTES": "4KB",
+				"GZAP_HTTP_BODY_STATUSES":  "5XX,409",
+				"GZAP_HTTP_BODY_REDACT":    "password,$.user.Token,items[*].card",
+				"GZAP_REDACT":              "*Secret*",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				want := httpBodyOptions{
+					maxBytes: 4096,
+					statuses: []string{"5xx", "409"},
+					redact:   [][]string{{"password"}, {"user", "token"}, {"items", "card"}},
+					keys:     redactRules{"*secret*"},
+				}
+				if got := cfg.getHTTPBodyOptions(); !reflect.DeepEqual(got, want) {
+					t.Errorf("getHTTPBodyOptions() = %+v, want %+v", got, want)
+				}
+			},
+		},
+		{
+			"NewEnvConfig should report invalid body capture options",
+			map[string]string{
+				"GZAP_HTTP_BODY_MAX_BYTES": "lots",
+				"GZAP_HTTP_BODY_STATUSES":  "4xx,6xx,error",
+				"GZAP_HTTP_BODY_REDACT":    "user..password,items[0]",
+			},
+			[]string{
+				"GZAP_HTTP_BODY_MAX_BYTES",
+				"GZAP_HTTP_BODY_STATUSES",
+				"GZAP_HTTP_BODY_STATUSES",
+				"GZAP_HTTP_BODY_REDACT",
+				"GZAP_HTTP_BODY_REDACT",
+			},
+			func(t *testing.T, cfg *EnvConfig) {
+				opts := cfg.getHTTPBodyOptions()
+				expect(t, opts.maxBytes, 0)
+				expect(t, len(opts.statuses), 1)
+				expect(t, len(opts.redact), 0)
+			},
+		},
+		{
+			"NewEnvConfig should resolve the sinks",
+			map[string]string{
+				"GZAP_SINKS":       "gelf+udp://graylog:12201?level=warn,file:///var/log/app.log?rotate=100MB,stdout?format=json",
+				"GRAYLOG_APP_NAME": "my-app",
+				"GRAYLOG_ENV":      "3",
+			},
+			nil,
+			func(t *testing.T, cfg *EnvConfig) {
+				sinks := cfg.getSinks()
+				expect(t, len(sinks), 3)
+				expect(t, sinks[0].url, "gelf+udp://graylog:12201?level=warn")
+				expect(t, sinks[0].level, zapcore.WarnLevel)
+				expect(t, sinks[1].scheme, "file")
+				expect(t, sinks[1].level, zapcore.DebugLevel)
+				expect(t, sinks[2].scheme, "stdout")
+
+				// Shipping to Graylog keeps the debug default, Graylog only
+				// receiving info and above, as with GRAYLOG_HOST.
+				expect(t, cfg.getLevel(), zapcore.DebugLevel)
+				expect(t, cfg.levelConfigured, false)
+			},
+		},
+		{
+			"NewEnvConfig should report invalid sinks",
+			map[string]string{
+				"GZAP_SINKS": "kafka://broker:9092,stdout?level=loud,file:///var/log/app.log?rotat=100MB,gelf+tls://graylog",
+			},
+			[]string{
+				"GZAP_SINKS",
+				"GZAP_SINKS",
+				"GZAP_SINKS",
+				"GRAYLOG_APP_NAME",
+				"GRAYLOG_ENV",
+			},
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, len(cfg.getSinks()), 1)
+			},
+		},
+		{
+			"NewEnvConfig should report every problem at once",
+			map[string]string{
+				"GRAYLOG_HOST":             "graylog.example.com",
+				"GRAYLOG_HANDLER_TYPE":     "carrier-pigeon",
+				"GRAYLOG_TLS_PORT":         "not-a-port",
+				"GRAYLOG_TLS_TIMEOUT_SECS": "soon",
+			},
+			[]string{
+				"GRAYLOG_HANDLER_TYPE",
+				"GRAYLOG_TLS_PORT",
+				"GRAYLOG_TLS_TIMEOUT_SECS",
+				"GRAYLOG_APP_NAME",
+				"GRAYLOG_ENV",
+			},
+			func(t *testing.T, cfg *EnvConfig) {
+				expect(t, cfg.getGraylogPort(), uint(defaultGraylogPort))
+				expect(t, cfg.getGraylogTLSTimeout(), defaultGraylogTLSTimeout)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restore := setEnv(tt.env)
+			defer restore()
+
+			cfg, err := NewEnvConfig()
+			if cfg == nil {
+				t.Fatal("NewEnvConfig() returned a nil config")
+			}
+
+			if tt.wantVars == nil && err != nil {
+				t.Fatalf("NewEnvConfig() expected error = \"nil\"; got \"%v\"", err)
+			}
+
+			if tt.wantVars != nil {
+				configErr, ok := err.(*ConfigError)
+				if !ok {
+					t.Fatalf("NewEnvConfig() expected a *ConfigError; got \"%v\"", err)
+				}
+
+				if !reflect.DeepEqual(configErr.Vars(), tt.wantVars) {
+					t.Errorf("NewEnvConfig() expected vars = %v; got %v", tt.wantVars, configErr.Vars())
+				}
+			}
+
+			tt.check(t, cfg)
+		})
+	}
+}
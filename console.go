@@ -0,0 +1,134 @@
+package gzap
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Console formats, see GZAP_CONSOLE_FORMAT.
+const (
+	consoleFormatConsole = "console"
+	consoleFormatJSON    = "json"
+	consoleFormatLogfmt  = "logfmt"
+	consoleFormatDatadog = "datadog"
+)
+
+// Caller formats, see GZAP_CONSOLE_CALLER.
+const (
+	consoleCallerShort = "short"
+	consoleCallerFull  = "full"
+	consoleCallerNone  = "none"
+)
+
+// noStderrLevel sends every entry to stdout.
+const noStderrLevel = zapcore.FatalLevel + 1
+
+// consoleOptions configures the console output.
+type consoleOptions struct {
+	format     string
+	timeFormat string
+	timeZone   *time.Location
+	caller     string
+
+	// stderrLevel is the level from which entries are written to stderr
+	// rather than stdout.
+	stderrLevel zapcore.Level
+
+	// datadog are the service tags written by the datadog format.
+	datadog datadogOptions
+}
+
+func defaultConsoleOptions() consoleOptions {
+	return consoleOptions{
+		format:      consoleFormatConsole,
+		timeFormat:  "iso8601",
+		caller:      consoleCallerShort,
+		stderrLevel: zapcore.ErrorLevel,
+	}
+}
+
+// namedTimeEncoders are the time formats known by name. Any other format is a
+// Go time layout.
+var namedTimeEncoders = map[string]zapcore.TimeEncoder{
+	"iso8601":      zapcore.ISO8601TimeEncoder,
+	"rfc3339":      layoutTimeEncoder(time.RFC3339),
+	"rfc3339nano":  layoutTimeEncoder(time.RFC3339Nano),
+	"epoch":        zapcore.EpochTimeEncoder,
+	"epoch_millis": zapcore.EpochMillisTimeEncoder,
+	"epoch_nanos":  zapcore.EpochNanosTimeEncoder,
+}
+
+func layoutTimeEncoder(layout string) zapcore.TimeEncoder {
+	return func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		enc.AppendString(t.Format(layout))
+	}
+}
+
+// isTimeLayout reports whether layout contains any element of a Go time
+// layout, so that misspelled format names are caught.
+func isTimeLayout(layout string) bool {
+	reference := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+	return reference.Format(layout) != layout
+}
+
+// newConsoleEncoder builds the encoder of the console output described by
+// opts.
+func newConsoleEncoder(opts consoleOptions, colored bool) zapcore.Encoder {
+	encoderConfig := zap.NewDevelopmentEncoderConfig()
+
+	if opts.format == consoleFormatLogfmt {
+		encoderConfig.TimeKey = "time"
+		encoderConfig.LevelKey = "level"
+		encoderConfig.NameKey = "logger"
+		encoderConfig.CallerKey = "caller"
+		encoderConfig.MessageKey = "msg"
+		encoderConfig.StacktraceKey = "stacktrace"
+		encoderConfig.EncodeLevel = zapcore.LowercaseLevelEncoder
+	}
+
+	// Escape sequences would end up in the values of structured formats.
+	if colored && opts.format == consoleFormatConsole {
+		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+
+	encodeTime, ok := namedTimeEncoders[opts.timeFormat]
+	if opts.timeFormat == "" {
+		encodeTime = zapcore.ISO8601TimeEncoder
+	} else if !ok {
+		encodeTime = layoutTimeEncoder(opts.timeFormat)
+	}
+	if opts.timeZone != nil {
+		encodeTime = inTimeZone(encodeTime, opts.timeZone)
+	}
+	encoderConfig.EncodeTime = encodeTime
+
+	switch opts.caller {
+	case consoleCallerFull:
+		encoderConfig.EncodeCaller = zapcore.FullCallerEncoder
+	case consoleCallerNone:
+		encoderConfig.CallerKey = ""
+	default:
+		encoderConfig.EncodeCaller = zapcore.ShortCallerEncoder
+	}
+
+	switch opts.format {
+	case consoleFormatDatadog:
+		// The time format and zone don't apply, as Datadog expects epoch
+		// milliseconds.
+		return newDatadogEncoder(opts.datadog, opts.caller != consoleCallerNone)
+	case consoleFormatJSON:
+		return zapcore.NewJSONEncoder(encoderConfig)
+	case consoleFormatLogfmt:
+		return newLogfmtEncoder(encoderConfig)
+	}
+
+	return zapcore.NewConsoleEncoder(encoderConfig)
+}
+
+func inTimeZone(encode zapcore.TimeEncoder, location *time.Location) zapcore.TimeEncoder {
+	return func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		encode(t.In(location), enc)
+	}
+}
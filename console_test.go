@@ -0,0 +1,142 @@
+package gzap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNewConsoleEncoder(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	entry := zapcore.Entry{
+		Level:   zapcore.WarnLevel,
+		Time:    time.Date(2018, time.January, 2, 15, 4, 5, 0, time.UTC),
+		Message: "disk almost full",
+		Caller:  zapcore.NewEntryCaller(0, "/go/src/app/disk/disk.go", 42, true),
+	}
+
+	tests := []struct {
+		name    string
+		opts    func(opts *consoleOptions)
+		colored bool
+		want    string
+	}{
+		{
+			"newConsoleEncoder should default to the development console format",
+			func(opts *consoleOptions) {},
+			false,
+			"2018-01-02T15:04:05.000Z\tWARN\tdisk/disk.go:42\tdisk almost full\t{\"disk\": \"/dev/sda1\"}\n",
+		},
+		{
+			"newConsoleEncoder should color levels",
+			func(opts *consoleOptions) {},
+			true,
+			"2018-01-02T15:04:05.000Z\t\x1b[33mWARN\x1b[0m\tdisk/disk.go:42\tdisk almost full\t{\"disk\": \"/dev/sda1\"}\n",
+		},
+		{
+			"newConsoleEncoder should not color structured formats",
+			func(opts *consoleOptions) {
+				opts.format = consoleFormatJSON
+				opts.timeFormat = "epoch"
+				opts.caller = consoleCallerFull
+			},
+			true,
+			`{"L":"WARN","T":1514905445,"C":"/go/src/app/disk/disk.go:42","M":"disk almost full","disk":"/dev/sda1"}` + "\n",
+		},
+		{
+			"newConsoleEncoder should apply the time format and zone",
+			func(opts *consoleOptions) {
+				opts.format = consoleFormatLogfmt
+				opts.timeFormat = time.RFC1123Z
+				opts.timeZone = newYork
+				opts.caller = consoleCallerNone
+			},
+			false,
+			`time="Tue, 02 Jan 2018 10:04:05 -0500" level=warn msg="disk almost full" disk=/dev/sda1` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultConsoleOptions()
+			tt.opts(&opts)
+
+			buf, err := newConsoleEncoder(opts, tt.colored).EncodeEntry(entry, []zapcore.Field{String("disk", "/dev/sda1")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expect(t, buf.String(), tt.want)
+		})
+	}
+}
+
+func TestNewConsoleCore_StderrLevel(t *testing.T) {
+	tests := []struct {
+		name        string
+		stderrLevel zapcore.Level
+		wantStdout  []string
+		wantStderr  []string
+	}{
+		{
+			"newConsoleCore should write errors to stderr by default",
+			zapcore.ErrorLevel,
+			[]string{"debug", "warn"},
+			[]string{"error"},
+		},
+		{
+			"newConsoleCore should write warnings to stderr",
+			zapcore.WarnLevel,
+			[]string{"debug"},
+			[]string{"warn", "error"},
+		},
+		{
+			"newConsoleCore should write everything to stdout",
+			noStderrLevel,
+			[]string{"debug", "warn", "error"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultConsoleOptions()
+			opts.format = consoleFormatLogfmt
+			opts.caller = consoleCallerNone
+			opts.timeFormat = "epoch"
+			opts.stderrLevel = tt.stderrLevel
+
+			cfg := MockEnvConfig{}
+			cfg.On("getConsoleOptions").Return(opts)
+			cfg.On("useColoredConsolelogs").Return(false)
+			cfg.On("getRedactRules").Return([]string{})
+
+			var stdout, stderr bytes.Buffer
+			logger := zap.New(newConsoleCore(&cfg, zapcore.AddSync(&stdout), zapcore.AddSync(&stderr)))
+			logger.Debug("debug")
+			logger.Warn("warn")
+			logger.Error("error")
+
+			expect(t, messages(stdout.String()), strings.Join(tt.wantStdout, ","))
+			expect(t, messages(stderr.String()), strings.Join(tt.wantStderr, ","))
+		})
+	}
+}
+
+// messages lists the msg values of logfmt lines.
+func messages(output string) string {
+	msgs := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if i := strings.Index(line, "msg="); i >= 0 {
+			msgs = append(msgs, line[i+len("msg="):])
+		}
+	}
+
+	return strings.Join(msgs, ",")
+}
@@ -0,0 +1,88 @@
+package gzap
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// contextKey is the key under which WithContext stores its fields.
+type contextKey struct{}
+
+// contextFields are the fields carried by a context, along with the last
+// logger built from them.
+type contextFields struct {
+	fields []zapcore.Field
+	cached atomic.Value // *contextLogger
+}
+
+// contextLogger caches a global logger with the context fields applied.
+type contextLogger struct {
+	base   *zap.Logger
+	logger *zap.Logger
+}
+
+// WithContext returns a copy of ctx carrying fields, in addition to the fields
+// already carried by ctx. Loggers returned by FromContext add them to every
+// entry:
+//
+//	ctx = gzap.WithContext(ctx, gzap.String("user_id", userID))
+//	gzap.Ctx(ctx).Info("profile updated")
+func WithContext(ctx context.Context, fields ...zapcore.Field) context.Context {
+	var existing []zapcore.Field
+	if carried, ok := ctx.Value(contextKey{}).(*contextFields); ok {
+		existing = carried.fields
+	}
+
+	combined := make([]zapcore.Field, 0, len(existing)+len(fields))
+	combined = append(combined, existing...)
+	combined = append(combined, fields...)
+
+	return context.WithValue(ctx, contextKey{}, &contextFields{fields: combined})
+}
+
+// FromContext returns the global logger with the fields carried by ctx, see
+// WithContext, and the IDs of the traces it carries, see WithTrace and
+// WithTraceContext. It returns the global logger itself when ctx carries none
+// of them.
+func FromContext(ctx context.Context) *zap.Logger {
+	logger := withContextFields(ctx)
+
+	// The span changes more often than the fields, so that the trace isn't
+	// part of the cached logger.
+	if fields := TraceFields(ctx); fields != nil {
+		return logger.With(fields...)
+	}
+
+	return logger
+}
+
+// withContextFields returns the global logger with the fields carried by ctx.
+func withContextFields(ctx context.Context) *zap.Logger {
+	base := L()
+	if ctx == nil {
+		return base
+	}
+
+	carried, ok := ctx.Value(contextKey{}).(*contextFields)
+	if !ok {
+		return base
+	}
+
+	// The global logger may have been replaced since the last call.
+	if c, ok := carried.cached.Load().(*contextLogger); ok && c.base == base {
+		return c.logger
+	}
+
+	logger := base.With(carried.fields...)
+	carried.cached.Store(&contextLogger{base: base, logger: logger})
+
+	return logger
+}
+
+// Ctx is a shorthand for FromContext.
+func Ctx(ctx context.Context) *zap.Logger {
+	return FromContext(ctx)
+}
@@ -0,0 +1,56 @@
+package gzap
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFromContext(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	undo := ReplaceGlobals(zap.New(core))
+	defer undo()
+
+	expect(t, FromContext(context.Background()), L())
+	expect(t, FromContext(nil), L())
+
+	ctx := WithContext(context.Background(), String("tenant", "acme"))
+	ctx = WithContext(ctx, String("user_id", "42"))
+	sibling := WithContext(ctx, String("layer", "sibling"))
+
+	Ctx(ctx).Info("accumulated")
+	Ctx(sibling).Info("sibling")
+	Ctx(ctx).Info("unchanged by sibling")
+
+	entries := logs.AllUntimed()
+	expect(t, len(entries), 3)
+
+	fields := entries[0].ContextMap()
+	expect(t, fields["tenant"], "acme")
+	expect(t, fields["user_id"], "42")
+	expect(t, len(fields), 2)
+
+	expect(t, entries[1].ContextMap()["layer"], "sibling")
+	expect(t, len(entries[2].ContextMap()), 2)
+}
+
+func TestFromContext_FollowsGlobal(t *testing.T) {
+	ctx := WithContext(context.Background(), String("tenant", "acme"))
+
+	first, firstLogs := observer.New(zapcore.DebugLevel)
+	undoFirst := ReplaceGlobals(zap.New(first))
+	Ctx(ctx).Info("first")
+	undoFirst()
+
+	second, secondLogs := observer.New(zapcore.DebugLevel)
+	undoSecond := ReplaceGlobals(zap.New(second))
+	defer undoSecond()
+	Ctx(ctx).Info("second")
+
+	expect(t, firstLogs.Len(), 1)
+	expect(t, secondLogs.Len(), 1)
+	expect(t, secondLogs.All()[0].ContextMap()["tenant"], "acme")
+}
@@ -21,7 +21,6 @@ type ResponseWriter interface {
 	Written() bool
 	// Size returns the size of the response body.
 	Size() int
-
 }
 
 // General purpose middleware can be used by multiple frameworks
@@ -47,8 +46,12 @@ func DatadogRequestLoggerMiddleware(rw http.ResponseWriter, r *http.Request, nex
 		}
 	}
 
+	// Fields identifying the request are added to the request context as well,
+	// so that handlers logging through Ctx inherit them.
+	var contextFields []zapcore.Field
+
 	if ip != "" {
-		fields = append(fields, String("network.client.ip", ip))
+		contextFields = append(contextFields, String("network.client.ip", ip))
 	}
 
 	userAgent := r.UserAgent()
@@ -66,7 +69,40 @@ func DatadogRequestLoggerMiddleware(rw http.ResponseWriter, r *http.Request, nex
 		requestId = r.Header.Get("X-Amzn-Trace-Id")
 	}
 	if requestId != "" {
-		fields = append(fields, String("http.request_id", requestId))
+		contextFields = append(contextFields, String("http.request_id", requestId))
+	}
+	fields = append(fields, contextFields...)
+
+	// The trace propagated by the caller is used unless a tracer already
+	// started a span for the request. Entries logged through the request
+	// context, and the summary, carry its IDs.
+	ctx := r.Context()
+	if _, _, ok := TraceFromContext(ctx); !ok {
+		if t, ok := traceFromHeaders(r.Header); ok {
+			ctx = WithTrace(ctx, t.traceID, t.spanID)
+			r = r.WithContext(ctx)
+		}
+	}
+
+	// The W3C trace context is kept alongside, for services that don't run
+	// Datadog.
+	if tc, ok := traceContextFromHeaders(r.Header); ok {
+		ctx = WithTraceContext(ctx, tc)
+		r = r.WithContext(ctx)
+	}
+
+	if len(contextFields) > 0 {
+		r = r.WithContext(WithContext(ctx, contextFields...))
+	}
+
+	// Bodies are only captured when GZAP_HTTP_BODY_MAX_BYTES is set.
+	var bodies *httpBodyCapture
+	if cfg := global.config(); cfg != nil {
+		if opts := cfg.getHTTPBodyOptions(); opts.maxBytes > 0 {
+			if res, ok := rw.(ResponseWriter); ok {
+				bodies, rw, r = newHTTPBodyCapture(opts, res, r)
+			}
+		}
 	}
 
 	next(rw, r)
@@ -81,7 +117,11 @@ func DatadogRequestLoggerMiddleware(rw http.ResponseWriter, r *http.Request, nex
 		fields = append(fields, Int("network.bytes_written", res.Size()))
 	}
 
+	if bodies != nil {
+		fields = append(fields, bodies.fields(statusCode)...)
+	}
 
+	logger := FromContext(ctx)
 	var responseLogger LevedLogger = logger.Info
 	if statusCode >= 400 && statusCode < 499 {
 		responseLogger = logger.Warn
@@ -0,0 +1,340 @@
+package gzap
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// datadogSource is the ddsource of every entry, which selects the Go
+// integration pipeline in Datadog.
+const datadogSource = "go"
+
+// datadogReservedPrefix prefixes the fields whose key collides with an
+// attribute written by the Datadog encoder, "fields.status".
+const datadogReservedPrefix = "fields."
+
+// datadogReserved are the top-level attributes written by the Datadog encoder.
+var datadogReserved = map[string]bool{
+	"status":    true,
+	"message":   true,
+	"timestamp": true,
+	"logger":    true,
+	"error":     true,
+	"service":   true,
+	"env":       true,
+	"version":   true,
+	"host":      true,
+	"ddsource":  true,
+}
+
+// datadogOptions are the unified service tags of the Datadog encoder.
+type datadogOptions struct {
+	service string
+	env     string
+	version string
+	host    string
+}
+
+// datadogEncoder encodes entries as JSON, using the reserved attributes of
+// Datadog so that no remapping is needed in the log pipeline:
+//
+//	{"status":"info","timestamp":1514905445123,"message":"...",
+//	 "logger":{"name":"db","method_name":"..."},
+//	 "service":"my-app","env":"prod","version":"1.2.3","host":"web-1","ddsource":"go"}
+//
+// The first error field is written as error.kind, error.message and
+// error.stack. Fields whose key collides with a reserved attribute are
+// prefixed with "fields.".
+type datadogEncoder struct {
+	zapcore.Encoder
+
+	// header writes the reserved attributes, ahead of the context held by
+	// the inner encoder, so that they stay at the top level whatever
+	// namespace the context opened.
+	header zapcore.Encoder
+
+	opts   datadogOptions
+	caller bool
+
+	// nested is set once a namespace is opened, as keys no longer collide
+	// with the reserved attributes from then on.
+	nested bool
+}
+
+func newDatadogEncoder(opts datadogOptions, caller bool) zapcore.Encoder {
+	// The entry is written as fields by the header encoder, so that the
+	// inner encoder only adds the context and the fields.
+	config := zapcore.EncoderConfig{
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+	}
+
+	return &datadogEncoder{
+		Encoder: zapcore.NewJSONEncoder(config),
+		header:  zapcore.NewJSONEncoder(config),
+		opts:    opts,
+		caller:  caller,
+	}
+}
+
+func (enc *datadogEncoder) Clone() zapcore.Encoder {
+	clone := *enc
+	clone.Encoder = enc.Encoder.Clone()
+
+	return &clone
+}
+
+func (enc *datadogEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	reserved := make([]zapcore.Field, 0, 10)
+	reserved = append(reserved,
+		zapcore.Field{Key: "status", Type: zapcore.StringType, String: datadogStatus(entry.Level)},
+		zapcore.Field{Key: "timestamp", Type: zapcore.Int64Type, Integer: entry.Time.UnixNano() / int64(time.Millisecond)},
+		zapcore.Field{Key: "message", Type: zapcore.StringType, String: entry.Message},
+		zapcore.Field{Key: "logger", Type: zapcore.ObjectMarshalerType, Interface: datadogLogger{entry: entry, caller: enc.caller}},
+	)
+
+	for _, tag := range []struct{ key, value string }{
+		{"service", enc.opts.service},
+		{"env", enc.opts.env},
+		{"version", enc.opts.version},
+		{"host", enc.opts.host},
+		{"ddsource", datadogSource},
+	} {
+		if tag.value != "" {
+			reserved = append(reserved, zapcore.Field{Key: tag.key, Type: zapcore.StringType, String: tag.value})
+		}
+	}
+
+	all := make([]zapcore.Field, 0, len(fields))
+	ddError := datadogError{stack: entry.Stack}
+	nested := enc.nested
+	for _, field := range fields {
+		if !nested && field.Type == zapcore.ErrorType && field.Key == "error" && ddError.err == nil {
+			ddError.err, _ = field.Interface.(error)
+			continue
+		}
+
+		if field.Type == zapcore.NamespaceType {
+			nested = true
+		} else if !nested && datadogReserved[field.Key] {
+			field.Key = datadogReservedPrefix + field.Key
+		}
+		all = append(all, field)
+	}
+
+	if ddError.err != nil || ddError.stack != "" {
+		reserved = append(reserved, zapcore.Field{Key: "error", Type: zapcore.ObjectMarshalerType, Interface: ddError})
+	}
+
+	line, err := enc.header.EncodeEntry(zapcore.Entry{}, reserved)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := enc.Encoder.EncodeEntry(zapcore.Entry{}, all)
+	if err != nil {
+		line.Free()
+		return nil, err
+	}
+	defer body.Free()
+
+	// Both are JSON objects followed by a line ending: the object of the
+	// context and fields is merged into the one of the reserved attributes.
+	header := bytes.TrimRight(line.Bytes(), "\n")
+	context := bytes.TrimRight(body.Bytes(), "\n")
+
+	merged := datadogBuffers.Get()
+	merged.Write(header[:len(header)-1])
+	if len(context) > 2 {
+		merged.AppendByte(',')
+		merged.Write(context[1:])
+	} else {
+		merged.AppendByte('}')
+	}
+	merged.AppendString(zapcore.DefaultLineEnding)
+	line.Free()
+
+	return merged, nil
+}
+
+// datadogBuffers holds the buffers of the encoded entries.
+var datadogBuffers = buffer.NewPool()
+
+// datadogStatus maps levels onto the statuses of Datadog.
+func datadogStatus(level zapcore.Level) string {
+	switch level {
+	case zapcore.DPanicLevel:
+		return "critical"
+	case zapcore.PanicLevel:
+		return "alert"
+	case zapcore.FatalLevel:
+		return "emergency"
+	}
+
+	return level.String()
+}
+
+// datadogLogger is the logger attribute of an entry.
+type datadogLogger struct {
+	entry  zapcore.Entry
+	caller bool
+}
+
+func (l datadogLogger) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if l.entry.LoggerName != "" {
+		enc.AddString("name", l.entry.LoggerName)
+	}
+
+	if l.caller && l.entry.Caller.Defined {
+		if fn := runtime.FuncForPC(l.entry.Caller.PC); fn != nil {
+			enc.AddString("method_name", fn.Name())
+		}
+		enc.AddString("caller", l.entry.Caller.TrimmedPath())
+	}
+
+	return nil
+}
+
+// datadogError is the error attribute of an entry.
+type datadogError struct {
+	err   error
+	stack string
+}
+
+func (e datadogError) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if e.err != nil {
+		enc.AddString("kind", fmt.Sprintf("%T", e.err))
+		enc.AddString("message", e.err.Error())
+	}
+
+	stack := e.stack
+	if stack == "" && e.err != nil {
+		// Errors such as those of github.com/pkg/errors print their stack
+		// trace with %+v.
+		if verbose := fmt.Sprintf("%+v", e.err); verbose != e.err.Error() {
+			stack = verbose
+		}
+	}
+	if stack != "" {
+		enc.AddString("stack", stack)
+	}
+
+	return nil
+}
+
+// key prefixes the keys of context fields that collide with a reserved
+// attribute.
+func (enc *datadogEncoder) key(key string) string {
+	if !enc.nested && datadogReserved[key] {
+		return datadogReservedPrefix + key
+	}
+
+	return key
+}
+
+func (enc *datadogEncoder) OpenNamespace(key string) {
+	enc.Encoder.OpenNamespace(enc.key(key))
+	enc.nested = true
+}
+
+func (enc *datadogEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	return enc.Encoder.AddArray(enc.key(key), marshaler)
+}
+
+func (enc *datadogEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	return enc.Encoder.AddObject(enc.key(key), marshaler)
+}
+
+func (enc *datadogEncoder) AddBinary(key string, value []byte) {
+	enc.Encoder.AddBinary(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddByteString(key string, value []byte) {
+	enc.Encoder.AddByteString(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddBool(key string, value bool) {
+	enc.Encoder.AddBool(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddComplex128(key string, value complex128) {
+	enc.Encoder.AddComplex128(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddComplex64(key string, value complex64) {
+	enc.Encoder.AddComplex64(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddDuration(key string, value time.Duration) {
+	enc.Encoder.AddDuration(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddFloat64(key string, value float64) {
+	enc.Encoder.AddFloat64(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddFloat32(key string, value float32) {
+	enc.Encoder.AddFloat32(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddInt(key string, value int) {
+	enc.Encoder.AddInt(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddInt64(key string, value int64) {
+	enc.Encoder.AddInt64(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddInt32(key string, value int32) {
+	enc.Encoder.AddInt32(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddInt16(key string, value int16) {
+	enc.Encoder.AddInt16(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddInt8(key string, value int8) {
+	enc.Encoder.AddInt8(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddString(key string, value string) {
+	enc.Encoder.AddString(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddTime(key string, value time.Time) {
+	enc.Encoder.AddTime(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddUint(key string, value uint) {
+	enc.Encoder.AddUint(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddUint64(key string, value uint64) {
+	enc.Encoder.AddUint64(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddUint32(key string, value uint32) {
+	enc.Encoder.AddUint32(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddUint16(key string, value uint16) {
+	enc.Encoder.AddUint16(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddUint8(key string, value uint8) {
+	enc.Encoder.AddUint8(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddUintptr(key string, value uintptr) {
+	enc.Encoder.AddUintptr(enc.key(key), value)
+}
+
+func (enc *datadogEncoder) AddReflected(key string, value interface{}) error {
+	return enc.Encoder.AddReflected(enc.key(key), value)
+}
@@ -0,0 +1,212 @@
+package gzap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestDatadogEncoder(t *testing.T) {
+	entry := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Date(2018, time.January, 2, 15, 4, 5, 123456789, time.UTC),
+		LoggerName: "db",
+		Message:    "slow query",
+		Caller:     zapcore.NewEntryCaller(0, "/go/src/app/db/db.go", 42, true),
+	}
+	opts := datadogOptions{service: "my-app", env: "prod", version: "1.2.3", host: "web-1"}
+
+	tests := []struct {
+		name    string
+		opts    datadogOptions
+		entry   func(entry zapcore.Entry) zapcore.Entry
+		context []zapcore.Field
+		fields  []zapcore.Field
+		want    map[string]interface{}
+	}{
+		{
+			"EncodeEntry should write the reserved attributes",
+			opts,
+			func(entry zapcore.Entry) zapcore.Entry { return entry },
+			nil,
+			[]zapcore.Field{zap.Int("rows", 3)},
+			map[string]interface{}{
+				"status":    "warn",
+				"timestamp": float64(1514905445123),
+				"message":   "slow query",
+				"logger":    map[string]interface{}{"name": "db", "caller": "db/db.go:42"},
+				"service":   "my-app",
+				"env":       "prod",
+				"version":   "1.2.3",
+				"host":      "web-1",
+				"ddsource":  "go",
+				"rows":      float64(3),
+			},
+		},
+		{
+			"EncodeEntry should omit the tags that are not set",
+			datadogOptions{},
+			func(entry zapcore.Entry) zapcore.Entry {
+				entry.Level = zapcore.DPanicLevel
+				entry.LoggerName = ""
+				entry.Caller = zapcore.EntryCaller{}
+				return entry
+			},
+			nil,
+			nil,
+			map[string]interface{}{
+				"status":    "critical",
+				"timestamp": float64(1514905445123),
+				"message":   "slow query",
+				"logger":    map[string]interface{}{},
+				"ddsource":  "go",
+			},
+		},
+		{
+			"EncodeEntry should write errors and stack traces",
+			datadogOptions{},
+			func(entry zapcore.Entry) zapcore.Entry {
+				entry.Level = zapcore.ErrorLevel
+				entry.Caller = zapcore.EntryCaller{}
+				entry.Stack = "main.main\n\t/go/src/app/main.go:12"
+				return entry
+			},
+			nil,
+			[]zapcore.Field{zap.Error(errors.New("connection refused"))},
+			map[string]interface{}{
+				"status":    "error",
+				"timestamp": float64(1514905445123),
+				"message":   "slow query",
+				"logger":    map[string]interface{}{"name": "db"},
+				"ddsource":  "go",
+				"error": map[string]interface{}{
+					"kind":    "*errors.errorString",
+					"message": "connection refused",
+					"stack":   "main.main\n\t/go/src/app/main.go:12",
+				},
+			},
+		},
+		{
+			"EncodeEntry should prefix the fields colliding with reserved attributes",
+			opts,
+			func(entry zapcore.Entry) zapcore.Entry {
+				entry.Caller = zapcore.EntryCaller{}
+				return entry
+			},
+			[]zapcore.Field{zap.String("env", "3"), zap.Error(errors.New("context"))},
+			[]zapcore.Field{
+				zap.String("status", "paid"),
+				zap.Namespace("order"),
+				zap.String("status", "shipped"),
+			},
+			map[string]interface{}{
+				"status":        "warn",
+				"timestamp":     float64(1514905445123),
+				"message":       "slow query",
+				"logger":        map[string]interface{}{"name": "db"},
+				"service":       "my-app",
+				"env":           "prod",
+				"version":       "1.2.3",
+				"host":          "web-1",
+				"ddsource":      "go",
+				"fields.env":    "3",
+				"fields.error":  "context",
+				"fields.status": "paid",
+				"order":         map[string]interface{}{"status": "shipped"},
+			},
+		},
+		{
+			"EncodeEntry should keep the reserved attributes out of the namespaces of the context",
+			datadogOptions{service: "my-app"},
+			func(entry zapcore.Entry) zapcore.Entry {
+				entry.Level = zapcore.ErrorLevel
+				entry.Caller = zapcore.EntryCaller{}
+				entry.Stack = "main.main"
+				return entry
+			},
+			[]zapcore.Field{zap.String("request_id", "abc"), zap.Namespace("ctx"), zap.String("a", "b")},
+			[]zapcore.Field{zap.Int("rows", 3)},
+			map[string]interface{}{
+				"status":     "error",
+				"timestamp":  float64(1514905445123),
+				"message":    "slow query",
+				"logger":     map[string]interface{}{"name": "db"},
+				"service":    "my-app",
+				"ddsource":   "go",
+				"error":      map[string]interface{}{"stack": "main.main"},
+				"request_id": "abc",
+				"ctx":        map[string]interface{}{"a": "b", "rows": float64(3)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := newDatadogEncoder(tt.opts, true)
+			for _, field := range tt.context {
+				field.AddTo(enc)
+			}
+
+			buf, err := enc.EncodeEntry(tt.entry(entry), tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]interface{}{}
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("%v in %s", err, buf.Bytes())
+			}
+
+			delete(got["logger"].(map[string]interface{}), "method_name")
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EncodeEntry() = %s, want %v", buf.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDatadogEncoder_MethodName(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := zap.New(zapcore.NewCore(newDatadogEncoder(datadogOptions{}, true), zapcore.AddSync(buf), zapcore.DebugLevel), zap.AddCaller())
+	logger.Info("called")
+
+	got := struct {
+		Logger struct {
+			MethodName string `json:"method_name"`
+			Caller     string `json:"caller"`
+		} `json:"logger"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, got.Logger.MethodName, "github.com/dailymuse/gzap.TestDatadogEncoder_MethodName")
+	expect(t, strings.HasPrefix(got.Logger.Caller, "gzap/datadog_encoder_test.go:"), true)
+}
+
+func TestDatadogStatus(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  string
+	}{
+		{zapcore.DebugLevel, "debug"},
+		{zapcore.InfoLevel, "info"},
+		{zapcore.WarnLevel, "warn"},
+		{zapcore.ErrorLevel, "error"},
+		{zapcore.DPanicLevel, "critical"},
+		{zapcore.PanicLevel, "alert"},
+		{zapcore.FatalLevel, "emergency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			expect(t, datadogStatus(tt.level), tt.want)
+		})
+	}
+}
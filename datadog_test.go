@@ -1,12 +1,15 @@
 package gzap
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zaptest/observer"
 )
 
@@ -31,6 +34,15 @@ func TestDatadog(t *testing.T) {
 	cfg.On("getGraylogHost").Return("")
 	cfg.On("getIsTestEnv").Return(false)
 	cfg.On("useColoredConsolelogs").Return(true)
+	cfg.On("getLevel").Return(zapcore.DebugLevel)
+	cfg.On("getLoggerLevels").Return(map[string]zapcore.Level{})
+	cfg.On("getStaticFields").Return(map[string]string{})
+	cfg.On("getRedactRules").Return([]string{})
+	cfg.On("getConsoleOptions").Return(defaultConsoleOptions())
+	cfg.On("getFileOptions").Return(fileOptions{})
+	cfg.On("getSyslogOptions").Return(syslogOptions{})
+	cfg.On("getHTTPBodyOptions").Return(httpBodyOptions{})
+	cfg.On("getSinks").Return([]sinkConfig(nil))
 
 	err := initLogger(&cfg, true)
 	if err != nil {
@@ -66,3 +78,247 @@ func TestDatadog(t *testing.T) {
 	expect(t, ctx["network.bytes_read"], int64(7))
 
 }
+
+func TestDatadog_Context(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	undo := ReplaceGlobals(zap.New(core))
+	defer undo()
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		Ctx(r.Context()).Info("handling request")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("X-Forwarded-For", "127.0.0.1")
+	req.Header.Set("X-Request-Id", "abc-123")
+	req = req.WithContext(WithContext(req.Context(), String("tenant", "acme")))
+
+	DatadogRequestLoggerHandler(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	all := logs.AllUntimed()
+	expect(t, len(all), 2)
+
+	handled := all[0].ContextMap()
+	expect(t, handled["network.client.ip"], "127.0.0.1")
+	expect(t, handled["http.request_id"], "abc-123")
+	expect(t, handled["tenant"], "acme")
+
+	summary := all[1].ContextMap()
+	expect(t, summary["http.request_id"], "abc-123")
+	expect(t, summary["tenant"], "acme")
+}
+
+func TestDatadog_Trace(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	undo := ReplaceGlobals(zap.New(core))
+	defer undo()
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		Ctx(r.Context()).Info("handling request")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("X-Request-Id", "abc-123")
+	req.Header.Set("x-datadog-trace-id", "1234")
+	req.Header.Set("x-datadog-parent-id", "5678")
+
+	DatadogRequestLoggerHandler(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	all := logs.AllUntimed()
+	expect(t, len(all), 2)
+
+	for _, entry := range all {
+		fields := entry.ContextMap()
+		expect(t, fields["dd.trace_id"], "1234")
+		expect(t, fields["dd.span_id"], "5678")
+		expect(t, fields["http.request_id"], "abc-123")
+	}
+}
+
+func TestDatadog_TraceContext(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	undo := ReplaceGlobals(zap.New(core))
+	defer undo()
+
+	downstream := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		downstream <- r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: TraceContextTransport(nil)}
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		Ctx(r.Context()).Info("calling downstream")
+
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		resp, err := client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			t.Error(err)
+		} else {
+			resp.Body.Close()
+		}
+
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	DatadogRequestLoggerHandler(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	expect(t, <-downstream, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	all := logs.AllUntimed()
+	expect(t, len(all), 2)
+
+	for _, entry := range all {
+		fields := entry.ContextMap()
+		expect(t, fields["trace_id"], "4bf92f3577b34da6a3ce929d0e0e4736")
+		expect(t, fields["span_id"], "00f067aa0ba902b7")
+		expect(t, fields["trace_flags"], "01")
+	}
+}
+
+func TestDatadog_Bodies(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	undo := ReplaceGlobals(zap.New(core))
+	defer undo()
+
+	cfg, err := NewConfig(FileConfig{HTTPBody: HTTPBodyFileConfig{
+		MaxBytes: "64",
+		Statuses: []string{"4xx"},
+		Redact:   []string{"password", "cards.number"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	global.mu.Lock()
+	previous := global.cfg
+	global.cfg = cfg
+	global.mu.Unlock()
+	defer func() {
+		global.mu.Lock()
+		global.cfg = previous
+		global.mu.Unlock()
+	}()
+
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		status       int
+		responseType string
+		response     string
+		want         map[string]interface{}
+	}{
+		{
+			"JSON bodies should be redacted",
+			"application/json",
+			`{"user":"jane","password":"hunter2","cards":[{"number":"4242"}]}`,
+			http.StatusBadRequest,
+			"application/problem+json",
+			`{"error":"invalid card"}`,
+			map[string]interface{}{
+				"http.request.body":  `{"cards":[{"number":"[REDACTED]"}],"password":"[REDACTED]","user":"jane"}`,
+				"http.response.body": `{"error":"invalid card"}`,
+			},
+		},
+		{
+			"Form bodies should be redacted",
+			"application/x-www-form-urlencoded",
+			"user=jane&Password=hunter2",
+			http.StatusUnauthorized,
+			"text/plain",
+			"unauthorized",
+			map[string]interface{}{
+				"http.request.body": "Password=%5BREDACTED%5D&user=jane",
+			},
+		},
+		{
+			"Truncated bodies should be redacted entirely",
+			"application/json",
+			`{"user":"jane","bio":"` + strings.Repeat("a", 64) + `"}`,
+			http.StatusNotFound,
+			"",
+			"",
+			map[string]interface{}{
+				"http.request.body":           redactedValue,
+				"http.request.body_truncated": true,
+			},
+		},
+		{
+			"Other content types should not be captured",
+			"text/plain",
+			"password=hunter2",
+			http.StatusBadRequest,
+			"text/html",
+			"<p>bad request</p>",
+			map[string]interface{}{},
+		},
+		{
+			"Other status classes should not be captured",
+			"application/json",
+			`{"user":"jane"}`,
+			http.StatusOK,
+			"application/json",
+			`{"id":1}`,
+			map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				expect(t, string(body), tt.body)
+
+				if tt.responseType != "" {
+					rw.Header().Set("Content-Type", tt.responseType)
+				}
+				rw.WriteHeader(tt.status)
+				rw.Write([]byte(tt.response))
+			})
+
+			req, err := http.NewRequest("POST", "http://localhost:3000/foobar", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+
+			recorder := httptest.NewRecorder()
+			DatadogRequestLoggerHandler(handler).ServeHTTP(recorder, req)
+			expect(t, recorder.Body.String(), tt.response)
+
+			all := logs.TakeAll()
+			expect(t, len(all), 1)
+
+			got := map[string]interface{}{}
+			for key, value := range all[0].ContextMap() {
+				if strings.HasPrefix(key, "http.request.body") || strings.HasPrefix(key, "http.response.body") {
+					got[key] = value
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("body fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
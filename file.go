@@ -0,0 +1,441 @@
+package gzap
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultFileMaxSize    = 100 * 1024 * 1024
+	defaultFileMaxBackups = 5
+	defaultFileMode       = os.FileMode(0644)
+
+	// backupTimeFormat is the timestamp inserted into the name of rotated
+	// files, "app-2018-01-02T15-04-05.000.log". It sorts chronologically.
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// fileOptions configures the rotating file sink.
+type fileOptions struct {
+	path string
+
+	// maxSize and maxAge rotate the file once it grows past maxSize bytes,
+	// or once it has been written to for longer than maxAge. Zero disables
+	// either rotation.
+	maxSize int64
+	maxAge  time.Duration
+
+	// maxBackups is the number of rotated files kept, all of them when zero.
+	maxBackups int
+	compress   bool
+	mode       os.FileMode
+	format     string
+}
+
+// enableFileLogging returns a core writing to the configured log file, and a
+// func closing it. It returns a nil core when no file is configured.
+func enableFileLogging(cfg Config) (zapcore.Core, func() error, error) {
+	opts := cfg.getFileOptions()
+	if opts.path == "" {
+		return nil, nil, nil
+	}
+
+	core, closeFile, err := openFileCore(cfg, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newRedactCore(core, newRedactRules(cfg.getRedactRules())), closeFile, nil
+}
+
+// openFileCore returns a core writing to the file described by opts, with
+// the console encoder of cfg in the format of opts, and a func closing it.
+func openFileCore(cfg Config, opts fileOptions) (zapcore.Core, func() error, error) {
+	file, err := openRotatingFile(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encoderOptions := cfg.getConsoleOptions()
+	encoderOptions.format = opts.format
+
+	// Levels are controlled by the logger wide filter, see LevelHandler.
+	core := zapcore.NewCore(newConsoleEncoder(encoderOptions, false), file, zapcore.DebugLevel)
+
+	return core, file.Close, nil
+}
+
+// openFiles holds the rotating files opened by path, shared by the loggers
+// built from successive configurations, so that a reload doesn't leave two
+// handles appending to the same file while the previous logger drains.
+var (
+	openFilesMu sync.Mutex
+	openFiles   = map[string]*rotatingFile{}
+)
+
+// rotatingFile is a zapcore.WriteSyncer appending to a file, which it rotates
+// by size and age. The file is reopened on SIGHUP, so that it can also be
+// rotated by an external tool such as logrotate.
+type rotatingFile struct {
+	opts fileOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	// refs is the number of loggers sharing the file, guarded by
+	// openFilesMu. The file is closed once the last one closes it.
+	refs int
+
+	// mill compresses and prunes rotated files in the background, one
+	// rotation at a time.
+	mill    sync.Mutex
+	milling sync.WaitGroup
+
+	signals chan os.Signal
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// openRotatingFile opens the file at opts.path, or shares the one already
+// open at that path, which then follows opts.
+func openRotatingFile(opts fileOptions) (*rotatingFile, error) {
+	openFilesMu.Lock()
+	defer openFilesMu.Unlock()
+
+	if f, ok := openFiles[opts.path]; ok {
+		f.mu.Lock()
+		f.opts = opts
+		f.mu.Unlock()
+
+		f.refs++
+		return f, nil
+	}
+
+	f := &rotatingFile{
+		opts:    opts,
+		refs:    1,
+		signals: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.path), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	signal.Notify(f.signals, syscall.SIGHUP)
+	go f.run()
+
+	openFiles[opts.path] = f
+
+	return f, nil
+}
+
+func (f *rotatingFile) run() {
+	defer close(f.stopped)
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-f.signals:
+			f.reopen()
+		}
+	}
+}
+
+// open opens the file for appending. It must be called with f.mu held, or
+// before f is shared.
+func (f *rotatingFile) open() error {
+	_, err := os.Stat(f.opts.path)
+	created := os.IsNotExist(err)
+
+	file, err := os.OpenFile(f.opts.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, f.opts.mode)
+	if err != nil {
+		return err
+	}
+
+	// The mode given to OpenFile is masked by the umask.
+	if created {
+		if err := file.Chmod(f.opts.mode); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	if info.Size() > 0 {
+		f.openedAt = f.createdAt(info)
+	}
+
+	return nil
+}
+
+// createdAt estimates when an existing file was started, so that its age
+// survives restarts: at the last rotation, named by the newest backup, or at
+// its last modification when there is none.
+func (f *rotatingFile) createdAt(info os.FileInfo) time.Time {
+	backups := f.backups()
+	if len(backups) == 0 {
+		return info.ModTime()
+	}
+
+	ext := filepath.Ext(f.opts.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.opts.path, ext)) + "-"
+	stamp := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(backups[len(backups)-1]), ".gz"), ext)
+
+	rotatedAt, err := time.ParseInLocation(backupTimeFormat, strings.TrimPrefix(stamp, prefix), time.Local)
+	if err != nil || rotatedAt.After(info.ModTime()) {
+		return info.ModTime()
+	}
+
+	return rotatedAt
+}
+
+// reopen closes and reopens the file, after it was moved by another process.
+func (f *rotatingFile) reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	f.file.Close()
+	return f.open()
+}
+
+// Write appends p to the file, rotating it first when p would exceed the
+// maximum size, or the file is older than the maximum age.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// A failed rotation is reported, but p is still written to the file,
+	// which rotate reopens.
+	var rotateErr error
+	tooBig := f.opts.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.maxSize
+	tooOld := f.opts.maxAge > 0 && time.Since(f.openedAt) > f.opts.maxAge
+	if tooBig || tooOld {
+		rotateErr = f.rotate()
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if rotateErr != nil {
+		err = rotateErr
+	}
+
+	return n, err
+}
+
+// Sync commits the file to disk.
+func (f *rotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	return f.file.Sync()
+}
+
+// Close releases the file. Once every logger sharing it has closed it, it
+// stops reopening the file on SIGHUP, waits for rotated files to be
+// compressed, and closes the file.
+func (f *rotatingFile) Close() error {
+	openFilesMu.Lock()
+	if f.refs > 1 {
+		f.refs--
+		openFilesMu.Unlock()
+		return nil
+	}
+	f.refs = 0
+	if openFiles[f.opts.path] == f {
+		delete(openFiles, f.opts.path)
+	}
+	openFilesMu.Unlock()
+
+	f.mu.Lock()
+	file := f.file
+	f.file = nil
+	f.mu.Unlock()
+
+	if file == nil {
+		return nil
+	}
+
+	signal.Stop(f.signals)
+	close(f.done)
+	<-f.stopped
+
+	f.milling.Wait()
+
+	return file.Close()
+}
+
+// rotate moves the file aside and opens a new one. It must be called with
+// f.mu held. When it fails, the file is reopened where it was, so that the
+// sink keeps writing.
+func (f *rotatingFile) rotate() error {
+	// The file is already closed when reopening it failed last time.
+	if err := f.file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return f.reopenAfterFailure(err)
+	}
+
+	backup := f.backupName(time.Now())
+	if err := os.Rename(f.opts.path, backup); err != nil && !os.IsNotExist(err) {
+		return f.reopenAfterFailure(err)
+	}
+
+	if err := f.open(); err != nil {
+		// Move the file back rather than appending to the backup.
+		os.Rename(backup, f.opts.path)
+		return f.reopenAfterFailure(err)
+	}
+
+	opts := f.opts
+	f.milling.Add(1)
+	go func() {
+		defer f.milling.Done()
+
+		f.mill.Lock()
+		defer f.mill.Unlock()
+
+		if opts.compress {
+			compressFile(backup, opts.mode)
+		}
+		pruneBackups(opts)
+	}()
+
+	return nil
+}
+
+// reopenAfterFailure reopens the file after a failed rotation, and returns
+// err. If that fails too, the file is left closed, and the next write tries
+// again.
+func (f *rotatingFile) reopenAfterFailure(err error) error {
+	if openErr := f.open(); openErr != nil {
+		return fmt.Errorf("rotate %s: %v, and could not reopen it: %v", f.opts.path, err, openErr)
+	}
+
+	return fmt.Errorf("rotate %s: %v", f.opts.path, err)
+}
+
+// backupName inserts t into the name of the file, before its extension.
+func (f *rotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.opts.path)
+	base := strings.TrimSuffix(f.opts.path, ext)
+
+	return base + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// backups returns the rotated files, oldest first.
+func (f *rotatingFile) backups() []string {
+	return listBackups(f.opts.path)
+}
+
+// listBackups returns the files rotated from path, oldest first.
+func listBackups(path string) []string {
+	ext := filepath.Ext(path)
+	prefix := filepath.Base(strings.TrimSuffix(path, ext)) + "-"
+
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+
+	backups := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name[len(prefix):], ".gz"), ext)
+		if _, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(path), name))
+	}
+	sort.Strings(backups)
+
+	return backups
+}
+
+// pruneBackups removes the oldest rotated files beyond the number of backups
+// kept.
+func pruneBackups(opts fileOptions) {
+	if opts.maxBackups <= 0 {
+		return
+	}
+
+	backups := listBackups(opts.path)
+	for len(backups) > opts.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// compressFile replaces path with a gzip compressed copy, path.gz.
+func compressFile(path string, mode os.FileMode) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	dst.Chmod(mode)
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,340 @@
+package gzap
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    int64
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"64KB", 64 * 1024, false},
+		{"100MB", 100 * 1024 * 1024, false},
+		{"100 mb", 100 * 1024 * 1024, false},
+		{"2G", 2 * 1024 * 1024 * 1024, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"-1MB", 0, true},
+		{"1.5GB", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parseSize(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			expect(t, got, tt.want)
+		})
+	}
+}
+
+func newTestRotatingFile(t *testing.T, opts fileOptions) (*rotatingFile, string) {
+	dir, err := ioutil.TempDir("", "gzap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	opts.path = filepath.Join(dir, "logs", "app.log")
+	if opts.mode == 0 {
+		opts.mode = defaultFileMode
+	}
+
+	file, err := openRotatingFile(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	return file, dir
+}
+
+func write(t *testing.T, file *rotatingFile, lines ...string) {
+	for _, line := range lines {
+		if _, err := file.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestRotatingFile_RotatesBySize(t *testing.T) {
+	file, _ := newTestRotatingFile(t, fileOptions{maxSize: 12})
+
+	write(t, file, "one", "two", "three", "four")
+
+	backups := file.backups()
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup, got %v", backups)
+	}
+	expect(t, readFile(t, backups[0]), "one\ntwo\n")
+	expect(t, readFile(t, file.opts.path), "three\nfour\n")
+}
+
+func TestRotatingFile_RotatesByAge(t *testing.T) {
+	file, _ := newTestRotatingFile(t, fileOptions{maxAge: time.Hour})
+
+	write(t, file, "one")
+	file.openedAt = file.openedAt.Add(-2 * time.Hour)
+	write(t, file, "two")
+
+	backups := file.backups()
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup, got %v", backups)
+	}
+	expect(t, readFile(t, backups[0]), "one\n")
+	expect(t, readFile(t, file.opts.path), "two\n")
+}
+
+func TestRotatingFile_AgeSurvivesRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gzap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	rotatedAt := time.Now().Add(-2 * time.Hour)
+	backup := filepath.Join(dir, "app-"+rotatedAt.Format(backupTimeFormat)+".log")
+	for name, content := range map[string]string{backup: "zero\n", path: "one\n"} {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Started at the last rotation, two hours ago, rather than when opened.
+	file, err := openRotatingFile(fileOptions{path: path, maxAge: time.Hour, mode: defaultFileMode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	write(t, file, "two")
+	expect(t, len(file.backups()), 2)
+	expect(t, readFile(t, path), "two\n")
+}
+
+func TestRotatingFile_RecoversFromFailedRotation(t *testing.T) {
+	file, dir := newTestRotatingFile(t, fileOptions{maxSize: 4})
+
+	write(t, file, "one")
+
+	// The new file can't be created once its directory is gone.
+	if err := os.RemoveAll(filepath.Join(dir, "logs")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("two\n")); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, file, "three", "four")
+
+	expect(t, readFile(t, file.opts.path), "four\n")
+}
+
+func TestRotatingFile_Shared(t *testing.T) {
+	file, _ := newTestRotatingFile(t, fileOptions{})
+
+	shared, err := openRotatingFile(fileOptions{path: file.opts.path, mode: defaultFileMode, maxSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, shared, file)
+	expect(t, file.opts.maxSize, int64(100))
+
+	// The file stays open until every logger sharing it closes it.
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	write(t, shared, "still open")
+
+	if err := shared.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.Write([]byte("late\n")); err != os.ErrClosed {
+		t.Fatalf("Write() after the last Close() error = %v, want %v", err, os.ErrClosed)
+	}
+	expect(t, readFile(t, file.opts.path), "still open\n")
+}
+
+func TestRotatingFile_PrunesAndCompressesBackups(t *testing.T) {
+	file, _ := newTestRotatingFile(t, fileOptions{maxSize: 1, maxBackups: 2, compress: true})
+
+	for _, line := range []string{"one", "two", "three", "four"} {
+		write(t, file, line)
+		// Backups are named after the time of the rotation.
+		time.Sleep(2 * time.Millisecond)
+	}
+	file.milling.Wait()
+
+	backups := file.backups()
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+
+	for i, want := range []string{"two\n", "three\n"} {
+		if !strings.HasSuffix(backups[i], ".log.gz") {
+			t.Fatalf("expected %s to be compressed", backups[i])
+		}
+
+		compressed, err := os.Open(backups[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer compressed.Close()
+
+		reader, err := gzip.NewReader(compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(t, string(data), want)
+	}
+	expect(t, readFile(t, file.opts.path), "four\n")
+}
+
+func TestRotatingFile_ReopensOnSIGHUP(t *testing.T) {
+	file, dir := newTestRotatingFile(t, fileOptions{})
+
+	write(t, file, "before")
+
+	// Rotate the file as logrotate would.
+	moved := filepath.Join(dir, "logs", "app.log.1")
+	if err := os.Rename(file.opts.path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(file.opts.path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the file was not reopened after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	write(t, file, "after")
+
+	expect(t, readFile(t, moved), "before\n")
+	expect(t, readFile(t, file.opts.path), "after\n")
+}
+
+func TestRotatingFile_Mode(t *testing.T) {
+	defer syscall.Umask(syscall.Umask(0))
+
+	file, _ := newTestRotatingFile(t, fileOptions{mode: 0600})
+
+	info, err := os.Stat(file.opts.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, info.Mode().Perm(), os.FileMode(0600))
+}
+
+func TestRotatingFile_ModeIgnoresUmask(t *testing.T) {
+	defer syscall.Umask(syscall.Umask(022))
+
+	file, _ := newTestRotatingFile(t, fileOptions{mode: 0666, maxSize: 1})
+
+	write(t, file, "one", "two")
+
+	for _, path := range append(file.backups(), file.opts.path) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(t, info.Mode().Perm(), os.FileMode(0666))
+	}
+}
+
+func TestRotatingFile_Close(t *testing.T) {
+	file, _ := newTestRotatingFile(t, fileOptions{})
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("late\n")); err != os.ErrClosed {
+		t.Fatalf("Write() after Close() error = %v, want %v", err, os.ErrClosed)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+}
+
+func TestEnableFileLogging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gzap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+
+	cfg := &MockEnvConfig{}
+	cfg.On("getFileOptions").Return(fileOptions{path: path, mode: defaultFileMode, format: consoleFormatLogfmt})
+	cfg.On("getConsoleOptions").Return(defaultConsoleOptions())
+	cfg.On("getRedactRules").Return([]string{"password"})
+
+	core, closeFile, err := enableFileLogging(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zap.New(core)
+	logger.Debug("signed in", zap.String("user", "jane"), zap.String("password", "hunter2"))
+	if err := closeFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	line := readFile(t, path)
+	for _, want := range []string{"level=debug", "msg=\"signed in\"", "user=jane"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("expected %q in %q", want, line)
+		}
+	}
+	if strings.Contains(line, "hunter2") {
+		t.Errorf("expected the password to be redacted from %q", line)
+	}
+}
+
+func TestEnableFileLogging_Disabled(t *testing.T) {
+	cfg := &MockEnvConfig{}
+	cfg.On("getFileOptions").Return(fileOptions{})
+
+	core, closeFile, err := enableFileLogging(cfg)
+	if core != nil || closeFile != nil || err != nil {
+		t.Fatalf("enableFileLogging() = %v, %v; want a nil core and closer", core, err)
+	}
+}
@@ -0,0 +1,421 @@
+package gzap
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultFluentPort          = 24224
+	defaultFluentTag           = "gzap"
+	defaultFluentFlushInterval = time.Second
+
+	// fluentTimeout bounds connecting, writing and waiting for acks, which
+	// happen in the background. After a failed connection, none is attempted
+	// for as long, so that an unreachable server doesn't hold up the queue.
+	fluentTimeout = 5 * time.Second
+
+	// fluentQueueBatch and fluentQueueFlushInterval bound how many entries
+	// are queued together in Message mode, and for how long, as syslog does.
+	fluentQueueBatch         = 100
+	fluentQueueFlushInterval = 100 * time.Millisecond
+)
+
+// fluentOptions configures a Fluentd Forward sink.
+type fluentOptions struct {
+	// network is "tcp" or "unix".
+	network string
+	address string
+
+	// tag prefixes the tag of every entry, the app name by default.
+	tag string
+
+	// batch is the number of entries sent together in PackedForward mode,
+	// flushed at the latest every flushInterval. Entries are sent one by one
+	// in Message mode when batch is 1.
+	batch         int
+	flushInterval time.Duration
+
+	// ack sets a chunk ID on every message, and waits for the server to
+	// acknowledge it. Messages that are not acknowledged are sent again.
+	ack     bool
+	timeout time.Duration
+}
+
+// parseFluentSink parses "fluent+tcp://fluent-bit:24224" or
+// "fluent+unix:///var/run/fluent.sock", with the tag, batch, flush_interval,
+// ack and timeout options.
+func parseFluentSink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+	if err := checkSinkParams(query, "tag", "batch", "flush_interval", "ack", "timeout"); err != nil {
+		return nil, err
+	}
+
+	opts := fluentOptions{
+		tag:           query.Get("tag"),
+		batch:         1,
+		flushInterval: defaultFluentFlushInterval,
+		timeout:       fluentTimeout,
+	}
+
+	if u.Scheme == "fluent+unix" {
+		opts.network, opts.address = "unix", u.Path
+		if opts.address == "" {
+			return nil, fmt.Errorf("no socket path")
+		}
+	} else {
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("no host")
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = strconv.Itoa(defaultFluentPort)
+		}
+		opts.network, opts.address = "tcp", net.JoinHostPort(u.Hostname(), port)
+	}
+
+	if text := query.Get("batch"); text != "" {
+		batch, err := strconv.Atoi(text)
+		if err != nil || batch < 1 {
+			return nil, fmt.Errorf("batch: could not parse %q as a number of entries", text)
+		}
+		opts.batch = batch
+	}
+
+	if err := parseSinkDuration(query, "flush_interval", &opts.flushInterval); err != nil {
+		return nil, err
+	}
+	if err := parseSinkDuration(query, "timeout", &opts.timeout); err != nil {
+		return nil, err
+	}
+
+	if err := parseSinkBool(query, "ack", &opts.ack); err != nil {
+		return nil, err
+	}
+
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		opts := opts
+		if opts.tag == "" {
+			opts.tag = cfg.getGraylogAppName()
+		}
+		if opts.tag == "" {
+			opts.tag = defaultFluentTag
+		}
+
+		client := newFluentClient(opts)
+		return newFluentCore(opts.tag, client), client.Close, nil
+	}, nil
+}
+
+// fluentCore writes entries as Fluentd Forward events. Their tag is the tag
+// of the sink, followed by the logger name, e.g. "my-app.db".
+type fluentCore struct {
+	tag    string
+	client *fluentClient
+	fields []zapcore.Field
+}
+
+func newFluentCore(tag string, client *fluentClient) *fluentCore {
+	return &fluentCore{tag: tag, client: client}
+}
+
+// Enabled accepts every level: levels are controlled by the logger wide
+// filter, see LevelHandler.
+func (c *fluentCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *fluentCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+
+	return &clone
+}
+
+func (c *fluentCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checked.AddCore(entry, c)
+}
+
+func (c *fluentCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	tag := c.tag
+	if entry.LoggerName != "" {
+		tag += "." + entry.LoggerName
+	}
+
+	return c.client.post(tag, appendMsgpackEventTime(nil, entry.Time), c.record(entry, fields))
+}
+
+// Sync waits for the queued entries to be sent.
+func (c *fluentCore) Sync() error {
+	return c.client.sync()
+}
+
+// record encodes the record of an entry: its level, logger, caller, message
+// and stack trace, along with the fields.
+func (c *fluentCore) record(entry zapcore.Entry, fields []zapcore.Field) []byte {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	record := enc.Fields
+	record["level"] = entry.Level.String()
+	record["message"] = entry.Message
+	if entry.LoggerName != "" {
+		record["logger"] = entry.LoggerName
+	}
+	if entry.Caller.Defined {
+		record["caller"] = entry.Caller.TrimmedPath()
+	}
+	if entry.Stack != "" {
+		record["stacktrace"] = entry.Stack
+	}
+
+	return appendMsgpackValue(nil, record)
+}
+
+// fluentClient sends events to a Fluentd Forward server, such as Fluent Bit,
+// in the background, so that an unresponsive server can't block logging:
+// events are queued, and dropped once the queue is full. It connects lazily,
+// and reconnects once when sending fails.
+type fluentClient struct {
+	*batcher
+
+	opts fluentOptions
+
+	// conn, reader and retryAt are only used by the sender goroutine, and by
+	// Close once it stopped. No connection is attempted before retryAt,
+	// after one failed, so that the events queued in the meantime are
+	// dropped without waiting for the server.
+	conn    net.Conn
+	reader  *bufio.Reader
+	retryAt time.Time
+}
+
+// fluentEvent is an entry waiting to be sent.
+type fluentEvent struct {
+	tag       string
+	eventTime []byte
+	record    []byte
+}
+
+// fluentBatch is a PackedForward stream of [time, record] entries.
+type fluentBatch struct {
+	entries []byte
+	count   int
+}
+
+func newFluentClient(opts fluentOptions) *fluentClient {
+	c := &fluentClient{opts: opts}
+
+	name := "fluent " + opts.address
+	if opts.batch > 1 {
+		c.batcher = newBatcher(name, opts.batch, 0, opts.flushInterval, c.send)
+	} else {
+		c.batcher = newBatcher(name, fluentQueueBatch, 0, fluentQueueFlushInterval, c.send)
+	}
+
+	return c
+}
+
+// post queues an event.
+func (c *fluentClient) post(tag string, eventTime []byte, record []byte) error {
+	return c.add(fluentEvent{tag: tag, eventTime: eventTime, record: record}, len(eventTime)+len(record))
+}
+
+// send sends a batch of events, dropping the rest of it on the first failure.
+// Events are sent one by one in Message mode when batch is 1, and as a
+// PackedForward message per tag otherwise.
+func (c *fluentClient) send(items []interface{}) error {
+	if c.opts.batch <= 1 {
+		for i, item := range items {
+			if err := c.sendEvent(item.(fluentEvent)); err != nil {
+				return fmt.Errorf("dropped %d entries: %v", len(items)-i, err)
+			}
+		}
+
+		return nil
+	}
+
+	// The batches of every tag, in the order their tags were first seen.
+	batches := map[string]*fluentBatch{}
+	tags := []string{}
+	for _, item := range items {
+		event := item.(fluentEvent)
+
+		batch, ok := batches[event.tag]
+		if !ok {
+			batch = &fluentBatch{}
+			batches[event.tag] = batch
+			tags = append(tags, event.tag)
+		}
+		batch.entries = appendMsgpackArrayHeader(batch.entries, 2)
+		batch.entries = append(batch.entries, event.eventTime...)
+		batch.entries = append(batch.entries, event.record...)
+		batch.count++
+	}
+
+	for i, tag := range tags {
+		if err := c.sendBatch(tag, batches[tag]); err != nil {
+			dropped := 0
+			for _, tag := range tags[i:] {
+				dropped += batches[tag].count
+			}
+			return fmt.Errorf("dropped %d entries: %v", dropped, err)
+		}
+	}
+
+	return nil
+}
+
+// sendEvent sends an event in Message mode: [tag, time, record, option].
+func (c *fluentClient) sendEvent(event fluentEvent) error {
+	chunk := c.chunk()
+	options := c.options(0, chunk)
+
+	msg := appendMsgpackArrayHeader(nil, 3)
+	if options != nil {
+		msg = appendMsgpackArrayHeader(nil, 4)
+	}
+	msg = appendMsgpackString(msg, event.tag)
+	msg = append(msg, event.eventTime...)
+	msg = append(msg, event.record...)
+	msg = append(msg, options...)
+
+	return c.sendMessage(msg, chunk)
+}
+
+// sendBatch sends the entries of a tag in PackedForward mode:
+// [tag, entries, option].
+func (c *fluentClient) sendBatch(tag string, batch *fluentBatch) error {
+	chunk := c.chunk()
+
+	msg := appendMsgpackArrayHeader(nil, 3)
+	msg = appendMsgpackString(msg, tag)
+	msg = appendMsgpackBinary(msg, batch.entries)
+	msg = append(msg, c.options(batch.count, chunk)...)
+
+	return c.sendMessage(msg, chunk)
+}
+
+// chunk returns a new chunk ID when acks are enabled, a base64 encoded
+// random 128-bit value.
+func (c *fluentClient) chunk() string {
+	if !c.opts.ack {
+		return ""
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return base64.StdEncoding.EncodeToString(id)
+}
+
+// options encodes the option map of a message: its size in PackedForward
+// mode, and its chunk ID. It returns nothing when neither is set.
+func (c *fluentClient) options(size int, chunk string) []byte {
+	n := 0
+	if size > 0 {
+		n++
+	}
+	if chunk != "" {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	options := appendMsgpackMapHeader(nil, n)
+	if size > 0 {
+		options = appendMsgpackString(options, "size")
+		options = appendMsgpackUint(options, uint64(size))
+	}
+	if chunk != "" {
+		options = appendMsgpackString(options, "chunk")
+		options = appendMsgpackString(options, chunk)
+	}
+
+	return options
+}
+
+// sendMessage writes a message, and waits for the ack of its chunk if any.
+// The message is sent again over a new connection when either fails.
+func (c *fluentClient) sendMessage(msg []byte, chunk string) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = c.sendOnce(msg, chunk); err == nil {
+			return nil
+		}
+		if c.conn == nil {
+			// Connecting failed, no new connection is attempted before
+			// retryAt.
+			return err
+		}
+		c.closeConn()
+	}
+
+	return err
+}
+
+func (c *fluentClient) sendOnce(msg []byte, chunk string) error {
+	if c.conn == nil {
+		if time.Now().Before(c.retryAt) {
+			return fmt.Errorf("server unreachable")
+		}
+
+		conn, err := net.DialTimeout(c.opts.network, c.opts.address, c.opts.timeout)
+		if err != nil {
+			c.retryAt = time.Now().Add(c.opts.timeout)
+			return err
+		}
+		c.conn, c.reader = conn, bufio.NewReader(conn)
+	}
+
+	c.conn.SetDeadline(time.Now().Add(c.opts.timeout))
+	if _, err := c.conn.Write(msg); err != nil {
+		return err
+	}
+
+	if chunk == "" {
+		return nil
+	}
+
+	response, err := readMsgpack(c.reader)
+	if err != nil {
+		return fmt.Errorf("reading ack: %v", err)
+	}
+	if ack, _ := response.(map[string]interface{}); ack["ack"] != chunk {
+		return fmt.Errorf("unexpected ack %v for chunk %s", response, chunk)
+	}
+
+	return nil
+}
+
+func (c *fluentClient) closeConn() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.reader = nil, nil
+	}
+}
+
+// Close sends the queued events, and closes the connection.
+func (c *fluentClient) Close() error {
+	err := c.batcher.Close()
+	c.closeConn()
+
+	return err
+}
@@ -0,0 +1,259 @@
+package gzap
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// fluentServer is a stand-in Forward server, such as Fluent Bit, decoding the
+// messages it receives.
+type fluentServer struct {
+	listener net.Listener
+	messages chan []interface{}
+
+	// ack answers the chunk of every message, but for the first one when
+	// dropFirst is set: its connection is closed instead.
+	ack       bool
+	dropFirst bool
+
+	mu      sync.Mutex
+	dropped bool
+}
+
+func newFluentServer(t *testing.T, ack bool, dropFirst bool) *fluentServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fluentServer{
+		listener:  listener,
+		messages:  make(chan []interface{}, 16),
+		ack:       ack,
+		dropFirst: dropFirst,
+	}
+	go s.serve()
+
+	return s
+}
+
+func (s *fluentServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fluentServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		value, err := readMsgpack(reader)
+		if err != nil {
+			return
+		}
+		msg := value.([]interface{})
+		s.messages <- msg
+
+		if !s.ack {
+			continue
+		}
+
+		s.mu.Lock()
+		drop := s.dropFirst && !s.dropped
+		s.dropped = true
+		s.mu.Unlock()
+		if drop {
+			return
+		}
+
+		options := msg[len(msg)-1].(map[string]interface{})
+		ack := appendMsgpackValue(nil, map[string]interface{}{"ack": options["chunk"]})
+		if _, err := conn.Write(ack); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fluentServer) opts() fluentOptions {
+	return fluentOptions{
+		network:       "tcp",
+		address:       s.listener.Addr().String(),
+		tag:           "my-app",
+		batch:         1,
+		flushInterval: time.Hour,
+		ack:           s.ack,
+		timeout:       time.Second,
+	}
+}
+
+func (s *fluentServer) next(t *testing.T) []interface{} {
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestFluentCore_Message(t *testing.T) {
+	server := newFluentServer(t, false, false)
+	defer server.listener.Close()
+
+	client := newFluentClient(server.opts())
+	defer client.Close()
+
+	logger := zap.New(newFluentCore("my-app", client), zap.AddCaller()).Named("db")
+	logger.With(zap.String("tenant", "acme")).Info("slow query", zap.Int("rows", 3))
+
+	msg := server.next(t)
+	expect(t, len(msg), 3)
+	expect(t, msg[0], "my-app.db")
+	if _, ok := msg[1].(time.Time); !ok {
+		t.Errorf("expected an EventTime, got %#v", msg[1])
+	}
+
+	record := msg[2].(map[string]interface{})
+	expect(t, record["level"], "info")
+	expect(t, record["message"], "slow query")
+	expect(t, record["logger"], "db")
+	expect(t, record["tenant"], "acme")
+	expect(t, record["rows"], int64(3))
+	if _, ok := record["caller"]; !ok {
+		t.Error("expected the caller in the record")
+	}
+}
+
+func TestFluentCore_PackedForward(t *testing.T) {
+	server := newFluentServer(t, true, false)
+	defer server.listener.Close()
+
+	opts := server.opts()
+	opts.batch = 2
+	client := newFluentClient(opts)
+
+	logger := zap.New(newFluentCore("my-app", client))
+	logger.Info("first")
+	logger.Info("second")
+
+	msg := server.next(t)
+	expect(t, len(msg), 3)
+	expect(t, msg[0], "my-app")
+
+	options := msg[2].(map[string]interface{})
+	expect(t, options["size"], int64(2))
+	if chunk, _ := options["chunk"].(string); len(chunk) != 24 {
+		t.Errorf("expected a base64 encoded 128-bit chunk, got %q", chunk)
+	}
+
+	entries := bufio.NewReader(bytes.NewReader(msg[1].([]byte)))
+	for _, want := range []string{"first", "second"} {
+		entry, err := readMsgpack(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(t, entry.([]interface{})[1].(map[string]interface{})["message"], want)
+	}
+
+	// Pending entries are flushed on close.
+	logger.Named("http").Info("third")
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg = server.next(t)
+	expect(t, msg[0], "my-app.http")
+	expect(t, msg[2].(map[string]interface{})["size"], int64(1))
+}
+
+func TestFluentClient_Reconnect(t *testing.T) {
+	server := newFluentServer(t, true, true)
+	defer server.listener.Close()
+
+	client := newFluentClient(server.opts())
+	defer client.Close()
+
+	entry := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "retried"}
+	if err := newFluentCore("my-app", client).Write(entry, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message is sent again with the same chunk over a new connection.
+	first, second := server.next(t), server.next(t)
+	expect(t, first[3].(map[string]interface{})["chunk"], second[3].(map[string]interface{})["chunk"])
+	expect(t, second[2].(map[string]interface{})["message"], "retried")
+}
+
+func TestFluentClient_Unreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	client := newFluentClient(fluentOptions{network: "tcp", address: address, batch: 1, timeout: time.Second})
+	defer client.Close()
+
+	// Writes only queue the entry, the failure is reported by Sync.
+	core := newFluentCore("my-app", client)
+	entry := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "lost"}
+	if err := core.Write(entry, nil); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	err = core.Sync()
+	if err == nil || !strings.Contains(err.Error(), address) {
+		t.Fatalf("Sync() error = %v, want an error naming %s", err, address)
+	}
+
+	// No connection is attempted until the timeout elapsed.
+	core.Write(entry, nil)
+	err = core.Sync()
+	if err == nil || !strings.Contains(err.Error(), "server unreachable") {
+		t.Fatalf("Sync() error = %v, want the server to be unreachable", err)
+	}
+}
+
+func TestFluentCore_DoesNotBlock(t *testing.T) {
+	// The server accepts connections, but never acknowledges messages.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	opts := fluentOptions{network: "tcp", address: listener.Addr().String(), batch: 1, ack: true, timeout: fluentTimeout}
+	client := newFluentClient(opts)
+	core := newFluentCore("my-app", client)
+
+	start := time.Now()
+	dropped := false
+	for i := 0; i < 2*fluentQueueBatch*batchQueueSize; i++ {
+		if err := core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "queued"}, nil); err != nil {
+			dropped = true
+		}
+	}
+	if elapsed := time.Since(start); elapsed > fluentTimeout/2 {
+		t.Errorf("writing took %s, want the writes not to wait for the server", elapsed)
+	}
+	if !dropped {
+		t.Error("expected entries to be dropped once the queue is full")
+	}
+
+	listener.Close()
+	client.Close()
+}
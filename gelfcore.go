@@ -19,6 +19,10 @@ type GelfCore struct {
 	cfg                Config
 	encoder            zapcore.Encoder
 	graylogConstructor GraylogConstructor
+
+	// level enables the entries written to Graylog, info messages and above
+	// when unset.
+	level zapcore.LevelEnabler
 }
 
 // NewGelfCore creates a new GelfCore with empty context.
@@ -133,9 +137,14 @@ func (gc GelfCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry
 	return checkedEntry
 }
 
-// Enabled only enables info messages and above.
+// Enabled only enables info messages and above, unless another level was
+// configured.
 func (gc GelfCore) Enabled(level zapcore.Level) bool {
-	return zapcore.InfoLevel.Enabled(level)
+	if gc.level == nil {
+		return zapcore.InfoLevel.Enabled(level)
+	}
+
+	return gc.level.Enabled(level)
 }
 
 func attemptRetry(cfg Config, gc GelfCore, msg graylog.Message, newGraylog GraylogConstructor) error {
@@ -0,0 +1,617 @@
+// Package gelftest provides a GELF server listening on localhost, for
+// integration tests exercising the real Graylog wire formats:
+//
+//	server, err := gelftest.NewServer(gelftest.UDP)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer server.Close()
+//
+//	// Point the logger at server.Host and server.Port, and log.
+//
+//	messages, err := server.Wait(1, time.Second)
+//
+// Servers decode chunked, gzip and zlib compressed messages, and can inject
+// faults to test how clients cope with an unavailable Graylog.
+package gelftest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transports served by a Server.
+const (
+	UDP  = "udp"
+	TCP  = "tcp"
+	TLS  = "tls"
+	HTTP = "http"
+)
+
+// Fault is a failure injected by a Server.
+type Fault int
+
+const (
+	// NoFault receives messages normally.
+	NoFault Fault = iota
+
+	// Drop discards received messages. Stream and HTTP connections are closed
+	// without a response.
+	Drop
+
+	// Refuse stops listening, so that new connections are refused.
+	Refuse
+
+	// InternalError answers HTTP requests with a 500 status. Other transports
+	// drop the messages.
+	InternalError
+)
+
+// Message is a decoded GELF message.
+type Message struct {
+	Version      string
+	Host         string
+	ShortMessage string
+	FullMessage  string
+	Timestamp    float64
+	Level        int
+
+	// Extra holds the additional fields, without their leading underscore.
+	Extra map[string]interface{}
+
+	// Raw is the JSON payload, after reassembly and decompression.
+	Raw []byte
+}
+
+// Server is a GELF server listening on localhost. It is safe for concurrent
+// use.
+type Server struct {
+	// Transport is one of UDP, TCP, TLS or HTTP.
+	Transport string
+
+	// Addr is the host:port the server listens on, Host and Port its parts.
+	Addr string
+	Host string
+	Port uint
+
+	// URL is the endpoint of an HTTP server.
+	URL string
+
+	tlsConfig   *tls.Config
+	certificate *x509.Certificate
+
+	mu       sync.Mutex
+	messages []Message
+	errs     []error
+	updated  chan struct{}
+	fault    Fault
+	delay    time.Duration
+
+	packetConn net.PacketConn
+	listener   net.Listener
+	httpServer *http.Server
+	conns      map[net.Conn]struct{}
+	wg         sync.WaitGroup
+}
+
+// NewServer starts a server receiving GELF messages over transport, on a
+// random localhost port. TLS servers use a self-signed certificate, see
+// CertPool.
+func NewServer(transport string) (*Server, error) {
+	s := &Server{
+		Transport: transport,
+		updated:   make(chan struct{}),
+		conns:     map[net.Conn]struct{}{},
+	}
+
+	if transport == TLS {
+		config, certificate, err := selfSignedTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig, s.certificate = config, certificate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.listen("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		s.stopListening()
+		return nil, err
+	}
+	portNumber, _ := strconv.ParseUint(port, 10, 16)
+	s.Host, s.Port = host, uint(portNumber)
+
+	if transport == HTTP {
+		s.URL = "http://" + s.Addr + "/gelf"
+	}
+
+	return s, nil
+}
+
+// CertPool returns a pool trusting the certificate of a TLS server, or nil
+// for other transports.
+func (s *Server) CertPool() *x509.CertPool {
+	if s.certificate == nil {
+		return nil
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(s.certificate)
+
+	return pool
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// Errors returns the payloads that could not be decoded as GELF messages.
+func (s *Server) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]error(nil), s.errs...)
+}
+
+// Wait waits until at least n messages were received, and returns them.
+func (s *Server) Wait(n int, timeout time.Duration) ([]Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		received := len(s.messages)
+		if received >= n {
+			messages := append([]Message(nil), s.messages...)
+			s.mu.Unlock()
+			return messages, nil
+		}
+		updated := s.updated
+		s.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return nil, fmt.Errorf("gelftest: received %d of %d messages within %s", received, n, timeout)
+		}
+	}
+}
+
+// Reset discards the messages and errors received so far.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = nil
+	s.errs = nil
+}
+
+// SetFault injects fault until another one is set. Setting NoFault after
+// Refuse listens again on the same address.
+func (s *Server) SetFault(fault Fault) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.fault
+	s.fault = fault
+
+	switch {
+	case fault == Refuse && previous != Refuse:
+		s.stopListening()
+	case fault != Refuse && previous == Refuse:
+		return s.listen(s.Addr)
+	}
+
+	return nil
+}
+
+// SetDelay delays the handling of every message, and of HTTP responses.
+func (s *Server) SetDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delay = delay
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.stopListening()
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// listen starts serving on addr. It must be called with s.mu held.
+func (s *Server) listen(addr string) error {
+	if s.Transport == UDP {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+
+		s.packetConn = conn
+		s.Addr = conn.LocalAddr().String()
+		s.wg.Add(1)
+		go s.serveUDP(conn)
+
+		return nil
+	}
+
+	if s.Transport != TCP && s.Transport != TLS && s.Transport != HTTP {
+		return fmt.Errorf("gelftest: unknown transport %q", s.Transport)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.Addr = listener.Addr().String()
+
+	if s.Transport == HTTP {
+		s.httpServer = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+		s.wg.Add(1)
+		go func(server *http.Server) {
+			defer s.wg.Done()
+			server.Serve(listener)
+		}(s.httpServer)
+
+		return nil
+	}
+
+	if s.Transport == TLS {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	s.listener = listener
+	s.wg.Add(1)
+	go s.serveStream(listener)
+
+	return nil
+}
+
+// stopListening closes the listener and every open connection. It must be
+// called with s.mu held.
+func (s *Server) stopListening() {
+	if s.packetConn != nil {
+		s.packetConn.Close()
+		s.packetConn = nil
+	}
+
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+
+	if s.httpServer != nil {
+		s.httpServer.Close()
+		s.httpServer = nil
+	}
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// faultAndDelay returns the current fault, after waiting for the configured
+// delay.
+func (s *Server) faultAndDelay() Fault {
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+
+	time.Sleep(delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fault
+}
+
+func (s *Server) serveUDP(conn net.PacketConn) {
+	defer s.wg.Done()
+
+	chunks := newChunkAssembler()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		datagram := append([]byte(nil), buf[:n]...)
+		if s.faultAndDelay() != NoFault {
+			continue
+		}
+
+		payload, err := chunks.add(datagram)
+		if err != nil {
+			s.recordError(err)
+			continue
+		}
+
+		if payload != nil {
+			s.record(payload)
+		}
+	}
+}
+
+func (s *Server) serveStream(listener net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn reads null byte delimited messages from conn.
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := reader.ReadBytes(0)
+		if err != nil {
+			return
+		}
+
+		if s.faultAndDelay() != NoFault {
+			return
+		}
+
+		s.record(bytes.TrimRight(frame[:len(frame)-1], "\r\n"))
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch s.faultAndDelay() {
+	case Drop:
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+
+	case InternalError:
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.recordError(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !s.record(body) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// record decodes and stores a message, and reports whether it was valid.
+func (s *Server) record(payload []byte) bool {
+	message, err := decodeMessage(payload)
+	if err != nil {
+		s.recordError(err)
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, message)
+	close(s.updated)
+	s.updated = make(chan struct{})
+
+	return true
+}
+
+func (s *Server) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errs = append(s.errs, err)
+}
+
+// decodeMessage decompresses and decodes a GELF payload.
+func decodeMessage(payload []byte) (Message, error) {
+	data, err := decompress(payload)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Clients such as go-graylog terminate every payload with the stream
+	// delimiter, which Graylog tolerates.
+	data = bytes.TrimRight(data, "\x00\r\n ")
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Message{}, fmt.Errorf("gelftest: invalid message %q: %v", data, err)
+	}
+
+	message := Message{Extra: map[string]interface{}{}, Raw: data}
+	for key, value := range fields {
+		switch key {
+		case "version":
+			message.Version, _ = value.(string)
+		case "host":
+			message.Host, _ = value.(string)
+		case "short_message":
+			message.ShortMessage, _ = value.(string)
+		case "full_message":
+			message.FullMessage, _ = value.(string)
+		case "timestamp":
+			message.Timestamp, _ = value.(float64)
+		case "level":
+			level, _ := value.(float64)
+			message.Level = int(level)
+		default:
+			if !strings.HasPrefix(key, "_") {
+				return Message{}, fmt.Errorf("gelftest: invalid message %q: field %q lacks a leading underscore", data, key)
+			}
+			message.Extra[key[1:]] = value
+		}
+	}
+
+	if message.Version == "" || message.Host == "" || message.ShortMessage == "" {
+		return Message{}, fmt.Errorf("gelftest: invalid message %q: version, host and short_message are required", data)
+	}
+
+	return message, nil
+}
+
+// decompress inflates gzip and zlib payloads, and returns others as is.
+func decompress(payload []byte) ([]byte, error) {
+	var reader io.Reader
+	var err error
+
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) >= 2 && payload[0] == 0x78 && (uint16(payload[0])<<8|uint16(payload[1]))%31 == 0:
+		reader, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		return payload, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("gelftest: invalid compressed message: %v", err)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("gelftest: invalid compressed message: %v", err)
+	}
+
+	return data, nil
+}
+
+// GELF chunks start with these magic bytes, followed by an 8 byte message id,
+// the sequence number and the sequence count.
+const (
+	chunkMagic0     = 0x1e
+	chunkMagic1     = 0x0f
+	chunkHeaderSize = 12
+	maxChunks       = 128
+	chunkTimeout    = 5 * time.Second
+)
+
+var errInvalidChunk = errors.New("gelftest: invalid chunk")
+
+type chunkSet struct {
+	parts    [][]byte
+	received int
+	started  time.Time
+}
+
+// chunkAssembler reassembles chunked GELF messages.
+type chunkAssembler struct {
+	sets map[string]*chunkSet
+}
+
+func newChunkAssembler() *chunkAssembler {
+	return &chunkAssembler{sets: map[string]*chunkSet{}}
+}
+
+// add returns the reassembled payload once every chunk of a message was
+// received, nil until then. Datagrams that are not chunks are returned as is.
+func (a *chunkAssembler) add(datagram []byte) ([]byte, error) {
+	if len(datagram) < 2 || datagram[0] != chunkMagic0 || datagram[1] != chunkMagic1 {
+		return datagram, nil
+	}
+
+	if len(datagram) < chunkHeaderSize {
+		return nil, errInvalidChunk
+	}
+
+	id := string(datagram[2:10])
+	sequence, count := int(datagram[10]), int(datagram[11])
+	if count == 0 || count > maxChunks || sequence >= count {
+		return nil, errInvalidChunk
+	}
+
+	// Incomplete messages are discarded after 5 seconds, as Graylog does.
+	now := time.Now()
+	for key, set := range a.sets {
+		if now.Sub(set.started) > chunkTimeout {
+			delete(a.sets, key)
+		}
+	}
+
+	set, ok := a.sets[id]
+	if !ok {
+		set = &chunkSet{parts: make([][]byte, count), started: now}
+		a.sets[id] = set
+	}
+
+	if len(set.parts) != count {
+		delete(a.sets, id)
+		return nil, errInvalidChunk
+	}
+
+	if set.parts[sequence] == nil {
+		set.parts[sequence] = datagram[chunkHeaderSize:]
+		set.received++
+	}
+
+	if set.received < count {
+		return nil, nil
+	}
+
+	delete(a.sets, id)
+	return bytes.Join(set.parts, nil), nil
+}
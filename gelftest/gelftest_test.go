@@ -0,0 +1,235 @@
+package gelftest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testMessage = `{"version":"1.1","host":"web-1","short_message":"hello","timestamp":1514764800.5,"level":3,"_app_name":"app","_count":2}`
+
+func newTestServer(t *testing.T, transport string) *Server {
+	server, err := NewServer(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func compress(t *testing.T, algorithm string, data []byte) []byte {
+	var buf bytes.Buffer
+	if algorithm == "gzip" {
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	} else {
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	}
+
+	return buf.Bytes()
+}
+
+// chunk splits payload into GELF chunks of at most size bytes.
+func chunk(id string, payload []byte, size int) [][]byte {
+	count := (len(payload) + size - 1) / size
+	chunks := [][]byte{}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		header := append([]byte{chunkMagic0, chunkMagic1}, id...)
+		header = append(header, byte(i), byte(count))
+		chunks = append(chunks, append(header, payload[i*size:end]...))
+	}
+
+	return chunks
+}
+
+func expectMessage(t *testing.T, server *Server) {
+	t.Helper()
+
+	messages, err := server.Wait(1, time.Second)
+	if err != nil {
+		t.Fatalf("%v, errors: %v", err, server.Errors())
+	}
+
+	m := messages[0]
+	if m.Version != "1.1" || m.Host != "web-1" || m.ShortMessage != "hello" || m.Level != 3 || m.Timestamp != 1514764800.5 {
+		t.Errorf("Wait() got unexpected message %+v", m)
+	}
+	if m.Extra["app_name"] != "app" || m.Extra["count"] != float64(2) {
+		t.Errorf("Wait() got unexpected extra fields %v", m.Extra)
+	}
+}
+
+func TestServer_UDP(t *testing.T) {
+	tests := []struct {
+		name      string
+		datagrams func(t *testing.T) [][]byte
+	}{
+		{"plain", func(t *testing.T) [][]byte {
+			return [][]byte{[]byte(testMessage)}
+		}},
+		{"gzip", func(t *testing.T) [][]byte {
+			return [][]byte{compress(t, "gzip", []byte(testMessage))}
+		}},
+		{"zlib", func(t *testing.T) [][]byte {
+			return [][]byte{compress(t, "zlib", []byte(testMessage))}
+		}},
+		{"chunked out of order", func(t *testing.T) [][]byte {
+			chunks := chunk("abcdefgh", []byte(testMessage), 16)
+			chunks[0], chunks[len(chunks)-1] = chunks[len(chunks)-1], chunks[0]
+			return chunks
+		}},
+		{"chunked gzip", func(t *testing.T) [][]byte {
+			return chunk("12345678", compress(t, "gzip", []byte(testMessage)), 20)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, UDP)
+
+			conn, err := net.Dial("udp", server.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			for _, datagram := range tt.datagrams(t) {
+				conn.Write(datagram)
+			}
+
+			expectMessage(t, server)
+		})
+	}
+}
+
+func TestServer_Stream(t *testing.T) {
+	for _, transport := range []string{TCP, TLS} {
+		t.Run(transport, func(t *testing.T) {
+			server := newTestServer(t, transport)
+
+			var conn net.Conn
+			var err error
+			if transport == TLS {
+				conn, err = tls.Dial("tcp", server.Addr, &tls.Config{RootCAs: server.CertPool()})
+			} else {
+				conn, err = net.Dial("tcp", server.Addr)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			conn.Write(append([]byte(testMessage), '\n', 0))
+			conn.Write([]byte(`{"version":"1.1"}` + "\x00"))
+
+			expectMessage(t, server)
+
+			deadline := time.Now().Add(time.Second)
+			for len(server.Errors()) == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond * 10)
+			}
+			if len(server.Errors()) != 1 {
+				t.Errorf("Errors() expected the invalid message to be reported; got %v", server.Errors())
+			}
+		})
+	}
+}
+
+func TestServer_HTTP(t *testing.T) {
+	server := newTestServer(t, HTTP)
+
+	res, err := http.Post(server.URL, "application/json", bytes.NewReader(compress(t, "gzip", []byte(testMessage))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("POST expected status 202; got %d", res.StatusCode)
+	}
+	expectMessage(t, server)
+}
+
+func TestServer_Faults(t *testing.T) {
+	t.Run("internal error", func(t *testing.T) {
+		server := newTestServer(t, HTTP)
+		server.SetFault(InternalError)
+
+		res, err := http.Post(server.URL, "application/json", bytes.NewReader([]byte(testMessage)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusInternalServerError {
+			t.Errorf("POST expected status 500; got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		server := newTestServer(t, HTTP)
+		server.SetFault(Drop)
+
+		if _, err := http.Post(server.URL, "application/json", bytes.NewReader([]byte(testMessage))); err == nil {
+			t.Error("POST expected the connection to be dropped")
+		}
+		if len(server.Messages()) != 0 {
+			t.Error("Messages() expected dropped messages to be discarded")
+		}
+	})
+
+	t.Run("refuse", func(t *testing.T) {
+		server := newTestServer(t, TCP)
+		if err := server.SetFault(Refuse); err != nil {
+			t.Fatal(err)
+		}
+
+		if conn, err := net.Dial("tcp", server.Addr); err == nil {
+			conn.Close()
+			t.Fatal("Dial() expected the connection to be refused")
+		}
+
+		if err := server.SetFault(NoFault); err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("tcp", server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		conn.Write(append([]byte(testMessage), 0))
+		expectMessage(t, server)
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		server := newTestServer(t, UDP)
+		server.SetDelay(time.Millisecond * 100)
+
+		conn, err := net.Dial("udp", server.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		conn.Write([]byte(testMessage))
+		if _, err := server.Wait(1, time.Millisecond*20); err == nil {
+			t.Error("Wait() expected the message to be delayed")
+		}
+		expectMessage(t, server)
+	})
+}
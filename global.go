@@ -0,0 +1,67 @@
+package gzap
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// globalsMu serializes the replacement of the global loggers, and the updates
+// of the deprecated Logger variable.
+var globalsMu sync.Mutex
+
+// replacement holds the logger installed by ReplaceGlobals, if any.
+var replacement atomic.Value // *zap.Logger
+
+// L returns the global logger. It is safe to call concurrently with
+// InitLogger, configuration reloads and ReplaceGlobals, and always returns
+// the current logger, unlike the deprecated Logger variable.
+func L() *zap.Logger {
+	if logger, _ := replacement.Load().(*zap.Logger); logger != nil {
+		return logger
+	}
+
+	return global.Logger()
+}
+
+// S returns a sugared version of the global logger.
+func S() *zap.SugaredLogger {
+	return L().Sugar()
+}
+
+// ReplaceGlobals replaces the global logger returned by L, as well as zap's
+// own global loggers returned by zap.L and zap.S. It returns a func restoring
+// the previous loggers:
+//
+//	undo := gzap.ReplaceGlobals(logger)
+//	defer undo()
+//
+// logger must not be nil.
+func ReplaceGlobals(logger *zap.Logger) func() {
+	globalsMu.Lock()
+	defer globalsMu.Unlock()
+
+	previous, _ := replacement.Load().(*zap.Logger)
+	replacement.Store(logger)
+	Logger = logger
+	undoZap := zap.ReplaceGlobals(logger)
+
+	return func() {
+		globalsMu.Lock()
+		defer globalsMu.Unlock()
+
+		replacement.Store(previous)
+		Logger = L()
+		undoZap()
+	}
+}
+
+// refreshLogger points the deprecated Logger variable at the current global
+// logger, after the global Handle rebuilt it.
+func refreshLogger() {
+	globalsMu.Lock()
+	defer globalsMu.Unlock()
+
+	Logger = L()
+}
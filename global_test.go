@@ -0,0 +1,94 @@
+package gzap
+
+import (
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestReplaceGlobals(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+
+	undo := ReplaceGlobals(logger)
+
+	expect(t, L(), logger)
+	expect(t, Logger, logger)
+	expect(t, zap.L(), logger)
+
+	L().Info("from gzap")
+	S().Info("from gzap sugar")
+	zap.L().Info("from zap")
+	zap.S().Info("from zap sugar")
+	expect(t, logs.Len(), 4)
+
+	undo()
+
+	expect(t, L(), global.Logger())
+	expect(t, Logger, global.Logger())
+	if zap.L() == logger {
+		t.Error("ReplaceGlobals() undo expected to restore zap.L()")
+	}
+}
+
+func TestReplaceGlobals_Nested(t *testing.T) {
+	first := zap.NewNop()
+	second := zap.NewNop()
+
+	undoFirst := ReplaceGlobals(first)
+	undoSecond := ReplaceGlobals(second)
+	expect(t, L(), second)
+
+	undoSecond()
+	expect(t, L(), first)
+	expect(t, zap.L(), first)
+
+	undoFirst()
+	expect(t, L(), global.Logger())
+}
+
+func TestL_FollowsInitLogger(t *testing.T) {
+	before := L()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	setLoggerFromCore(core)
+	defer setTestLogger(nil)
+
+	if L() == before {
+		t.Error("L() expected to return the rebuilt global logger")
+	}
+	expect(t, Logger, L())
+
+	L().Info("after InitLogger")
+	expect(t, logs.Len(), 1)
+}
+
+func TestL_Concurrent(t *testing.T) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					L().Debug("concurrent")
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		ReplaceGlobals(zap.NewNop())()
+	}
+	close(done)
+	wg.Wait()
+
+	expect(t, L(), global.Logger())
+}
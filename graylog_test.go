@@ -0,0 +1,66 @@
+package gzap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dailymuse/gzap/gelftest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestGelfCore_Wire(t *testing.T) {
+	tests := []struct {
+		name        string
+		transport   string
+		handlerType string
+	}{
+		{"GelfCore should ship messages over UDP", gelftest.UDP, "udp"},
+		{"GelfCore should ship messages over TLS", gelftest.TLS, "tls"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := gelftest.NewServer(tt.transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			skipVerify := true
+			cfg, err := NewConfig(FileConfig{Graylog: GraylogFileConfig{
+				Host:          server.Host,
+				AppName:       "app",
+				Env:           "3",
+				HandlerType:   tt.handlerType,
+				Port:          server.Port,
+				SkipTLSVerify: &skipVerify,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			graylog, err := NewGraylog(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer graylog.Close()
+
+			core := NewGelfCore(cfg, graylog).With([]zapcore.Field{String("team", "search")})
+			logger := zap.New(core)
+
+			logger.Warn("disk almost full", String("disk", "/dev/sda1"))
+
+			messages, err := server.Wait(1, time.Second*5)
+			if err != nil {
+				t.Fatal(err, server.Errors())
+			}
+
+			m := messages[0]
+			expect(t, m.ShortMessage, "disk almost full")
+			expect(t, m.Level, 4)
+			expect(t, m.Extra["app_name"], "app")
+			expect(t, m.Extra["team"], "search")
+			expect(t, m.Extra["disk"], "/dev/sda1")
+		})
+	}
+}
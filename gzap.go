@@ -2,6 +2,7 @@ package gzap
 
 import (
 	"os"
+	"sort"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -13,22 +14,29 @@ type LevedLogger func(msg string, fields ...zapcore.Field)
 
 // Logger is the global logger for the application. Upon first initalization of
 // the logger all calls to 'getLogger' are memoized with the instantiated 'logger'.
-var Logger = getLogger()
-
-// logger is the package level pointer to an instantied Logger.
-var logger *zap.Logger
+//
+// Deprecated: Logger is updated by InitLogger and ReplaceGlobals, but reading
+// it while they run is a data race. Use L instead.
+var Logger *zap.Logger
 
-var highPriority = zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-	return lvl >= zapcore.ErrorLevel
-})
+// global is the Handle behind the global Logger.
+var global = newHandle()
 
-var lowPriority = zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-	return lvl < zapcore.ErrorLevel
-})
+func init() {
+	Logger = getLogger()
+}
 
 // InitLogger initializes a global Logger based upon your env configurations.
+//
+// The configuration is resolved and validated before anything is built, and
+// every invalid or missing variable is reported in a single *ConfigError.
 func InitLogger() error {
-	return initLogger(&EnvConfig{}, false)
+	cfg, err := NewEnvConfig()
+	if err != nil {
+		return err
+	}
+
+	return initLogger(cfg, false)
 }
 
 func initLogger(cfg Config, disableGraylog bool) error {
@@ -37,19 +45,9 @@ func initLogger(cfg Config, disableGraylog bool) error {
 		return setTestLogger(cfg)
 	}
 
-	// Create a console output enabled zapcore.
-	zapcore := enableConsoleLogging(cfg)
+	global.applyLevels(cfg)
 
-	// Check if Graylog host is defined
-	// if so return a Graylog Logger with
-	// console logging enabled.
-	graylogHost := cfg.getGraylogHost()
-	if graylogHost != "" && !disableGraylog {
-		return setGraylogLogger(cfg, zapcore)
-	}
-
-	// Return a console logger by default.
-	return setLoggerFromCore(zapcore)
+	return global.build(cfg, disableGraylog)
 }
 
 // getLogger is an internal function that returns an instantied Logger,
@@ -59,91 +57,88 @@ func initLogger(cfg Config, disableGraylog bool) error {
 // need an instaniated Logger to run. In this case we want to make sure we
 // use a no-op logger, to reduce test noise.
 func getLogger() *zap.Logger {
-	if logger == nil {
-		if err := InitLogger(); err != nil {
+	if global.config() == nil {
+		cfg, err := newInitConfig()
+		if err == nil {
+			err = initLogger(cfg, false)
+		}
+
+		if err != nil {
 			// If the logger fails to instaniate with it's current configuration
 			// attempt to initLogger and skip setting up Graylog. This is to prevent
 			// panicing and shutting down a service when Graylog experiences difficulties.
 			// If this also fails we have a problem unrelated to Graylog and we need
 			// to panic as logging has issues that are unrecoverable.
-			if err != initLogger(&EnvConfig{}, true) {
-				panic(err)
+			if fallbackErr := initLogger(cfg, true); fallbackErr != nil {
+				panic(fallbackErr)
 			}
-		}
-	}
-
-	return logger
-}
 
-func setGraylogLogger(cfg Config, consoleLoggingCore zapcore.Core) error {
-	graylog, err := NewGraylog(cfg)
-	if err != nil {
-		return err
+			global.Logger().Error("gzap failed to initialize Graylog, falling back to console logging", Error(err))
+		}
 	}
 
-	zapcore := zap.New(
-		zapcore.NewTee(
-			NewGelfCore(cfg, graylog),
-			consoleLoggingCore,
-		),
-		zap.AddCaller(),
-		zap.AddStacktrace(zapcore.ErrorLevel),
-		zap.Fields(
-			zapcore.Field{
-				Key:    "env",
-				String: cfg.getGraylogLogEnvName(),
-				Type:   zapcore.StringType,
-			},
-		),
-	)
-
-	logger = zapcore
-
-	return nil
+	return global.Logger()
 }
 
 func enableConsoleLogging(cfg Config) zapcore.Core {
-	consoleDebugging := zapcore.Lock(os.Stdout)
-	consoleErrors := zapcore.Lock(os.Stderr)
-	encoderConfig := zap.NewDevelopmentEncoderConfig()
-
-	// if ENABLE_DATADOG_JSON_FORMATTER then replace
-	// zapcore console log with zapcore json log
-	var logEncoder = zapcore.NewConsoleEncoder(encoderConfig)
-	if cfg.enableJSONFormatter() {
-		logEncoder = zapcore.NewJSONEncoder(encoderConfig)
-	}
-
-	if cfg.useColoredConsolelogs() {
-		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	}
+	return newConsoleCore(cfg, zapcore.Lock(os.Stdout), zapcore.Lock(os.Stderr))
+}
 
-	zapcore := zapcore.NewTee(
-		zapcore.NewCore(
+// newConsoleCore writes entries to stdout, or to stderr from the configured
+// stderr level.
+func newConsoleCore(cfg Config, consoleDebugging zapcore.WriteSyncer, consoleErrors zapcore.WriteSyncer) zapcore.Core {
+	opts := cfg.getConsoleOptions()
+	logEncoder := newConsoleEncoder(opts, cfg.useColoredConsolelogs())
+
+	// Entries at or above the stderr level, error by default, are written to
+	// stderr, the others to stdout.
+	highPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return lvl >= opts.stderrLevel
+	})
+	lowPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return lvl < opts.stderrLevel
+	})
+
+	rules := newRedactRules(cfg.getRedactRules())
+	return zapcore.NewTee(
+		newRedactCore(zapcore.NewCore(
 			logEncoder,
 			consoleDebugging,
 			lowPriority,
-		),
-		zapcore.NewCore(
+		), rules),
+		newRedactCore(zapcore.NewCore(
 			logEncoder,
 			consoleErrors,
 			highPriority,
-		),
+		), rules),
 	)
+}
+
+// staticFields returns the fields configured to be added to every entry,
+// sorted by key.
+func staticFields(cfg Config) []zapcore.Field {
+	configured := cfg.getStaticFields()
+
+	keys := make([]string, 0, len(configured))
+	for key := range configured {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := make([]zapcore.Field, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, String(key, configured[key]))
+	}
 
-	return zapcore
+	return fields
 }
 
 func setTestLogger(cfg Config) error {
-	zapNopLogger := zap.NewNop()
-	logger = zapNopLogger
+	global.setTestLogger()
 	return nil
 }
 
 func setLoggerFromCore(core zapcore.Core) error {
-	logger = zap.New(
-		core,
-		zap.AddCaller(),
-	)
+	global.setLoggerFromCore(core, nil)
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	graylog "github.com/Devatoria/go-graylog"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestInitLogger(t *testing.T) {
@@ -55,6 +56,15 @@ func TestInitLogger(t *testing.T) {
 			cfg.On("getGraylogHandlerType").Return(tt.args.graylogHandlerType)
 			cfg.On("getGraylogLogEnvName").Return(tt.args.graylogLogEnvName)
 			cfg.On("useColoredConsolelogs").Return(true)
+			cfg.On("getLevel").Return(zapcore.DebugLevel)
+			cfg.On("getLoggerLevels").Return(map[string]zapcore.Level{})
+			cfg.On("getStaticFields").Return(map[string]string{})
+			cfg.On("getRedactRules").Return([]string{})
+			cfg.On("getConsoleOptions").Return(defaultConsoleOptions())
+			cfg.On("getFileOptions").Return(fileOptions{})
+			cfg.On("getSyslogOptions").Return(syslogOptions{})
+			cfg.On("getHTTPBodyOptions").Return(httpBodyOptions{})
+			cfg.On("getSinks").Return([]sinkConfig(nil))
 
 			err := initLogger(&cfg, false)
 
@@ -74,7 +84,7 @@ func ExampleInitLogger() {
 		panic(err)
 	}
 
-	defer Logger.Sync()
+	defer L().Sync()
 
-	Logger.Info("this is a test info log")
+	L().Info("this is a test info log")
 }
@@ -0,0 +1,152 @@
+// Package gzaptest installs an in-memory logger as the global gzap logger for
+// the duration of a test, so that tests can assert what the code under test
+// logged:
+//
+//	func TestCharge(t *testing.T) {
+//		logs := gzaptest.New(t)
+//
+//		charge(ctx, order)
+//
+//		logs.AssertLogged(t, zapcore.ErrorLevel, "charge failed",
+//			gzap.String("order_id", order.ID),
+//		)
+//	}
+package gzaptest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dailymuse/gzap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// Options configures an Observer.
+type Options struct {
+	// Level enables the entries kept by the Observer, every level when unset.
+	Level zapcore.LevelEnabler
+
+	// LogOnFailure writes every observed entry to t.Log when the test fails.
+	LogOnFailure bool
+}
+
+// Observer keeps the entries written to the global logger in memory.
+// It is safe for concurrent use.
+type Observer struct {
+	logs *observer.ObservedLogs
+}
+
+// New installs an Observer as the global logger, see gzap.ReplaceGlobals,
+// until the end of the test.
+func New(t testing.TB) *Observer {
+	return NewWithOptions(t, Options{})
+}
+
+// NewWithOptions installs an Observer configured by opts as the global logger,
+// until the end of the test.
+func NewWithOptions(t testing.TB, opts Options) *Observer {
+	t.Helper()
+
+	if opts.Level == nil {
+		opts.Level = zapcore.DebugLevel
+	}
+
+	core, logs := observer.New(opts.Level)
+	o := &Observer{logs: logs}
+
+	restore := gzap.ReplaceGlobals(zap.New(core, zap.AddCaller()))
+	t.Cleanup(func() {
+		restore()
+
+		if opts.LogOnFailure && t.Failed() {
+			for _, entry := range o.Entries() {
+				t.Log(formatEntry(entry))
+			}
+		}
+	})
+
+	return o
+}
+
+// Entries returns every entry observed since the test started, or since the
+// last Reset.
+func (o *Observer) Entries() []observer.LoggedEntry {
+	return o.logs.All()
+}
+
+// FilterField returns the observed entries carrying field.
+func (o *Observer) FilterField(field zapcore.Field) []observer.LoggedEntry {
+	entries := []observer.LoggedEntry{}
+	for _, entry := range o.logs.All() {
+		if hasFields(entry, field) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Reset discards the observed entries.
+func (o *Observer) Reset() {
+	o.logs.TakeAll()
+}
+
+// AssertLogged reports a test failure unless an entry was logged at level,
+// with a message containing msg, and carrying every field in fields. It
+// returns whether the assertion held.
+func (o *Observer) AssertLogged(t testing.TB, level zapcore.Level, msg string, fields ...zapcore.Field) bool {
+	t.Helper()
+
+	entries := o.logs.All()
+	for _, entry := range entries {
+		if entry.Level == level && strings.Contains(entry.Message, msg) && hasFields(entry, fields...) {
+			return true
+		}
+	}
+
+	observed := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		observed = append(observed, "\t"+formatEntry(entry))
+	}
+
+	t.Errorf("gzaptest: no %s entry containing %q with fields %v, observed %d entries:\n%s",
+		level.CapitalString(), msg, fieldsMap(fields), len(entries), strings.Join(observed, "\n"))
+
+	return false
+}
+
+// hasFields reports whether entry carries every field, compared by their
+// encoded values so that e.g. gzap.Int and gzap.Int64 fields match.
+func hasFields(entry observer.LoggedEntry, fields ...zapcore.Field) bool {
+	context := entry.ContextMap()
+	for key, want := range fieldsMap(fields) {
+		got, ok := context[key]
+		if !ok || !reflect.DeepEqual(got, want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fieldsMap(fields []zapcore.Field) map[string]interface{} {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	return enc.Fields
+}
+
+func formatEntry(entry observer.LoggedEntry) string {
+	name := ""
+	if entry.LoggerName != "" {
+		name = entry.LoggerName + " "
+	}
+
+	return fmt.Sprintf("%s %s%s %v", entry.Level.CapitalString(), name, entry.Message, entry.ContextMap())
+}
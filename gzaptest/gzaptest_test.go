@@ -0,0 +1,75 @@
+package gzaptest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dailymuse/gzap"
+	"go.uber.org/zap/zapcore"
+)
+
+// recorder captures the failures reported by the helpers under test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestObserver(t *testing.T) {
+	previous := gzap.L()
+
+	t.Run("observe", func(t *testing.T) {
+		logs := New(t)
+
+		gzap.L().Info("request handled", gzap.Int("status", 200), gzap.String("path", "/jobs"))
+		gzap.L().Named("billing").Error("charge failed", gzap.String("order_id", "42"))
+
+		if !logs.AssertLogged(t, zapcore.ErrorLevel, "charge", gzap.String("order_id", "42")) {
+			t.Error("AssertLogged() expected to find the error entry")
+		}
+		logs.AssertLogged(t, zapcore.InfoLevel, "handled", gzap.Int64("status", 200))
+
+		r := &recorder{TB: t}
+		if logs.AssertLogged(r, zapcore.WarnLevel, "charge failed") {
+			t.Error("AssertLogged() expected no warn entry")
+		}
+		if logs.AssertLogged(r, zapcore.ErrorLevel, "charge failed", gzap.String("order_id", "43")) {
+			t.Error("AssertLogged() expected the fields to be compared")
+		}
+		expect(t, len(r.errors), 2)
+		if !strings.Contains(r.errors[0], "ERROR billing charge failed") {
+			t.Errorf("AssertLogged() expected the failure to list the observed entries; got %q", r.errors[0])
+		}
+
+		expect(t, len(logs.Entries()), 2)
+		expect(t, len(logs.FilterField(gzap.String("path", "/jobs"))), 1)
+
+		logs.Reset()
+		expect(t, len(logs.Entries()), 0)
+	})
+
+	if gzap.L() != previous {
+		t.Error("New() expected the previous global logger to be restored")
+	}
+}
+
+func TestObserver_Level(t *testing.T) {
+	logs := NewWithOptions(t, Options{Level: zapcore.WarnLevel})
+
+	gzap.L().Info("ignored")
+	gzap.L().Warn("kept")
+
+	expect(t, len(logs.Entries()), 1)
+}
+
+func expect(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Errorf("Expected %v - Got %v ", b, a)
+	}
+}
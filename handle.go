@@ -0,0 +1,297 @@
+package gzap
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Handle owns the cores behind a logger built by New. It changes their levels
+// at runtime, reloads their configuration, and flushes and closes them.
+//
+// A Handle is safe for concurrent use.
+type Handle struct {
+	core   *reloadableCore
+	levels *levelRegistry
+	logger atomic.Value // *zap.Logger
+
+	mu  sync.Mutex
+	cfg Config
+}
+
+func newHandle() *Handle {
+	h := &Handle{
+		core:   newReloadableCore(),
+		levels: newLevelRegistry(zapcore.DebugLevel),
+	}
+	h.logger.Store(zap.New(h.core))
+
+	return h
+}
+
+// New builds a logger from cfg, independent from the global Logger and from
+// any other logger built by New. This allows running differently configured
+// loggers side by side, e.g. an audit logger shipping to its own Graylog input:
+//
+//	cfg, err := gzap.LoadConfigFile("/etc/gzap/audit.json")
+//	if err != nil {
+//		panic(err)
+//	}
+//
+//	audit, handle, err := gzap.New(cfg)
+//	if err != nil {
+//		panic(err)
+//	}
+//	defer handle.Close()
+//
+// Unlike InitLogger, New always builds real cores, even when running tests.
+func New(cfg Config) (*zap.Logger, *Handle, error) {
+	h := newHandle()
+	h.applyLevels(cfg)
+
+	if err := h.build(cfg, false); err != nil {
+		return nil, nil, err
+	}
+
+	return h.Logger(), h, nil
+}
+
+// Logger returns the logger owned by the Handle. Loggers derived from it keep
+// following configuration reloads.
+func (h *Handle) Logger() *zap.Logger {
+	return h.logger.Load().(*zap.Logger)
+}
+
+// LevelHandler returns an http.Handler reporting and changing the levels of
+// the logger at runtime, see the package level LevelHandler.
+func (h *Handle) LevelHandler() http.Handler {
+	return h.levels
+}
+
+// Reload rebuilds the cores from cfg and swaps them into the logger, and
+// every logger derived from it. The replaced cores are drained in the
+// background.
+func (h *Handle) Reload(cfg Config) error {
+	_, err := h.reload(cfg)
+	return err
+}
+
+// Sync flushes the cores of the logger.
+func (h *Handle) Sync() error {
+	return h.core.Sync()
+}
+
+// Close flushes and closes the cores of the logger. Entries logged afterwards
+// are discarded.
+func (h *Handle) Close() error {
+	return h.core.close()
+}
+
+func (h *Handle) config() Config {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.cfg
+}
+
+// configSource returns where the configuration of the handle can be read
+// again from. The global Logger is always configured by the environment.
+func (h *Handle) configSource() (configSource, bool) {
+	if h == global {
+		return configSource{env: true}, true
+	}
+
+	cfg, ok := h.config().(*EnvConfig)
+	if !ok || cfg.source == (configSource{}) {
+		return configSource{}, false
+	}
+
+	return cfg.source, true
+}
+
+// reload rebuilds the cores from cfg, and returns the settings that changed.
+func (h *Handle) reload(cfg Config) ([]string, error) {
+	previous := h.config()
+	if err := h.build(cfg, false); err != nil {
+		return nil, err
+	}
+
+	changes := diffConfigs(previous, cfg)
+	if levelsChanged(changes) {
+		h.applyLevels(cfg)
+	}
+
+	return changes, nil
+}
+
+// applyLevels resets the global and per-logger levels to the configured ones,
+// dropping any change made at runtime.
+func (h *Handle) applyLevels(cfg Config) {
+	h.levels.reset(cfg.getLevel(), levelConfigured(cfg))
+	for name, lvl := range cfg.getLoggerLevels() {
+		h.levels.setLogger(name, lvl, 0)
+	}
+}
+
+// build builds the cores described by cfg and swaps them into the logger.
+// Runtime levels are left untouched.
+func (h *Handle) build(cfg Config, disableGraylog bool) error {
+	var err error
+	if sinks := cfg.getSinks(); len(sinks) > 0 {
+		err = h.setSinksLogger(cfg, sinks, disableGraylog)
+	} else {
+		err = h.setDefaultLogger(cfg, disableGraylog)
+	}
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.cfg = cfg
+	h.mu.Unlock()
+
+	return nil
+}
+
+// setDefaultLogger builds the console output, teed with the log file, syslog
+// and Graylog when they are configured.
+func (h *Handle) setDefaultLogger(cfg Config, disableGraylog bool) error {
+	// Create a console output enabled zapcore.
+	consoleCore := enableConsoleLogging(cfg)
+
+	// Tee the log file and syslog, if any, with the console output.
+	cores := []zapcore.Core{consoleCore}
+	closers := []func() error{}
+	for _, enable := range []func(Config) (zapcore.Core, func() error, error){enableFileLogging, enableSyslogLogging} {
+		core, closer, err := enable(cfg)
+		if err != nil {
+			closeAll(closers...)()
+			return err
+		}
+		if core != nil {
+			cores = append(cores, core)
+			closers = append(closers, closer)
+		}
+	}
+	consoleCore = zapcore.NewTee(cores...)
+	closeSinks := closeAll(closers...)
+
+	// Check if Graylog host is defined
+	// if so return a Graylog Logger with
+	// console logging enabled.
+	graylogHost := cfg.getGraylogHost()
+	if graylogHost != "" && !disableGraylog {
+		if err := h.setGraylogLogger(cfg, consoleCore, closeSinks); err != nil {
+			closeSinks()
+			return err
+		}
+	} else {
+		// Return a console logger by default.
+		h.setLoggerFromCore(consoleCore.With(staticFields(cfg)), closeSinks)
+	}
+
+	return nil
+}
+
+// setSinksLogger tees the sinks listed in GZAP_SINKS, which replace the
+// console, file, syslog and Graylog outputs. Stack traces are added from the
+// error level when shipping to Graylog, as with GRAYLOG_HOST, or to Sentry,
+// see sinkNeedsStacktrace.
+func (h *Handle) setSinksLogger(cfg Config, sinks []sinkConfig, disableGraylog bool) error {
+	core, closeSinks, err := openSinks(cfg, sinks, h.levels, disableGraylog)
+	if err != nil {
+		return err
+	}
+
+	options := []zap.Option{zap.AddCaller()}
+	for _, sink := range sinks {
+		if sinkNeedsStacktrace(sink) && !(disableGraylog && isGelfSink(sink)) {
+			options = append(options, zap.AddStacktrace(zapcore.ErrorLevel))
+			break
+		}
+	}
+
+	h.core.swap(newLevelFilterCore(core.With(staticFields(cfg)), h.levels), closeSinks)
+	h.setLogger(zap.New(h.core, options...))
+
+	return nil
+}
+
+// setGraylogLogger tees a Graylog core with consoleLoggingCore. closeConsole,
+// if not nil, is called along with closing the Graylog client.
+func (h *Handle) setGraylogLogger(cfg Config, consoleLoggingCore zapcore.Core, closeConsole func() error) error {
+	graylog, err := NewGraylog(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Levels are controlled by the logger wide filter, see LevelHandler.
+	gelfCore := NewGelfCore(cfg, graylog)
+	gelfCore.level = zapcore.DebugLevel
+
+	core := newLevelFilterCore(
+		zapcore.NewTee(
+			newGraylogLevelCore(newRedactCore(gelfCore, newRedactRules(cfg.getRedactRules())), h.levels),
+			consoleLoggingCore,
+		),
+		h.levels,
+	).With(append(staticFields(cfg), zapcore.Field{
+		Key:    "env",
+		String: cfg.getGraylogLogEnvName(),
+		Type:   zapcore.StringType,
+	}))
+
+	h.core.swap(core, closeAll(graylog.Close, closeConsole))
+	h.setLogger(zap.New(
+		h.core,
+		zap.AddCaller(),
+		zap.AddStacktrace(zapcore.ErrorLevel),
+	))
+
+	return nil
+}
+
+func (h *Handle) setTestLogger() {
+	h.core.swap(zapcore.NewNopCore(), nil)
+	h.setLogger(zap.New(h.core))
+}
+
+// setLoggerFromCore swaps core into the logger. closer, if not nil, is called
+// once core has been replaced in turn.
+func (h *Handle) setLoggerFromCore(core zapcore.Core, closer func() error) {
+	h.core.swap(newLevelFilterCore(core, h.levels), closer)
+	h.setLogger(zap.New(
+		h.core,
+		zap.AddCaller(),
+	))
+}
+
+// setLogger replaces the logger owned by the Handle. Loggers already handed
+// out keep working, as they share its cores.
+func (h *Handle) setLogger(logger *zap.Logger) {
+	h.logger.Store(logger)
+	if h == global {
+		refreshLogger()
+	}
+}
+
+// closeAll returns a func calling every closer that is not nil, and returning
+// the first error.
+func closeAll(closers ...func() error) func() error {
+	return func() error {
+		var err error
+		for _, closer := range closers {
+			if closer == nil {
+				continue
+			}
+			if closeErr := closer(); err == nil {
+				err = closeErr
+			}
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,76 @@
+package gzap
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newTestHandle returns a Handle logging to the console with the default
+// configuration.
+func newTestHandle(t *testing.T) *Handle {
+	cfg, err := NewConfig(FileConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func TestNew(t *testing.T) {
+	t.Run("New should return independent loggers", func(t *testing.T) {
+		t.Parallel()
+
+		cfg, err := NewConfig(FileConfig{Level: "warn", Fields: map[string]string{"logger": "audit"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		audit, auditHandle, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer auditHandle.Close()
+
+		_, appHandle, err := New(&EnvConfig{level: zapcore.DebugLevel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer appHandle.Close()
+
+		expect(t, audit.Core().Enabled(zapcore.InfoLevel), false)
+		expect(t, appHandle.Logger().Core().Enabled(zapcore.DebugLevel), true)
+	})
+
+	t.Run("NewConfig should report invalid configurations", func(t *testing.T) {
+		t.Parallel()
+
+		cfg, err := NewConfig(FileConfig{Graylog: GraylogFileConfig{Host: "graylog.example.com"}})
+		if err == nil {
+			t.Fatal("NewConfig() expected an error when Graylog is missing its app name")
+		}
+		expect(t, cfg.getGraylogHost(), "graylog.example.com")
+	})
+}
+
+func TestHandle_Close(t *testing.T) {
+	h := newHandle()
+	core, logs := observer.New(zapcore.DebugLevel)
+	h.setLoggerFromCore(core, nil)
+
+	l := h.Logger().Named("derived")
+	l.Info("before close")
+
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("after close")
+
+	expect(t, logs.Len(), 1)
+}
@@ -0,0 +1,352 @@
+package gzap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultHTTPBodyStatuses are the status classes whose bodies are captured
+// unless GZAP_HTTP_BODY_STATUSES says otherwise.
+var defaultHTTPBodyStatuses = []string{"4xx", "5xx"}
+
+// httpBodyOptions configures the capture of request and response bodies by
+// DatadogRequestLoggerMiddleware.
+type httpBodyOptions struct {
+	// maxBytes is the number of bytes kept of each body, 0 disabling the
+	// capture.
+	maxBytes int
+
+	// statuses are the status classes, e.g. "4xx", and status codes of the
+	// responses whose bodies are captured.
+	statuses []string
+
+	// redact holds the paths of the values replaced by [REDACTED], split into
+	// lower case segments which may use the wildcards of path.Match.
+	redact [][]string
+
+	// keys are the GZAP_REDACT rules, redacting the values of matching keys
+	// at any depth.
+	keys redactRules
+}
+
+func parseHTTPBodyOptions(r *configResolver, errs *ConfigError, redactRules []string) httpBodyOptions {
+	opts := httpBodyOptions{statuses: defaultHTTPBodyStatuses}
+	if len(redactRules) > 0 {
+		opts.keys = newRedactRules(redactRules)
+	}
+
+	if text := r.get("GZAP_HTTP_BODY_MAX_BYTES"); text != "" {
+		size, err := parseSize(text)
+		if err != nil {
+			errs.add("GZAP_HTTP_BODY_MAX_BYTES", err.Error())
+		} else {
+			opts.maxBytes = int(size)
+		}
+	}
+
+	if statuses := r.getList("GZAP_HTTP_BODY_STATUSES"); len(statuses) > 0 {
+		opts.statuses = nil
+		for _, status := range statuses {
+			status = strings.ToLower(status)
+			if !isHTTPStatusSelector(status) {
+				errs.add("GZAP_HTTP_BODY_STATUSES", fmt.Sprintf("expected a status class such as \"4xx\" or a status code, got %q", status))
+				continue
+			}
+			opts.statuses = append(opts.statuses, status)
+		}
+	}
+
+	for _, text := range r.getList("GZAP_HTTP_BODY_REDACT") {
+		segments, err := parseHTTPBodyPath(text)
+		if err != nil {
+			errs.add("GZAP_HTTP_BODY_REDACT", err.Error())
+			continue
+		}
+		opts.redact = append(opts.redact, segments)
+	}
+
+	return opts
+}
+
+// isHTTPStatusSelector reports whether status is a status class, "1xx" to
+// "5xx", or a status code.
+func isHTTPStatusSelector(status string) bool {
+	if len(status) == 3 && status[1:] == "xx" {
+		return status[0] >= '1' && status[0] <= '5'
+	}
+
+	code, err := strconv.Atoi(status)
+	return err == nil && code >= 100 && code <= 599
+}
+
+// parseHTTPBodyPath splits a JSON path such as "$.user.password" or
+// "items[*].card" into lower case segments. Arrays are traversed without
+// segments of their own, so "items.card" is the same path.
+func parseHTTPBodyPath(text string) ([]string, error) {
+	normalized := strings.TrimPrefix(strings.TrimPrefix(text, "$"), ".")
+	normalized = strings.Replace(normalized, "[*]", "", -1)
+
+	segments := strings.Split(strings.ToLower(normalized), ".")
+	for _, segment := range segments {
+		if segment == "" || strings.ContainsAny(segment, "[]") {
+			return nil, fmt.Errorf("could not parse %q as a JSON path, e.g. \"user.password\"", text)
+		}
+	}
+
+	return segments, nil
+}
+
+// captures reports whether the bodies of a response with the given status
+// are captured.
+func (o httpBodyOptions) captures(status int) bool {
+	code := strconv.Itoa(status)
+	for _, selector := range o.statuses {
+		if selector == code || selector[1:] == "xx" && selector[0] == code[0] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// httpBodyKind returns "json" or "form" for the content types whose bodies
+// are captured, and "" for the others.
+func httpBodyKind(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	case mediaType == "application/x-www-form-urlencoded":
+		return "form"
+	}
+
+	return ""
+}
+
+// cappedBuffer keeps the first max bytes written to it, and drops the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.Len(); n > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.Buffer.Write(p)
+
+	return n, nil
+}
+
+// httpBodyCapture records the bodies of a request and of its response, as
+// far as the handler reads and writes them.
+type httpBodyCapture struct {
+	opts httpBodyOptions
+
+	requestKind string
+	request     *cappedBuffer
+	response    *cappedBuffer
+	writer      *bodyCaptureWriter
+}
+
+// newHTTPBodyCapture tees the body of r, when it is a JSON or form body, and
+// wraps rw to buffer the response body.
+func newHTTPBodyCapture(opts httpBodyOptions, rw ResponseWriter, r *http.Request) (*httpBodyCapture, ResponseWriter, *http.Request) {
+	c := &httpBodyCapture{
+		opts:        opts,
+		requestKind: httpBodyKind(r.Header.Get("Content-Type")),
+		response:    &cappedBuffer{max: opts.maxBytes},
+	}
+	c.writer = &bodyCaptureWriter{ResponseWriter: rw, body: c.response}
+
+	if c.requestKind != "" && r.Body != nil {
+		c.request = &cappedBuffer{max: opts.maxBytes}
+		body := r.Body
+		r = r.WithContext(r.Context())
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(body, c.request), body}
+	}
+
+	return c, c.writer, r
+}
+
+// fields returns the http.request.body and http.response.body fields, if the
+// bodies are captured for this status.
+func (c *httpBodyCapture) fields(status int) []zapcore.Field {
+	if !c.opts.captures(status) {
+		return nil
+	}
+
+	var fields []zapcore.Field
+	if c.request != nil && c.request.Len() > 0 {
+		fields = append(fields, c.bodyFields("http.request.body", c.requestKind, c.request)...)
+	}
+
+	responseKind := httpBodyKind(c.writer.Header().Get("Content-Type"))
+	if responseKind != "" && c.response.Len() > 0 {
+		fields = append(fields, c.bodyFields("http.response.body", responseKind, c.response)...)
+	}
+
+	return fields
+}
+
+func (c *httpBodyCapture) bodyFields(key string, kind string, body *cappedBuffer) []zapcore.Field {
+	fields := []zapcore.Field{String(key, redactHTTPBody(kind, body.Bytes(), c.opts.keys, c.opts.redact))}
+	if body.truncated {
+		fields = append(fields, Bool(key+"_truncated", true))
+	}
+
+	return fields
+}
+
+// redactHTTPBody replaces the values of the keys matching keys, at any
+// depth, and those at the given paths of a JSON or form body. Bodies that
+// can't be parsed, such as truncated JSON, are redacted entirely when there
+// is anything to redact.
+func redactHTTPBody(kind string, body []byte, keys redactRules, paths [][]string) string {
+	if len(keys) == 0 && len(paths) == 0 {
+		return string(body)
+	}
+
+	if kind == "form" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return redactedValue
+		}
+
+		for key := range values {
+			if keys.match(key) {
+				values[key] = []string{redactedValue}
+			}
+			for _, segments := range paths {
+				if len(segments) == 1 && (redactRules{segments[0]}).match(key) {
+					values[key] = []string{redactedValue}
+				}
+			}
+		}
+
+		return values.Encode()
+	}
+
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return redactedValue
+	}
+
+	value = redactJSONKeys(value, keys)
+	for _, segments := range paths {
+		value = redactJSONPath(value, segments)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return redactedValue
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// redactJSONKeys replaces the values of the keys matching keys, looking into
+// every object and array.
+func redactJSONKeys(value interface{}, keys redactRules) interface{} {
+	if len(keys) == 0 {
+		return value
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		for i := range value {
+			value[i] = redactJSONKeys(value[i], keys)
+		}
+	case map[string]interface{}:
+		for key, child := range value {
+			if keys.match(key) {
+				value[key] = redactedValue
+			} else {
+				value[key] = redactJSONKeys(child, keys)
+			}
+		}
+	}
+
+	return value
+}
+
+// redactJSONPath replaces the values at the path given by segments, looking
+// into every element of the arrays along the way.
+func redactJSONPath(value interface{}, segments []string) interface{} {
+	switch value := value.(type) {
+	case []interface{}:
+		for i := range value {
+			value[i] = redactJSONPath(value[i], segments)
+		}
+	case map[string]interface{}:
+		for key, child := range value {
+			if !(redactRules{segments[0]}).match(key) {
+				continue
+			}
+
+			if len(segments) == 1 {
+				value[key] = redactedValue
+			} else {
+				value[key] = redactJSONPath(child, segments[1:])
+			}
+		}
+	}
+
+	return value
+}
+
+// bodyCaptureWriter buffers the beginning of the response body, on its way
+// to the wrapped ResponseWriter.
+type bodyCaptureWriter struct {
+	ResponseWriter
+	body *cappedBuffer
+}
+
+func (w *bodyCaptureWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+
+	return n, err
+}
+
+// Flush implements http.Flusher, for the writers that support it.
+func (w *bodyCaptureWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, for the writers that support it.
+func (w *bodyCaptureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+	}
+
+	return hijacker.Hijack()
+}
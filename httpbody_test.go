@@ -0,0 +1,150 @@
+package gzap
+
+import "testing"
+
+func TestHTTPBodyOptions_Captures(t *testing.T) {
+	opts := httpBodyOptions{statuses: []string{"5xx", "409"}}
+
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{200, false},
+		{404, false},
+		{409, true},
+		{500, true},
+		{503, true},
+		{0, false},
+	}
+
+	for _, tt := range tests {
+		if got := opts.captures(tt.status); got != tt.want {
+			t.Errorf("captures(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPBodyKind(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+	}{
+		{"application/json", "json"},
+		{"application/json; charset=utf-8", "json"},
+		{"application/vnd.api+json", "json"},
+		{"application/x-www-form-urlencoded", "form"},
+		{"multipart/form-data; boundary=x", ""},
+		{"text/plain", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		expect(t, httpBodyKind(tt.contentType), tt.want)
+	}
+}
+
+func TestRedactHTTPBody(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  string
+		body  string
+		paths [][]string
+		want  string
+	}{
+		{
+			"Bodies should be kept as is without paths",
+			"json",
+			`{"b": 1, "a": "<x>"}`,
+			nil,
+			`{"b": 1, "a": "<x>"}`,
+		},
+		{
+			"Nested values should be redacted",
+			"json",
+			`{"user":{"name":"jane","Token":"abc"},"amount":12.50}`,
+			[][]string{{"user", "token"}},
+			`{"amount":12.50,"user":{"Token":"[REDACTED]","name":"jane"}}`,
+		},
+		{
+			"Arrays should be traversed",
+			"json",
+			`[{"cards":[{"number":"4242","cvc":"123"}]}]`,
+			[][]string{{"cards", "number"}, {"cards", "cvc"}},
+			`[{"cards":[{"cvc":"[REDACTED]","number":"[REDACTED]"}]}]`,
+		},
+		{
+			"Wildcards should match keys",
+			"json",
+			`{"auth_token":"abc","refresh_token":"def","user":"<jane>"}`,
+			[][]string{{"*token"}},
+			`{"auth_token":"[REDACTED]","refresh_token":"[REDACTED]","user":"<jane>"}`,
+		},
+		{
+			"Invalid JSON should be redacted entirely",
+			"json",
+			`{"password":"hun`,
+			[][]string{{"password"}},
+			redactedValue,
+		},
+		{
+			"Form values should be redacted",
+			"form",
+			"password=hunter2&user=jane",
+			[][]string{{"password"}, {"user", "name"}},
+			"password=%5BREDACTED%5D&user=jane",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, redactHTTPBody(tt.kind, []byte(tt.body), nil, tt.paths), tt.want)
+		})
+	}
+}
+
+func TestRedactHTTPBody_Keys(t *testing.T) {
+	keys := newRedactRules([]string{"password", "*TOKEN*"})
+
+	tests := []struct {
+		name  string
+		kind  string
+		body  string
+		paths [][]string
+		want  string
+	}{
+		{
+			"Matching keys should be redacted at any depth",
+			"json",
+			`{"password":"hunter2","user":{"Password":"hunter2","api_token":"abc","name":"jane"},"items":[{"password":"x"}]}`,
+			nil,
+			`{"items":[{"password":"[REDACTED]"}],"password":"[REDACTED]","user":{"Password":"[REDACTED]","api_token":"[REDACTED]","name":"jane"}}`,
+		},
+		{
+			"Paths should be redacted along with the keys",
+			"json",
+			`{"password":"hunter2","cards":[{"number":"4242"}]}`,
+			[][]string{{"cards", "number"}},
+			`{"cards":[{"number":"[REDACTED]"}],"password":"[REDACTED]"}`,
+		},
+		{
+			"Invalid JSON should be redacted entirely",
+			"json",
+			`{"user":"ja`,
+			nil,
+			redactedValue,
+		},
+		{
+			"Form values should be redacted",
+			"form",
+			"password=hunter2&user=jane",
+			nil,
+			"password=%5BREDACTED%5D&user=jane",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, redactHTTPBody(tt.kind, []byte(tt.body), keys, tt.paths), tt.want)
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package gzap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// LevelHandler returns an http.Handler reporting and changing the levels of
+// the global Logger at runtime.
+//
+// GET responds with the global level and every per-logger override:
+//
+//	{"level":"info","loggers":{"db":{"level":"debug","expires":"2026-10-19T10:15:00Z"}}}
+//
+// PUT changes the global level, or the level of a named logger (and its
+// children, so "db" also applies to "db.pool"). An optional ttl reverts the
+// change automatically once it elapses:
+//
+//	{"level":"debug","ttl":"15m"}
+//	{"logger":"db","level":"debug","ttl":"15m"}
+//
+// Sending a logger without a level removes its override.
+func LevelHandler() http.Handler {
+	return global.LevelHandler()
+}
+
+// levelState is the current level of either the global logger, or a named
+// logger override, along with its pending auto-revert if any.
+type levelState struct {
+	level   zapcore.Level
+	expires time.Time
+	timer   *time.Timer
+
+	// configured is false for the default global level, which only applies
+	// to the console, see graylogLevelCore.
+	configured bool
+
+	// revertTo is the state restored when the ttl elapses, nil removes the
+	// override of a named logger altogether.
+	revertTo *levelState
+}
+
+// levelRegistry stores the global level and the per-logger-name overrides.
+//
+// Reads happen on every log call, so the global level and the presence of
+// overrides are kept in atomics, and the lock is only taken when overrides
+// actually exist.
+type levelRegistry struct {
+	mu      sync.RWMutex
+	global  *levelState
+	loggers map[string]*levelState
+
+	globalLevel      int32
+	globalConfigured int32
+	minLevel         int32
+	hasOverrides     int32
+}
+
+func newLevelRegistry(lvl zapcore.Level) *levelRegistry {
+	r := &levelRegistry{}
+	r.reset(lvl, false)
+	return r
+}
+
+// reset sets the global level, configured or the default one, and drops
+// every override and pending revert.
+func (r *levelRegistry) reset(lvl zapcore.Level, configured bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.global != nil {
+		stopRevert(r.global)
+	}
+	for _, state := range r.loggers {
+		stopRevert(state)
+	}
+
+	r.global = &levelState{level: lvl, configured: configured}
+	r.loggers = map[string]*levelState{}
+	r.refresh()
+}
+
+// enabled reports whether an entry of the given logger name and level passes.
+func (r *levelRegistry) enabled(name string, lvl zapcore.Level) bool {
+	if name != "" && atomic.LoadInt32(&r.hasOverrides) == 1 {
+		r.mu.RLock()
+		state := r.lookup(name)
+		r.mu.RUnlock()
+
+		if state != nil {
+			return state.level.Enabled(lvl)
+		}
+	}
+
+	return zapcore.Level(atomic.LoadInt32(&r.globalLevel)).Enabled(lvl)
+}
+
+// configured reports whether the level applying to the given logger name was
+// configured, rather than being the default global level.
+func (r *levelRegistry) configured(name string) bool {
+	if name != "" && atomic.LoadInt32(&r.hasOverrides) == 1 {
+		r.mu.RLock()
+		state := r.lookup(name)
+		r.mu.RUnlock()
+
+		if state != nil {
+			return true
+		}
+	}
+
+	return atomic.LoadInt32(&r.globalConfigured) == 1
+}
+
+// Enabled reports whether any logger could log at the given level.
+func (r *levelRegistry) Enabled(lvl zapcore.Level) bool {
+	return zapcore.Level(atomic.LoadInt32(&r.minLevel)).Enabled(lvl)
+}
+
+// lookup returns the override of the closest named ancestor of name. It must
+// be called with the lock held.
+func (r *levelRegistry) lookup(name string) *levelState {
+	for {
+		if state, ok := r.loggers[name]; ok {
+			return state
+		}
+
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			return nil
+		}
+		name = name[:i]
+	}
+}
+
+// setGlobal changes the global level, reverting it after ttl if non-zero.
+func (r *levelRegistry) setGlobal(lvl zapcore.Level, ttl time.Duration) *levelState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.global = r.transition(r.global, lvl, ttl, func(state *levelState) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.global == state {
+			r.global = state.revertTo
+			r.refresh()
+		}
+	})
+	r.refresh()
+
+	return r.global
+}
+
+// setLogger overrides the level of a named logger, reverting it after ttl if
+// non-zero.
+func (r *levelRegistry) setLogger(name string, lvl zapcore.Level, ttl time.Duration) *levelState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := r.transition(r.loggers[name], lvl, ttl, func(state *levelState) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.loggers[name] != state {
+			return
+		}
+
+		if state.revertTo == nil {
+			delete(r.loggers, name)
+		} else {
+			r.loggers[name] = state.revertTo
+		}
+		r.refresh()
+	})
+	r.loggers[name] = state
+	r.refresh()
+
+	return state
+}
+
+// removeLogger drops the override of a named logger.
+func (r *levelRegistry) removeLogger(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if state, ok := r.loggers[name]; ok {
+		stopRevert(state)
+		delete(r.loggers, name)
+		r.refresh()
+	}
+}
+
+// transition builds the state replacing current. When a revert is already
+// pending its target is kept, so that extending a temporary level still ends
+// up back at the original one.
+func (r *levelRegistry) transition(current *levelState, lvl zapcore.Level, ttl time.Duration, revert func(*levelState)) *levelState {
+	next := &levelState{level: lvl, configured: true}
+
+	if current != nil {
+		stopRevert(current)
+		next.revertTo = current
+		if !current.expires.IsZero() {
+			next.revertTo = current.revertTo
+		}
+	}
+
+	if ttl <= 0 {
+		next.revertTo = nil
+		return next
+	}
+
+	next.expires = time.Now().Add(ttl)
+	next.timer = time.AfterFunc(ttl, func() { revert(next) })
+
+	return next
+}
+
+func stopRevert(state *levelState) {
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+}
+
+// refresh recomputes the atomics read on the hot path. It must be called with
+// the lock held.
+func (r *levelRegistry) refresh() {
+	min := r.global.level
+	for _, state := range r.loggers {
+		if state.level < min {
+			min = state.level
+		}
+	}
+
+	hasOverrides := int32(0)
+	if len(r.loggers) > 0 {
+		hasOverrides = 1
+	}
+
+	globalConfigured := int32(0)
+	if r.global.configured {
+		globalConfigured = 1
+	}
+
+	atomic.StoreInt32(&r.globalLevel, int32(r.global.level))
+	atomic.StoreInt32(&r.globalConfigured, globalConfigured)
+	atomic.StoreInt32(&r.minLevel, int32(min))
+	atomic.StoreInt32(&r.hasOverrides, hasOverrides)
+}
+
+type levelPayload struct {
+	Logger string         `json:"logger,omitempty"`
+	Level  *zapcore.Level `json:"level,omitempty"`
+	TTL    string         `json:"ttl,omitempty"`
+}
+
+type levelStatus struct {
+	Level   zapcore.Level `json:"level"`
+	Expires *time.Time    `json:"expires,omitempty"`
+}
+
+type levelsResponse struct {
+	levelStatus
+	Loggers map[string]levelStatus `json:"loggers,omitempty"`
+}
+
+type levelError struct {
+	Error string `json:"error"`
+}
+
+func newLevelStatus(state *levelState) levelStatus {
+	status := levelStatus{Level: state.level}
+	if !state.expires.IsZero() {
+		expires := state.expires.UTC()
+		status.Expires = &expires
+	}
+
+	return status
+}
+
+func (r *levelRegistry) status() levelsResponse {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := levelsResponse{
+		levelStatus: newLevelStatus(r.global),
+		Loggers:     make(map[string]levelStatus, len(r.loggers)),
+	}
+	for name, state := range r.loggers {
+		res.Loggers[name] = newLevelStatus(state)
+	}
+
+	return res
+}
+
+// ServeHTTP implements the endpoint documented on LevelHandler.
+func (r *levelRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	enc := json.NewEncoder(w)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch req.Method {
+	case http.MethodGet:
+		enc.Encode(r.status())
+
+	case http.MethodPut:
+		if err := r.apply(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			enc.Encode(levelError{Error: err.Error()})
+			return
+		}
+		enc.Encode(r.status())
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		enc.Encode(levelError{Error: "Only GET and PUT are supported."})
+	}
+}
+
+func (r *levelRegistry) apply(req *http.Request) error {
+	var payload levelPayload
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Request body must be well-formed JSON: %v", err)
+	}
+
+	var ttl time.Duration
+	if payload.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(payload.TTL); err != nil || ttl < 0 {
+			return fmt.Errorf("ttl must be a positive duration, got %q", payload.TTL)
+		}
+	}
+
+	if payload.Logger == "" {
+		if payload.Level == nil {
+			return fmt.Errorf("Must specify a logging level.")
+		}
+
+		r.setGlobal(*payload.Level, ttl)
+		return nil
+	}
+
+	if payload.Level == nil {
+		r.removeLogger(payload.Logger)
+		return nil
+	}
+
+	r.setLogger(payload.Logger, *payload.Level, ttl)
+	return nil
+}
+
+// levelFilterCore wraps a zapcore.Core, only passing down the entries enabled
+// by a levelRegistry.
+type levelFilterCore struct {
+	zapcore.Core
+	levels *levelRegistry
+}
+
+func newLevelFilterCore(core zapcore.Core, levels *levelRegistry) zapcore.Core {
+	return levelFilterCore{Core: core, levels: levels}
+}
+
+// Enabled reports whether any logger could log at the given level.
+func (c levelFilterCore) Enabled(lvl zapcore.Level) bool {
+	return c.levels.Enabled(lvl) && c.Core.Enabled(lvl)
+}
+
+// With adds structured context to the wrapped core.
+func (c levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelFilterCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+// Check only passes the entry to the wrapped core if the level of its logger
+// name enables it.
+func (c levelFilterCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.levels.enabled(entry.LoggerName, entry.Level) {
+		return checkedEntry
+	}
+
+	return c.Core.Check(entry, checkedEntry)
+}
+
+// graylogLevelCore keeps the entries below info away from Graylog, as the
+// GELF core always did, unless the level enabling them was configured with
+// GZAP_LEVEL, GZAP_LOGGER_LEVELS or the LevelHandler. Debug entries only
+// reach the console by default.
+type graylogLevelCore struct {
+	zapcore.Core
+	levels *levelRegistry
+}
+
+func newGraylogLevelCore(core zapcore.Core, levels *levelRegistry) zapcore.Core {
+	return graylogLevelCore{Core: core, levels: levels}
+}
+
+// With adds structured context to the wrapped core.
+func (c graylogLevelCore) With(fields []zapcore.Field) zapcore.Core {
+	return graylogLevelCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+// Check only passes the entries below info to the wrapped core if their level
+// was configured.
+func (c graylogLevelCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !zapcore.InfoLevel.Enabled(entry.Level) && !c.levels.configured(entry.LoggerName) {
+		return checkedEntry
+	}
+
+	return c.Core.Check(entry, checkedEntry)
+}
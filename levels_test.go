@@ -0,0 +1,177 @@
+package gzap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func serveLevels(t *testing.T, r *levelRegistry, method string, body string) (int, levelsResponse) {
+	req := httptest.NewRequest(method, "/log/level", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+
+	var res levelsResponse
+	if recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return recorder.Code, res
+}
+
+func TestLevelHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantLevel  zapcore.Level
+		wantLogger map[string]zapcore.Level
+	}{
+		{
+			"GET should report the current levels",
+			http.MethodGet,
+			"",
+			http.StatusOK,
+			zapcore.InfoLevel,
+			map[string]zapcore.Level{},
+		},
+		{
+			"PUT should change the global level",
+			http.MethodPut,
+			`{"level":"debug"}`,
+			http.StatusOK,
+			zapcore.DebugLevel,
+			map[string]zapcore.Level{},
+		},
+		{
+			"PUT should override the level of a named logger",
+			http.MethodPut,
+			`{"logger":"db","level":"error"}`,
+			http.StatusOK,
+			zapcore.InfoLevel,
+			map[string]zapcore.Level{"db": zapcore.ErrorLevel},
+		},
+		{
+			"PUT should reject an unknown level",
+			http.MethodPut,
+			`{"level":"chatty"}`,
+			http.StatusBadRequest,
+			zapcore.InfoLevel,
+			nil,
+		},
+		{
+			"PUT should reject an invalid ttl",
+			http.MethodPut,
+			`{"level":"debug","ttl":"forever"}`,
+			http.StatusBadRequest,
+			zapcore.InfoLevel,
+			nil,
+		},
+		{
+			"POST should not be allowed",
+			http.MethodPost,
+			`{"level":"debug"}`,
+			http.StatusMethodNotAllowed,
+			zapcore.InfoLevel,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			levels := newLevelRegistry(zapcore.InfoLevel)
+
+			status, res := serveLevels(t, levels, tt.method, tt.body)
+			expect(t, status, tt.wantStatus)
+
+			_, res = serveLevels(t, levels, http.MethodGet, "")
+			expect(t, res.Level, tt.wantLevel)
+
+			if tt.wantLogger != nil {
+				expect(t, len(res.Loggers), len(tt.wantLogger))
+				for name, lvl := range tt.wantLogger {
+					expect(t, res.Loggers[name].Level, lvl)
+				}
+			}
+		})
+	}
+}
+
+func TestLevelHandler_TTL(t *testing.T) {
+	levels := newLevelRegistry(zapcore.InfoLevel)
+
+	serveLevels(t, levels, http.MethodPut, `{"level":"debug","ttl":"50ms"}`)
+	// Extending a temporary level must still revert to the original one.
+	_, res := serveLevels(t, levels, http.MethodPut, `{"level":"debug","ttl":"100ms"}`)
+	expect(t, res.Level, zapcore.DebugLevel)
+	if res.Expires == nil {
+		t.Fatal("expected the temporary level to report its expiry")
+	}
+
+	serveLevels(t, levels, http.MethodPut, `{"logger":"db","level":"debug","ttl":"50ms"}`)
+
+	time.Sleep(200 * time.Millisecond)
+
+	_, res = serveLevels(t, levels, http.MethodGet, "")
+	expect(t, res.Level, zapcore.InfoLevel)
+	expect(t, res.Expires == nil, true)
+	expect(t, len(res.Loggers), 0)
+}
+
+func TestLevelFilterCore(t *testing.T) {
+	levels := newLevelRegistry(zapcore.InfoLevel)
+	levels.setLogger("db", zapcore.DebugLevel, 0)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := zap.New(newLevelFilterCore(core, levels))
+
+	l.Debug("dropped")
+	l.Named("http").Debug("dropped")
+	l.Named("db").Debug("kept")
+	l.Named("db").Named("pool").Debug("kept by parent")
+	l.Info("kept")
+
+	expect(t, logs.Len(), 3)
+	expect(t, logs.FilterMessage("dropped").Len(), 0)
+
+	levels.removeLogger("db")
+	l.Named("db").Debug("dropped")
+	expect(t, logs.Len(), 3)
+}
+
+func TestGraylogLevelCore(t *testing.T) {
+	levels := newLevelRegistry(zapcore.DebugLevel)
+
+	console, consoleLogs := observer.New(zapcore.DebugLevel)
+	graylog, graylogLogs := observer.New(zapcore.DebugLevel)
+	l := zap.New(newLevelFilterCore(zapcore.NewTee(console, newGraylogLevelCore(graylog, levels)), levels))
+
+	// The default level only applies to the console.
+	l.Debug("console only")
+	l.Info("both")
+	expect(t, consoleLogs.Len(), 2)
+	expect(t, graylogLogs.Len(), 1)
+
+	levels.setLogger("db", zapcore.DebugLevel, 0)
+	l.Named("db").Debug("both")
+	l.Named("http").Debug("console only")
+	expect(t, graylogLogs.Len(), 2)
+
+	levels.setGlobal(zapcore.DebugLevel, 0)
+	l.Named("http").Debug("both")
+	expect(t, graylogLogs.Len(), 3)
+
+	levels.reset(zapcore.DebugLevel, true)
+	l.Debug("both")
+	expect(t, graylogLogs.Len(), 4)
+	expect(t, graylogLogs.FilterMessage("console only").Len(), 0)
+}
@@ -0,0 +1,289 @@
+package gzap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+var logfmtPool = buffer.NewPool()
+
+// logfmtEncoder encodes entries as logfmt lines, key=value pairs separated by
+// spaces. Nested objects and namespaces are flattened into dotted keys, and
+// arrays and reflected values are encoded as JSON.
+type logfmtEncoder struct {
+	*zapcore.EncoderConfig
+	buf *buffer.Buffer
+
+	// prefix is the dotted path of the open namespaces and objects.
+	prefix string
+}
+
+func newLogfmtEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return &logfmtEncoder{EncoderConfig: &cfg, buf: logfmtPool.Get()}
+}
+
+func (enc *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := &logfmtEncoder{EncoderConfig: enc.EncoderConfig, buf: logfmtPool.Get(), prefix: enc.prefix}
+	clone.buf.Write(enc.buf.Bytes())
+
+	return clone
+}
+
+func (enc *logfmtEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	line := &logfmtEncoder{EncoderConfig: enc.EncoderConfig, buf: logfmtPool.Get()}
+
+	if enc.TimeKey != "" && enc.EncodeTime != nil {
+		line.addEncoded(enc.TimeKey, func(values *logfmtValues) { enc.EncodeTime(entry.Time, values) })
+	}
+	if enc.LevelKey != "" && enc.EncodeLevel != nil {
+		line.addEncoded(enc.LevelKey, func(values *logfmtValues) { enc.EncodeLevel(entry.Level, values) })
+	}
+	if enc.NameKey != "" && entry.LoggerName != "" {
+		line.AddString(enc.NameKey, entry.LoggerName)
+	}
+	if enc.CallerKey != "" && entry.Caller.Defined && enc.EncodeCaller != nil {
+		line.addEncoded(enc.CallerKey, func(values *logfmtValues) { enc.EncodeCaller(entry.Caller, values) })
+	}
+	if enc.MessageKey != "" {
+		line.AddString(enc.MessageKey, entry.Message)
+	}
+
+	if enc.buf.Len() > 0 {
+		line.separate()
+		line.buf.Write(enc.buf.Bytes())
+	}
+
+	// Fields get their own encoder, so that the namespaces they open don't
+	// leak into the context.
+	fieldsEncoder := &logfmtEncoder{EncoderConfig: enc.EncoderConfig, buf: line.buf, prefix: enc.prefix}
+	for _, field := range fields {
+		field.AddTo(fieldsEncoder)
+	}
+
+	if enc.StacktraceKey != "" && entry.Stack != "" {
+		line.AddString(enc.StacktraceKey, entry.Stack)
+	}
+
+	line.buf.AppendString(enc.LineEnding)
+	if enc.LineEnding == "" {
+		line.buf.AppendString(zapcore.DefaultLineEnding)
+	}
+
+	return line.buf, nil
+}
+
+func (enc *logfmtEncoder) separate() {
+	if enc.buf.Len() > 0 {
+		enc.buf.AppendByte(' ')
+	}
+}
+
+func (enc *logfmtEncoder) addKey(key string) {
+	enc.separate()
+	enc.buf.AppendString(strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			return '_'
+		}
+		return r
+	}, enc.prefix+key))
+	enc.buf.AppendByte('=')
+}
+
+func (enc *logfmtEncoder) addValue(key string, value string) {
+	enc.addKey(key)
+	enc.buf.AppendString(quoteLogfmt(value))
+}
+
+// addEncoded adds the values appended by an encoding function, such as
+// EncodeTime, as a single value.
+func (enc *logfmtEncoder) addEncoded(key string, encode func(*logfmtValues)) {
+	values := &logfmtValues{}
+	encode(values)
+	enc.addValue(key, strings.Join(values.values, ","))
+}
+
+// quoteLogfmt quotes values that are empty, or contain spaces, equal signs,
+// quotes or control characters.
+func quoteLogfmt(value string) string {
+	if value == "" {
+		return `""`
+	}
+
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError {
+			return strconv.Quote(value)
+		}
+	}
+
+	return value
+}
+
+func (enc *logfmtEncoder) addJSON(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	enc.addValue(key, string(data))
+	return nil
+}
+
+func (enc *logfmtEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	m := zapcore.NewMapObjectEncoder()
+	if err := m.AddArray(key, marshaler); err != nil {
+		return err
+	}
+
+	return enc.addJSON(key, m.Fields[key])
+}
+
+func (enc *logfmtEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	nested := &logfmtEncoder{EncoderConfig: enc.EncoderConfig, buf: enc.buf, prefix: enc.prefix + key + "."}
+	return marshaler.MarshalLogObject(nested)
+}
+
+func (enc *logfmtEncoder) AddBinary(key string, value []byte) {
+	enc.addValue(key, base64.StdEncoding.EncodeToString(value))
+}
+
+func (enc *logfmtEncoder) AddByteString(key string, value []byte) {
+	enc.addValue(key, string(value))
+}
+
+func (enc *logfmtEncoder) AddBool(key string, value bool) {
+	enc.addValue(key, strconv.FormatBool(value))
+}
+
+func (enc *logfmtEncoder) AddComplex128(key string, value complex128) {
+	enc.addValue(key, strconv.FormatComplex(value, 'g', -1, 128))
+}
+
+func (enc *logfmtEncoder) AddComplex64(key string, value complex64) {
+	enc.addValue(key, strconv.FormatComplex(complex128(value), 'g', -1, 64))
+}
+
+func (enc *logfmtEncoder) AddDuration(key string, value time.Duration) {
+	if enc.EncodeDuration == nil {
+		enc.addValue(key, value.String())
+		return
+	}
+
+	enc.addEncoded(key, func(values *logfmtValues) { enc.EncodeDuration(value, values) })
+}
+
+func (enc *logfmtEncoder) AddFloat64(key string, value float64) {
+	enc.addValue(key, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (enc *logfmtEncoder) AddFloat32(key string, value float32) {
+	enc.addValue(key, strconv.FormatFloat(float64(value), 'f', -1, 32))
+}
+
+func (enc *logfmtEncoder) AddInt(key string, value int) {
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *logfmtEncoder) AddInt64(key string, value int64) {
+	enc.addValue(key, strconv.FormatInt(value, 10))
+}
+
+func (enc *logfmtEncoder) AddInt32(key string, value int32) {
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *logfmtEncoder) AddInt16(key string, value int16) {
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *logfmtEncoder) AddInt8(key string, value int8) {
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *logfmtEncoder) AddString(key string, value string) {
+	enc.addValue(key, value)
+}
+
+func (enc *logfmtEncoder) AddTime(key string, value time.Time) {
+	if enc.EncodeTime == nil {
+		enc.addValue(key, value.Format(time.RFC3339Nano))
+		return
+	}
+
+	enc.addEncoded(key, func(values *logfmtValues) { enc.EncodeTime(value, values) })
+}
+
+func (enc *logfmtEncoder) AddUint(key string, value uint) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddUint64(key string, value uint64) {
+	enc.addValue(key, strconv.FormatUint(value, 10))
+}
+
+func (enc *logfmtEncoder) AddUint32(key string, value uint32) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddUint16(key string, value uint16) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddUint8(key string, value uint8) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddUintptr(key string, value uintptr) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddReflected(key string, value interface{}) error {
+	return enc.addJSON(key, value)
+}
+
+func (enc *logfmtEncoder) OpenNamespace(key string) {
+	enc.prefix += key + "."
+}
+
+// logfmtValues collects the values appended by the encoding functions of an
+// EncoderConfig.
+type logfmtValues struct {
+	values []string
+}
+
+func (v *logfmtValues) append(value string) {
+	v.values = append(v.values, value)
+}
+
+func (v *logfmtValues) AppendBool(value bool)         { v.append(strconv.FormatBool(value)) }
+func (v *logfmtValues) AppendByteString(value []byte) { v.append(string(value)) }
+func (v *logfmtValues) AppendComplex128(value complex128) {
+	v.append(strconv.FormatComplex(value, 'g', -1, 128))
+}
+func (v *logfmtValues) AppendComplex64(value complex64) {
+	v.append(strconv.FormatComplex(complex128(value), 'g', -1, 64))
+}
+func (v *logfmtValues) AppendFloat64(value float64) {
+	v.append(strconv.FormatFloat(value, 'f', -1, 64))
+}
+func (v *logfmtValues) AppendFloat32(value float32) {
+	v.append(strconv.FormatFloat(float64(value), 'f', -1, 32))
+}
+func (v *logfmtValues) AppendInt(value int)         { v.append(strconv.Itoa(value)) }
+func (v *logfmtValues) AppendInt64(value int64)     { v.append(strconv.FormatInt(value, 10)) }
+func (v *logfmtValues) AppendInt32(value int32)     { v.append(strconv.FormatInt(int64(value), 10)) }
+func (v *logfmtValues) AppendInt16(value int16)     { v.append(strconv.FormatInt(int64(value), 10)) }
+func (v *logfmtValues) AppendInt8(value int8)       { v.append(strconv.FormatInt(int64(value), 10)) }
+func (v *logfmtValues) AppendString(value string)   { v.append(value) }
+func (v *logfmtValues) AppendUint(value uint)       { v.append(strconv.FormatUint(uint64(value), 10)) }
+func (v *logfmtValues) AppendUint64(value uint64)   { v.append(strconv.FormatUint(value, 10)) }
+func (v *logfmtValues) AppendUint32(value uint32)   { v.append(strconv.FormatUint(uint64(value), 10)) }
+func (v *logfmtValues) AppendUint16(value uint16)   { v.append(strconv.FormatUint(uint64(value), 10)) }
+func (v *logfmtValues) AppendUint8(value uint8)     { v.append(strconv.FormatUint(uint64(value), 10)) }
+func (v *logfmtValues) AppendUintptr(value uintptr) { v.append(strconv.FormatUint(uint64(value), 10)) }
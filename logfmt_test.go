@@ -0,0 +1,77 @@
+package gzap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type logfmtUser struct {
+	name string
+	id   int
+}
+
+func (u logfmtUser) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("name", u.name)
+	enc.AddInt("id", u.id)
+	return nil
+}
+
+func TestLogfmtEncoder(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(logger *zap.Logger)
+		want string
+	}{
+		{
+			"logfmtEncoder should quote values when needed",
+			func(logger *zap.Logger) {
+				logger.Info("hello world", String("path", "/jobs"), String("query", `a="b"`), String("empty", ""), Int("status", 200))
+			},
+			`level=info msg="hello world" path=/jobs query="a=\"b\"" empty="" status=200`,
+		},
+		{
+			"logfmtEncoder should flatten objects and namespaces",
+			func(logger *zap.Logger) {
+				logger.With(String("request_id", "abc"), zap.Namespace("db")).Info("query",
+					zap.Object("user", logfmtUser{name: "ada", id: 1}),
+					Duration("took", time.Millisecond*1500),
+				)
+			},
+			`level=info msg=query request_id=abc db.user.name=ada db.user.id=1 db.took=1.5s`,
+		},
+		{
+			"logfmtEncoder should encode arrays and reflected values as JSON",
+			func(logger *zap.Logger) {
+				logger.Named("jobs").Warn("retrying",
+					Strings("queues", []string{"a", "b"}),
+					Any("attempts", map[string]int{"max": 3}),
+					Error(errors.New("timed out")),
+					Float64("ratio", 0.25),
+				)
+			},
+			`level=warn logger=jobs msg=retrying queues="[\"a\",\"b\"]" attempts="{\"max\":3}" error="timed out" ratio=0.25`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := zap.NewDevelopmentEncoderConfig()
+			cfg.TimeKey = ""
+			cfg.CallerKey = ""
+			cfg.LevelKey = "level"
+			cfg.MessageKey = "msg"
+			cfg.NameKey = "logger"
+			cfg.EncodeLevel = zapcore.LowercaseLevelEncoder
+
+			var buf bytes.Buffer
+			logger := zap.New(zapcore.NewCore(newLogfmtEncoder(cfg), zapcore.AddSync(&buf), zapcore.DebugLevel))
+			tt.log(logger)
+
+			expect(t, buf.String(), tt.want+"\n")
+		})
+	}
+}
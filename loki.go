@@ -0,0 +1,425 @@
+package gzap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultLokiPath          = "/loki/api/v1/push"
+	defaultLokiBatch         = 500
+	defaultLokiFlushInterval = time.Second
+	defaultLokiTimeout       = 10 * time.Second
+	defaultLokiRetries       = 5
+)
+
+// Labels a Loki sink may set on its streams. Any other field goes in the
+// line, so that the number of streams stays low.
+const (
+	lokiLabelApp    = "app"
+	lokiLabelEnv    = "env"
+	lokiLabelLevel  = "level"
+	lokiLabelLogger = "logger"
+)
+
+// Encodings of the push requests and of the lines of a Loki sink.
+const (
+	lokiEncodingJSON     = "json"
+	lokiEncodingProtobuf = "protobuf"
+	lokiLineJSON         = "json"
+	lokiLineLogfmt       = "logfmt"
+)
+
+// lokiOptions configures a Loki sink.
+type lokiOptions struct {
+	// endpoint is the URL of the push API, without credentials.
+	endpoint string
+	username string
+	password string
+	tenant   string
+
+	labels   []string
+	encoding string
+	line     string
+
+	batch         int
+	flushInterval time.Duration
+	timeout       time.Duration
+
+	retry httpRetry
+}
+
+// parseLokiSink parses "loki+http://loki:3100" or "loki+https://...", with
+// the label, encoding, line, tenant, batch, flush_interval, timeout and
+// retries options. The path defaults to the push API, and credentials are
+// sent with basic authentication.
+func parseLokiSink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+	if err := checkSinkParams(query, "label", "encoding", "line", "tenant", "batch", "flush_interval", "timeout", "retries"); err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host")
+	}
+
+	endpoint := url.URL{Scheme: strings.TrimPrefix(u.Scheme, "loki+"), Host: u.Host, Path: u.Path}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = defaultLokiPath
+	}
+
+	opts := lokiOptions{
+		endpoint:      endpoint.String(),
+		tenant:        query.Get("tenant"),
+		labels:        []string{lokiLabelApp, lokiLabelEnv, lokiLabelLevel},
+		encoding:      lokiEncodingJSON,
+		line:          lokiLineJSON,
+		batch:         defaultLokiBatch,
+		flushInterval: defaultLokiFlushInterval,
+		timeout:       defaultLokiTimeout,
+		retry:         httpRetry{retries: defaultLokiRetries, minBackoff: 500 * time.Millisecond, maxBackoff: 30 * time.Second},
+	}
+	if u.User != nil {
+		opts.username = u.User.Username()
+		opts.password, _ = u.User.Password()
+	}
+
+	if labels, ok := query["label"]; ok {
+		opts.labels = nil
+		for _, label := range labels {
+			switch label {
+			case lokiLabelApp, lokiLabelEnv, lokiLabelLevel, lokiLabelLogger:
+				opts.labels = append(opts.labels, label)
+			default:
+				return nil, fmt.Errorf("label: unknown label %q, expected %q, %q, %q or %q", label, lokiLabelApp, lokiLabelEnv, lokiLabelLevel, lokiLabelLogger)
+			}
+		}
+	}
+
+	switch encoding := query.Get("encoding"); encoding {
+	case "":
+	case lokiEncodingJSON, lokiEncodingProtobuf:
+		opts.encoding = encoding
+	default:
+		return nil, fmt.Errorf("encoding: unknown encoding %q, expected %q or %q", encoding, lokiEncodingJSON, lokiEncodingProtobuf)
+	}
+
+	switch line := query.Get("line"); line {
+	case "":
+	case lokiLineJSON, lokiLineLogfmt:
+		opts.line = line
+	default:
+		return nil, fmt.Errorf("line: unknown format %q, expected %q or %q", line, lokiLineJSON, lokiLineLogfmt)
+	}
+
+	if text := query.Get("batch"); text != "" {
+		batch, err := strconv.Atoi(text)
+		if err != nil || batch < 1 {
+			return nil, fmt.Errorf("batch: could not parse %q as a number of entries", text)
+		}
+		opts.batch = batch
+	}
+
+	if text := query.Get("retries"); text != "" {
+		retries, err := strconv.Atoi(text)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("retries: could not parse %q as a number of retries", text)
+		}
+		opts.retry.retries = retries
+	}
+
+	if err := parseSinkDuration(query, "flush_interval", &opts.flushInterval); err != nil {
+		return nil, err
+	}
+	if err := parseSinkDuration(query, "timeout", &opts.timeout); err != nil {
+		return nil, err
+	}
+
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		client := newLokiClient(opts)
+		return newLokiCore(opts, cfg.getGraylogAppName(), cfg.getGraylogLogEnvName(), client), client.Close, nil
+	}, nil
+}
+
+// lokiLabel is a label of a Loki stream.
+type lokiLabel struct {
+	name  string
+	value string
+}
+
+// formatLokiLabels formats labels as a Prometheus label set,
+// {app="my-app", level="info"}.
+func formatLokiLabels(labels []lokiLabel) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(label.name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(label.value))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// lokiCore writes entries to the streams of a Loki sink. The labels of the
+// sink select the stream of an entry, and the rest of the entry is encoded
+// in its line.
+type lokiCore struct {
+	labels  map[string]bool
+	static  []lokiLabel
+	encoder zapcore.Encoder
+	client  *lokiClient
+}
+
+func newLokiCore(opts lokiOptions, appName string, env string, client *lokiClient) *lokiCore {
+	c := &lokiCore{labels: map[string]bool{}, client: client}
+	for _, label := range opts.labels {
+		c.labels[label] = true
+	}
+
+	if c.labels[lokiLabelApp] && appName != "" {
+		c.static = append(c.static, lokiLabel{lokiLabelApp, appName})
+	}
+	if c.labels[lokiLabelEnv] && env != "" {
+		c.static = append(c.static, lokiLabel{lokiLabelEnv, env})
+	}
+
+	// The timestamp is sent along with the line, and labels are not repeated
+	// in it.
+	encoderConfig := zapcore.EncoderConfig{
+		LevelKey:       "level",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		MessageKey:     "msg",
+		StacktraceKey:  "stacktrace",
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+	if c.labels[lokiLabelLevel] {
+		encoderConfig.LevelKey = ""
+	}
+	if c.labels[lokiLabelLogger] {
+		encoderConfig.NameKey = ""
+	}
+
+	if opts.line == lokiLineLogfmt {
+		c.encoder = newLogfmtEncoder(encoderConfig)
+	} else {
+		c.encoder = zapcore.NewJSONEncoder(encoderConfig)
+	}
+
+	return c
+}
+
+// Enabled accepts every level: levels are controlled by the logger wide
+// filter, see LevelHandler.
+func (c *lokiCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *lokiCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.encoder = c.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(clone.encoder)
+	}
+
+	return &clone
+}
+
+func (c *lokiCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checked.AddCore(entry, c)
+}
+
+func (c *lokiCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimRight(buf.String(), "\n")
+	buf.Free()
+
+	labels := append([]lokiLabel{}, c.static...)
+	if c.labels[lokiLabelLevel] {
+		labels = append(labels, lokiLabel{lokiLabelLevel, entry.Level.String()})
+	}
+	if c.labels[lokiLabelLogger] && entry.LoggerName != "" {
+		labels = append(labels, lokiLabel{lokiLabelLogger, entry.LoggerName})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+	return c.client.add(lokiEntry{labels: labels, time: entry.Time, line: line}, len(line))
+}
+
+func (c *lokiCore) Sync() error {
+	return c.client.sync()
+}
+
+type lokiEntry struct {
+	labels []lokiLabel
+	time   time.Time
+	line   string
+}
+
+// lokiStream holds the entries of a stream in a push request.
+type lokiStream struct {
+	labels  []lokiLabel
+	entries []lokiEntry
+}
+
+// lokiClient pushes batches of entries to Loki, one batch at a time, so that
+// the entries of a stream are pushed in order even when a push is retried.
+type lokiClient struct {
+	*batcher
+
+	opts lokiOptions
+	http *http.Client
+
+	// last is the time of the last entry pushed to every stream, only used
+	// by the batcher goroutine. Loki rejects entries older than the last one
+	// of their stream, so later entries are pushed at that time at the
+	// earliest.
+	last map[string]time.Time
+}
+
+func newLokiClient(opts lokiOptions) *lokiClient {
+	c := &lokiClient{
+		opts: opts,
+		http: &http.Client{Timeout: opts.timeout},
+		last: map[string]time.Time{},
+	}
+	c.batcher = newBatcher("loki "+opts.endpoint, opts.batch, 0, opts.flushInterval, c.push)
+
+	return c
+}
+
+func (c *lokiClient) push(items []interface{}) error {
+	streams, order := c.streams(items)
+
+	var body []byte
+	contentType := "application/x-protobuf"
+	if c.opts.encoding == lokiEncodingProtobuf {
+		body = snappyEncode(encodeLokiProtobuf(streams, order))
+	} else {
+		var err error
+		if body, err = encodeLokiJSON(streams, order); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	_, err := c.opts.retry.do(c.http, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", c.opts.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "gzap")
+		if c.opts.tenant != "" {
+			req.Header.Set("X-Scope-OrgID", c.opts.tenant)
+		}
+		if c.opts.username != "" {
+			req.SetBasicAuth(c.opts.username, c.opts.password)
+		}
+
+		return req, nil
+	})
+
+	return err
+}
+
+// streams groups entries per stream, in the order of their first entry,
+// sorting the entries of every stream by time.
+func (c *lokiClient) streams(items []interface{}) (map[string]*lokiStream, []string) {
+	streams := map[string]*lokiStream{}
+	var order []string
+	for _, item := range items {
+		entry := item.(lokiEntry)
+		key := formatLokiLabels(entry.labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{labels: entry.labels}
+			streams[key] = stream
+			order = append(order, key)
+		}
+		stream.entries = append(stream.entries, entry)
+	}
+
+	for key, stream := range streams {
+		sort.SliceStable(stream.entries, func(i, j int) bool {
+			return stream.entries[i].time.Before(stream.entries[j].time)
+		})
+		for i := range stream.entries {
+			if last := c.last[key]; stream.entries[i].time.Before(last) {
+				stream.entries[i].time = last
+			}
+		}
+		c.last[key] = stream.entries[len(stream.entries)-1].time
+	}
+
+	return streams, order
+}
+
+// encodeLokiJSON encodes a push request as JSON:
+// {"streams": [{"stream": {"app": "my-app"}, "values": [["<ns>", "<line>"]]}]}.
+func encodeLokiJSON(streams map[string]*lokiStream, order []string) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	request := struct {
+		Streams []stream `json:"streams"`
+	}{}
+	for _, key := range order {
+		s := stream{Stream: map[string]string{}}
+		for _, label := range streams[key].labels {
+			s.Stream[label.name] = label.value
+		}
+		for _, entry := range streams[key].entries {
+			s.Values = append(s.Values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+		}
+		request.Streams = append(request.Streams, s)
+	}
+
+	return json.Marshal(request)
+}
+
+// encodeLokiProtobuf encodes a push request as the PushRequest message of
+// Loki's logproto package:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodeLokiProtobuf(streams map[string]*lokiStream, order []string) []byte {
+	var request, stream, entry, timestamp []byte
+	for _, key := range order {
+		stream = appendProtoString(stream[:0], 1, key)
+		for _, e := range streams[key].entries {
+			timestamp = appendProtoInt(timestamp[:0], 1, e.time.Unix())
+			timestamp = appendProtoInt(timestamp, 2, int64(e.time.Nanosecond()))
+
+			entry = appendProtoMessage(entry[:0], 1, timestamp)
+			entry = appendProtoString(entry, 2, e.line)
+			stream = appendProtoMessage(stream, 2, entry)
+		}
+		request = appendProtoMessage(request, 1, stream)
+	}
+
+	return request
+}
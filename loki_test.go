@@ -0,0 +1,246 @@
+package gzap
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// lokiServer is a stand-in push API, answering with the queued statuses
+// before succeeding.
+type lokiServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newLokiServer(statuses ...int) *lokiServer {
+	s := &lokiServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	return s
+}
+
+func (s *lokiServer) opts() lokiOptions {
+	return lokiOptions{
+		endpoint:      s.URL + defaultLokiPath,
+		labels:        []string{lokiLabelApp, lokiLabelEnv, lokiLabelLevel},
+		encoding:      lokiEncodingJSON,
+		line:          lokiLineJSON,
+		batch:         100,
+		flushInterval: time.Hour,
+		timeout:       time.Second,
+		retry:         httpRetry{retries: 2, minBackoff: time.Millisecond, maxBackoff: 10 * time.Millisecond},
+	}
+}
+
+type lokiPushRequest struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	} `json:"streams"`
+}
+
+func TestLokiCore_JSON(t *testing.T) {
+	server := newLokiServer()
+	defer server.Close()
+
+	opts := server.opts()
+	opts.tenant = "team-a"
+	opts.username, opts.password = "user", "secret"
+	client := newLokiClient(opts)
+	defer client.Close()
+
+	core := newLokiCore(opts, "my-app", "3", client).With([]zapcore.Field{zap.String("tenant", "acme")})
+	logger := zap.New(core).Named("db")
+	logger.Info("slow query", zap.Int("rows", 3))
+	logger.Error("failed query")
+
+	// Entries of a push are sorted by time.
+	early := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now().Add(-time.Minute), Message: "early"}
+	if err := core.Write(early, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.requests), 1)
+	r := server.requests[0]
+	expect(t, r.URL.Path, defaultLokiPath)
+	expect(t, r.Header.Get("Content-Type"), "application/json")
+	expect(t, r.Header.Get("X-Scope-OrgID"), "team-a")
+	username, password, _ := r.BasicAuth()
+	expect(t, username+":"+password, "user:secret")
+
+	var request lokiPushRequest
+	if err := json.Unmarshal(server.bodies[0], &request); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, len(request.Streams), 2)
+
+	info := request.Streams[0]
+	if want := map[string]string{"app": "my-app", "env": "3", "level": "info"}; !reflect.DeepEqual(info.Stream, want) {
+		t.Errorf("stream labels = %v, want %v", info.Stream, want)
+	}
+	expect(t, len(info.Values), 2)
+	expect(t, info.Values[0][1], `{"msg":"early","tenant":"acme"}`)
+	expect(t, info.Values[1][1], `{"logger":"db","msg":"slow query","tenant":"acme","rows":3}`)
+
+	expect(t, request.Streams[1].Stream["level"], "error")
+
+	// An entry older than the last one pushed to its stream is pushed at the
+	// time of the latter.
+	late := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now().Add(-time.Hour), Message: "late"}
+	if err := core.Write(late, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.bodies), 2)
+	request = lokiPushRequest{}
+	if err := json.Unmarshal(server.bodies[1], &request); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, request.Streams[0].Values[0][1], `{"msg":"late","tenant":"acme"}`)
+	expect(t, request.Streams[0].Values[0][0], info.Values[1][0])
+}
+
+func TestLokiCore_Protobuf(t *testing.T) {
+	server := newLokiServer()
+	defer server.Close()
+
+	opts := server.opts()
+	opts.encoding = lokiEncodingProtobuf
+	opts.labels = []string{lokiLabelLevel, lokiLabelLogger}
+	opts.line = lokiLineLogfmt
+	client := newLokiClient(opts)
+	defer client.Close()
+
+	core := newLokiCore(opts, "my-app", "3", client)
+	entry := zapcore.Entry{Level: zapcore.WarnLevel, Time: time.Unix(1514905445, 123456789), LoggerName: "http", Message: "slow"}
+	if err := core.Write(entry, []zapcore.Field{zap.Int("status", 200)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.requests), 1)
+	expect(t, server.requests[0].Header.Get("Content-Type"), "application/x-protobuf")
+
+	body, err := snappyDecode(server.bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, _ := decodeProto(body)
+	expect(t, len(request), 1)
+
+	stream, _ := decodeProto(request[0].value.([]byte))
+	expect(t, len(stream), 2)
+	expect(t, string(stream[0].value.([]byte)), `{level="warn", logger="http"}`)
+
+	e, _ := decodeProto(stream[1].value.([]byte))
+	timestamp, _ := decodeProto(e[0].value.([]byte))
+	if want := []protoField{{1, uint64(1514905445)}, {2, uint64(123456789)}}; !reflect.DeepEqual(timestamp, want) {
+		t.Errorf("timestamp = %v, want %v", timestamp, want)
+	}
+	expect(t, string(e[1].value.([]byte)), "msg=slow status=200")
+}
+
+func TestLokiClient_Retry(t *testing.T) {
+	server := newLokiServer(http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	opts := server.opts()
+	opts.batch = 1
+	client := newLokiClient(opts)
+	defer client.Close()
+
+	core := newLokiCore(opts, "my-app", "3", client)
+	if err := core.Write(zapcore.Entry{Time: time.Now(), Message: "retried"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.bodies), 3)
+	expect(t, string(server.bodies[2]), string(server.bodies[0]))
+}
+
+func TestLokiClient_Errors(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+	}{
+		{"a rejected push", []int{http.StatusBadRequest}, 1},
+		{"too many retries", []int{500, 502, 504}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newLokiServer(tt.statuses...)
+			defer server.Close()
+
+			opts := server.opts()
+			client := newLokiClient(opts)
+			defer client.Close()
+
+			core := newLokiCore(opts, "my-app", "3", client)
+			if err := core.Write(zapcore.Entry{Time: time.Now(), Message: "lost"}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			err := core.Sync()
+			if err == nil || !strings.Contains(err.Error(), server.URL) {
+				t.Errorf("expected an error naming the endpoint, got %v", err)
+			}
+			expect(t, len(server.requests), tt.wantRequests)
+
+			// The error is only reported once.
+			expect(t, core.Sync(), nil)
+		})
+	}
+}
+
+func TestLokiClient_Closed(t *testing.T) {
+	client := newLokiClient(lokiOptions{endpoint: "http://127.0.0.1:0", batch: 1, flushInterval: time.Hour})
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.add(lokiEntry{time: time.Now(), line: "lost"}, 4); err == nil {
+		t.Error("expected an error posting to a closed client")
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	graylog "github.com/Devatoria/go-graylog"
 	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zapcore"
 )
 
 // MockEnvConfig represents all the logger configurations available
@@ -67,3 +68,48 @@ func (m *MockEnvConfig) useColoredConsolelogs() bool {
 	args := m.Called()
 	return args.Bool(0)
 }
+
+func (m *MockEnvConfig) getLevel() zapcore.Level {
+	args := m.Called()
+	return args.Get(0).(zapcore.Level)
+}
+
+func (m *MockEnvConfig) getLoggerLevels() map[string]zapcore.Level {
+	args := m.Called()
+	return args.Get(0).(map[string]zapcore.Level)
+}
+
+func (m *MockEnvConfig) getStaticFields() map[string]string {
+	args := m.Called()
+	return args.Get(0).(map[string]string)
+}
+
+func (m *MockEnvConfig) getRedactRules() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *MockEnvConfig) getConsoleOptions() consoleOptions {
+	args := m.Called()
+	return args.Get(0).(consoleOptions)
+}
+
+func (m *MockEnvConfig) getFileOptions() fileOptions {
+	args := m.Called()
+	return args.Get(0).(fileOptions)
+}
+
+func (m *MockEnvConfig) getSyslogOptions() syslogOptions {
+	args := m.Called()
+	return args.Get(0).(syslogOptions)
+}
+
+func (m *MockEnvConfig) getHTTPBodyOptions() httpBodyOptions {
+	args := m.Called()
+	return args.Get(0).(httpBodyOptions)
+}
+
+func (m *MockEnvConfig) getSinks() []sinkConfig {
+	args := m.Called()
+	return args.Get(0).([]sinkConfig)
+}
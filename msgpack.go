@@ -0,0 +1,372 @@
+package gzap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// MessagePack encoding, see https://github.com/msgpack/msgpack/blob/master/spec.md.
+// Only the subset needed to write log records, and to read them back, is
+// implemented.
+
+// msgpackEventTimeType is the extension type of the EventTime of the Fluentd
+// Forward protocol: seconds and nanoseconds as two big-endian uint32.
+const msgpackEventTimeType = 0
+
+func appendMsgpackNil(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+func appendMsgpackBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+
+	return append(b, 0xc2)
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return appendMsgpackUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return appendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return appendUint32(append(b, 0xd2), uint32(v))
+	}
+
+	return appendUint64(append(b, 0xd3), uint64(v))
+}
+
+func appendMsgpackUint(b []byte, v uint64) []byte {
+	switch {
+	case v <= math.MaxInt8:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return appendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return appendUint32(append(b, 0xce), uint32(v))
+	}
+
+	return appendUint64(append(b, 0xcf), v)
+}
+
+func appendMsgpackFloat(b []byte, v float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = appendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+
+	return append(b, s...)
+}
+
+func appendMsgpackBinary(b []byte, v []byte) []byte {
+	switch n := len(v); {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = appendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = appendUint32(append(b, 0xc6), uint32(n))
+	}
+
+	return append(b, v...)
+}
+
+func appendMsgpackArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xdc), uint16(n))
+	}
+
+	return appendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xde), uint16(n))
+	}
+
+	return appendUint32(append(b, 0xdf), uint32(n))
+}
+
+// appendMsgpackEventTime appends t as a fixext 8 EventTime, which keeps the
+// nanoseconds that a plain integer timestamp would drop.
+func appendMsgpackEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, msgpackEventTimeType)
+	b = appendUint32(b, uint32(t.Unix()))
+
+	return appendUint32(b, uint32(t.Nanosecond()))
+}
+
+// appendMsgpackValue appends a value of a zapcore.MapObjectEncoder. Maps are
+// written with sorted keys, and values of other types are written as their
+// JSON representation, as a JSON encoder would.
+func appendMsgpackValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return appendMsgpackNil(b)
+	case bool:
+		return appendMsgpackBool(b, v)
+	case string:
+		return appendMsgpackString(b, v)
+	case []byte:
+		return appendMsgpackBinary(b, v)
+	case int:
+		return appendMsgpackInt(b, int64(v))
+	case int8:
+		return appendMsgpackInt(b, int64(v))
+	case int16:
+		return appendMsgpackInt(b, int64(v))
+	case int32:
+		return appendMsgpackInt(b, int64(v))
+	case int64:
+		return appendMsgpackInt(b, v)
+	case uint:
+		return appendMsgpackUint(b, uint64(v))
+	case uint8:
+		return appendMsgpackUint(b, uint64(v))
+	case uint16:
+		return appendMsgpackUint(b, uint64(v))
+	case uint32:
+		return appendMsgpackUint(b, uint64(v))
+	case uint64:
+		return appendMsgpackUint(b, v)
+	case uintptr:
+		return appendMsgpackUint(b, uint64(v))
+	case float32:
+		return appendMsgpackFloat(b, float64(v))
+	case float64:
+		return appendMsgpackFloat(b, v)
+	case complex64, complex128:
+		return appendMsgpackString(b, fmt.Sprint(v))
+	case time.Duration:
+		return appendMsgpackString(b, v.String())
+	case time.Time:
+		return appendMsgpackString(b, v.Format(time.RFC3339Nano))
+	case []interface{}:
+		b = appendMsgpackArrayHeader(b, len(v))
+		for _, item := range v {
+			b = appendMsgpackValue(b, item)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b = appendMsgpackMapHeader(b, len(v))
+		for _, key := range keys {
+			b = appendMsgpackString(b, key)
+			b = appendMsgpackValue(b, v[key])
+		}
+		return b
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return appendMsgpackString(b, fmt.Sprintf("%+v", v))
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return appendMsgpackString(b, string(data))
+	}
+
+	return appendMsgpackValue(b, decoded)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// errMsgpackFormat is returned when reading an unsupported or invalid format.
+var errMsgpackFormat = errors.New("invalid MessagePack format")
+
+// readMsgpack reads a single value: nil, bool, int64 (or uint64 beyond its
+// range), float64, string, []byte, []interface{}, map[string]interface{}, or
+// time.Time for an EventTime. Maps with keys other than strings are rejected.
+func readMsgpack(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return readMsgpackString(r, int(c&0x1f))
+	case c&0xf0 == 0x90:
+		return readMsgpackArray(r, int(c&0x0f))
+	case c&0xf0 == 0x80:
+		return readMsgpackMap(r, int(c&0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readMsgpackLength(r, c-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(r, n)
+	case 0xca:
+		bits, err := readMsgpackUint(r, 4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := readMsgpackUint(r, 8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := readMsgpackUint(r, 1<<(c-0xcc))
+		if v > math.MaxInt64 {
+			return v, err
+		}
+		return int64(v), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		v, err := readMsgpackUint(r, size)
+		shift := uint(64 - 8*size)
+		return int64(v<<shift) >> shift, err
+	case 0xd7:
+		data, err := readMsgpackBytes(r, 9)
+		if err != nil {
+			return nil, err
+		}
+		if data[0] != msgpackEventTimeType {
+			return nil, errMsgpackFormat
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(data[1:5])), int64(binary.BigEndian.Uint32(data[5:9]))), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackLength(r, c-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, n)
+	case 0xdc, 0xdd:
+		n, err := readMsgpackLength(r, c-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, n)
+	case 0xde, 0xdf:
+		n, err := readMsgpackLength(r, c-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, n)
+	}
+
+	return nil, errMsgpackFormat
+}
+
+// readMsgpackLength reads a length of 1, 2 or 4 bytes, for a class of 0, 1
+// or 2.
+func readMsgpackLength(r *bufio.Reader, class byte) (int, error) {
+	n, err := readMsgpackUint(r, 1<<class)
+	return int(n), err
+}
+
+func readMsgpackUint(r *bufio.Reader, size int) (uint64, error) {
+	data, err := readMsgpackBytes(r, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	for _, c := range data {
+		v = v<<8 | uint64(c)
+	}
+
+	return v, nil
+}
+
+func readMsgpackBytes(r *bufio.Reader, n int) ([]byte, error) {
+	data := make([]byte, n)
+	_, err := io.ReadFull(r, data)
+
+	return data, err
+}
+
+func readMsgpackString(r *bufio.Reader, n int) (interface{}, error) {
+	data, err := readMsgpackBytes(r, n)
+	return string(data), err
+}
+
+func readMsgpackArray(r *bufio.Reader, n int) (interface{}, error) {
+	array := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, item)
+	}
+
+	return array, nil
+}
+
+func readMsgpackMap(r *bufio.Reader, n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := readMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, errMsgpackFormat
+		}
+
+		if m[name], err = readMsgpack(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,114 @@
+package gzap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendMsgpackValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"nil", nil, "c0"},
+		{"true", true, "c3"},
+		{"a positive fixint", 7, "07"},
+		{"a negative fixint", -3, "fd"},
+		{"an int8", int8(-100), "d09c"},
+		{"an int16", int64(-1000), "d1fc18"},
+		{"an int64", int64(math.MinInt64), "d38000000000000000"},
+		{"a uint8", uint8(200), "ccc8"},
+		{"a uint16", uint16(1000), "cd03e8"},
+		{"a uint32", uint32(70000), "ce00011170"},
+		{"a uint64", uint64(math.MaxUint64), "cfffffffffffffffff"},
+		{"a float", 1.5, "cb3ff8000000000000"},
+		{"a fixstr", "abc", "a3616263"},
+		{"a str8", strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{"a binary", []byte{1, 2}, "c4020102"},
+		{"a duration", time.Second, "a23173"},
+		{"an array", []interface{}{1, "a"}, "9201a161"},
+		{"a map with sorted keys", map[string]interface{}{"b": 2, "a": 1}, "82a16101a16202"},
+		{"a reflected struct", struct{ ID int }{42}, "81a24944cb4045000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, hex.EncodeToString(appendMsgpackValue(nil, tt.value)), tt.want)
+		})
+	}
+}
+
+func TestAppendMsgpackEventTime(t *testing.T) {
+	got := appendMsgpackEventTime(nil, time.Unix(1514905445, 123456789))
+	expect(t, hex.EncodeToString(got), "d7005a4b9f65075bcd15")
+}
+
+func TestReadMsgpack(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"nil", nil, nil},
+		{"false", false, false},
+		{"a positive int", 300, int64(300)},
+		{"a negative int", -70000, int64(-70000)},
+		{"a uint64 beyond int64", uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{"a float", 2.25, 2.25},
+		{"a long string", strings.Repeat("x", 300), strings.Repeat("x", 300)},
+		{"a binary", []byte("raw"), []byte("raw")},
+		{"a long array", make([]interface{}, 20), make([]interface{}, 20)},
+		{
+			"nested maps",
+			map[string]interface{}{"user": map[string]interface{}{"id": 1, "tags": []interface{}{"a"}}},
+			map[string]interface{}{"user": map[string]interface{}{"id": int64(1), "tags": []interface{}{"a"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readMsgpack(bufio.NewReader(bytes.NewReader(appendMsgpackValue(nil, tt.value))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readMsgpack() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMsgpack_EventTime(t *testing.T) {
+	want := time.Unix(1514905445, 123456789)
+
+	got, err := readMsgpack(bufio.NewReader(bytes.NewReader(appendMsgpackEventTime(nil, want))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, got.(time.Time).Equal(want), true)
+}
+
+func TestReadMsgpack_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"a truncated string", "a361"},
+		{"an unsupported format", "c1"},
+		{"a map with an integer key", "810101"},
+		{"an unknown extension", "d7010000000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			if _, err := readMsgpack(bufio.NewReader(bytes.NewReader(data))); err == nil {
+				t.Errorf("readMsgpack(%s) expected an error", tt.data)
+			}
+		})
+	}
+}
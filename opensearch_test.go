@@ -0,0 +1,274 @@
+package gzap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// openSearchServer is a stand-in _bulk API, rejecting the documents which
+// message is a key of reject with its statuses, one per request.
+type openSearchServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	reject   map[string][]int
+	requests int
+	indices  []string
+	docs     []map[string]interface{}
+}
+
+func newOpenSearchServer(reject map[string][]int) *openSearchServer {
+	s := &openSearchServer{reject: reject}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.requests++
+		var items []string
+		errors := false
+
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action struct {
+				Create struct {
+					Index string `json:"_index"`
+				} `json:"create"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+			var doc map[string]interface{}
+			json.Unmarshal(scanner.Bytes(), &doc)
+
+			message, _ := doc["message"].(string)
+			if statuses := s.reject[message]; len(statuses) > 0 {
+				s.reject[message] = statuses[1:]
+				errors = true
+				items = append(items, fmt.Sprintf(`{"create":{"status":%d,"error":{"type":"rejected","reason":"%s"}}}`, statuses[0], message))
+				continue
+			}
+
+			s.indices = append(s.indices, action.Create.Index)
+			s.docs = append(s.docs, doc)
+			items = append(items, `{"create":{"status":201}}`)
+		}
+
+		fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[%s]}`, errors, strings.Join(items, ","))
+	}))
+
+	return s
+}
+
+func (s *openSearchServer) opts() openSearchOptions {
+	return openSearchOptions{
+		endpoint:      s.URL + "/_bulk",
+		index:         defaultOpenSearchIndex,
+		dateFormat:    defaultOpenSearchDateFormat,
+		batch:         100,
+		flushInterval: time.Hour,
+		timeout:       time.Second,
+		retry:         httpRetry{retries: 2, minBackoff: time.Millisecond, maxBackoff: time.Millisecond},
+	}
+}
+
+func TestOpenSearchCore_Documents(t *testing.T) {
+	server := newOpenSearchServer(nil)
+	defer server.Close()
+
+	client := newOpenSearchClient(server.opts())
+	defer client.Close()
+
+	core := newOpenSearchCore(server.opts(), "My-App", "3", client)
+	ctx := WithTraceContext(context.Background(), TraceContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}})
+	logger := zap.New(core, zap.AddCaller()).Named("db").With(traceContextFields(ctx)...)
+	logger.Info("slow query", zap.Duration("elapsed", time.Millisecond))
+
+	entry := zapcore.Entry{Level: zapcore.ErrorLevel, Time: time.Date(2026, 10, 18, 23, 0, 0, 0, time.FixedZone("", -2*3600)), Message: "failed", Stack: "main.main()"}
+	if err := core.Write(entry, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.docs), 2)
+
+	doc := server.docs[0]
+	expect(t, doc["message"], "slow query")
+	expect(t, doc["log.level"], "info")
+	expect(t, doc["log.logger"], "db")
+	expect(t, doc["ecs.version"], ecsVersion)
+	expect(t, doc["service.name"], "My-App")
+	expect(t, doc["service.environment"], "3")
+	expect(t, doc["trace.id"], "01000000000000000000000000000000")
+	expect(t, doc["span.id"], "0200000000000000")
+	expect(t, doc["elapsed"], 1e6)
+	expect(t, doc["log.origin.file.name"] != nil, true)
+	expect(t, doc["log.origin.file.line"] != nil, true)
+
+	// Indices are named after the UTC date of the entry, in lower case.
+	expect(t, server.indices[1], "logs-my-app-2026.10.19")
+	expect(t, server.docs[1]["@timestamp"], "2026-10-19T01:00:00Z")
+	expect(t, server.docs[1]["error.stack_trace"], "main.main()")
+}
+
+func TestOpenSearchCore_ECSFields(t *testing.T) {
+	ctx := WithTrace(WithTraceContext(context.Background(), TraceContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}}), 3, 4)
+
+	tests := []struct {
+		name    string
+		context []zapcore.Field
+		fields  []zapcore.Field
+		want    map[string]interface{}
+		missing []string
+	}{
+		{
+			name:    "an error",
+			fields:  []zapcore.Field{Error(errors.New("connection refused"))},
+			want:    map[string]interface{}{"error.message": "connection refused", "error.type": "*errors.errorString"},
+			missing: []string{"error"},
+		},
+		{
+			name:    "an error of the context",
+			context: []zapcore.Field{Error(errors.New("connection refused"))},
+			want:    map[string]interface{}{"error.message": "connection refused", "error.type": "*errors.errorString"},
+			missing: []string{"error"},
+		},
+		{
+			name:    "an error as a string",
+			fields:  []zapcore.Field{String("error", "timeout")},
+			want:    map[string]interface{}{"error.message": "timeout"},
+			missing: []string{"error", "error.type"},
+		},
+		{
+			name:    "two errors",
+			context: []zapcore.Field{Error(errors.New("a"))},
+			fields:  []zapcore.Field{Error(errors.New("b"))},
+			want:    map[string]interface{}{"error.message": "a", "labels.error": "b"},
+			missing: []string{"error"},
+		},
+		{
+			name:    "an error within a namespace",
+			fields:  []zapcore.Field{Namespace("retry"), String("error", "timeout")},
+			want:    map[string]interface{}{"retry": map[string]interface{}{"error": "timeout"}},
+			missing: []string{"error.message"},
+		},
+		{
+			name:    "Datadog and W3C trace fields",
+			context: TraceFields(ctx),
+			want:    map[string]interface{}{"trace.id": "3", "span.id": "4", "trace_id": "01000000000000000000000000000000", "span_id": "0200000000000000"},
+			missing: []string{"dd.trace_id", "dd.span_id"},
+		},
+		{
+			name:   "a logger field",
+			fields: []zapcore.Field{String("logger", "http")},
+			want:   map[string]interface{}{"log.logger": "db", "logger": "http"},
+		},
+		{
+			name:    "a logger field of the context",
+			context: []zapcore.Field{String("logger", "http")},
+			want:    map[string]interface{}{"log.logger": "http", "logger": "db"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newOpenSearchServer(nil)
+			defer server.Close()
+
+			client := newOpenSearchClient(server.opts())
+			defer client.Close()
+
+			logger := zap.New(newOpenSearchCore(server.opts(), "my-app", "", client)).Named("db").With(tt.context...)
+			logger.Error("failed", tt.fields...)
+			if err := logger.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			expect(t, len(server.docs), 1)
+			for key, want := range tt.want {
+				if got := server.docs[0][key]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+			for _, key := range tt.missing {
+				if got, ok := server.docs[0][key]; ok {
+					t.Errorf("expected no %s, got %v", key, got)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenSearchClient_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		reject       map[string][]int
+		wantErr      bool
+		wantIndexed  int
+		wantRequests int
+	}{
+		{"no failures", nil, false, 3, 1},
+		{"retried documents", map[string][]int{"b": {429, 503}}, false, 3, 3},
+		{"too many retries", map[string][]int{"b": {429, 429, 429}}, true, 2, 3},
+		{"a rejected document", map[string][]int{"c": {400}}, true, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newOpenSearchServer(tt.reject)
+			defer server.Close()
+
+			client := newOpenSearchClient(server.opts())
+			defer client.Close()
+
+			core := newOpenSearchCore(server.opts(), "my-app", "", client)
+			for _, message := range []string{"a", "b", "c"} {
+				if err := core.Write(zapcore.Entry{Time: time.Now(), Message: message}, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := core.Sync()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			expect(t, len(server.docs), tt.wantIndexed)
+			expect(t, server.requests, tt.wantRequests)
+		})
+	}
+}
+
+func TestOpenSearchClient_Flush(t *testing.T) {
+	server := newOpenSearchServer(nil)
+	defer server.Close()
+
+	// Documents are sent once the batch reaches its size in bytes.
+	opts := server.opts()
+	opts.bytes = 700
+	client := newOpenSearchClient(opts)
+	defer client.Close()
+
+	core := newOpenSearchCore(opts, "my-app", "", client)
+	for i := 0; i < 4; i++ {
+		if err := core.Write(zapcore.Entry{Time: time.Now(), Message: strings.Repeat("x", 300)}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.docs), 4)
+	expect(t, server.requests, 2)
+}
@@ -0,0 +1,603 @@
+package gzap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultOTLPPath          = "/v1/logs"
+	defaultOTLPBatch         = 512
+	defaultOTLPFlushInterval = time.Second
+	defaultOTLPTimeout       = 10 * time.Second
+	defaultOTLPRetries       = 5
+
+	// otlpScope is the instrumentation scope of the entries of the root
+	// logger, the ones of named loggers being their name.
+	otlpScope = "github.com/dailymuse/gzap"
+)
+
+// Encodings of the export requests of an OTLP sink.
+const (
+	otlpEncodingProtobuf = "protobuf"
+	otlpEncodingJSON     = "json"
+)
+
+// otlpSeverities maps levels to OpenTelemetry severity numbers, see
+// https://opentelemetry.io/docs/specs/otel/logs/data-model/#field-severitynumber.
+var otlpSeverities = map[zapcore.Level]int64{
+	zapcore.DebugLevel:  5,  // DEBUG
+	zapcore.InfoLevel:   9,  // INFO
+	zapcore.WarnLevel:   13, // WARN
+	zapcore.ErrorLevel:  17, // ERROR
+	zapcore.DPanicLevel: 18, // ERROR2
+	zapcore.PanicLevel:  19, // ERROR3
+	zapcore.FatalLevel:  21, // FATAL
+}
+
+// otlpOptions configures an OTLP/HTTP logs exporter.
+type otlpOptions struct {
+	// endpoint is the URL of the logs endpoint.
+	endpoint string
+	encoding string
+	gzip     bool
+
+	batch         int
+	flushInterval time.Duration
+
+	timeout    time.Duration
+	skipVerify bool
+	retry      httpRetry
+}
+
+// parseOTLPSink parses "otlp+http://localhost:4318" or "otlp+https://...",
+// with the encoding, gzip, batch, flush_interval, timeout, retries and
+// skip_verify options. The path defaults to the logs endpoint.
+func parseOTLPSink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+	allowed := []string{"encoding", "gzip", "batch", "flush_interval", "timeout", "retries"}
+	if u.Scheme == "otlp+https" {
+		allowed = append(allowed, "skip_verify")
+	}
+	if err := checkSinkParams(query, allowed...); err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host")
+	}
+
+	endpoint := url.URL{Scheme: strings.TrimPrefix(u.Scheme, "otlp+"), Host: u.Host, Path: u.Path}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = defaultOTLPPath
+	}
+
+	opts := otlpOptions{
+		endpoint:      endpoint.String(),
+		encoding:      otlpEncodingProtobuf,
+		batch:         defaultOTLPBatch,
+		flushInterval: defaultOTLPFlushInterval,
+		timeout:       defaultOTLPTimeout,
+		retry:         httpRetry{retries: defaultOTLPRetries, minBackoff: 500 * time.Millisecond, maxBackoff: 30 * time.Second},
+	}
+
+	switch encoding := query.Get("encoding"); encoding {
+	case "":
+	case otlpEncodingProtobuf, otlpEncodingJSON:
+		opts.encoding = encoding
+	default:
+		return nil, fmt.Errorf("encoding: unknown encoding %q, expected %q or %q", encoding, otlpEncodingProtobuf, otlpEncodingJSON)
+	}
+
+	if text := query.Get("batch"); text != "" {
+		batch, err := strconv.Atoi(text)
+		if err != nil || batch < 1 {
+			return nil, fmt.Errorf("batch: could not parse %q as a number of entries", text)
+		}
+		opts.batch = batch
+	}
+
+	if text := query.Get("retries"); text != "" {
+		retries, err := strconv.Atoi(text)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("retries: could not parse %q as a number of retries", text)
+		}
+		opts.retry.retries = retries
+	}
+
+	for name, value := range map[string]*bool{"gzip": &opts.gzip, "skip_verify": &opts.skipVerify} {
+		if err := parseSinkBool(query, name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, value := range map[string]*time.Duration{"flush_interval": &opts.flushInterval, "timeout": &opts.timeout} {
+		if err := parseSinkDuration(query, name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		client := newOTLPClient(opts, cfg.getGraylogAppName(), cfg.getGraylogLogEnvName())
+		return newOTLPCore(client), client.Close, nil
+	}, nil
+}
+
+// otlpKeyValue is an attribute, which value is nil, a string, a bool, an
+// int64, a float64, a []byte, a []interface{} of such values, or a
+// []otlpKeyValue.
+type otlpKeyValue struct {
+	key   string
+	value interface{}
+}
+
+// otlpRecord is a LogRecord, see
+// https://opentelemetry.io/docs/specs/otel/logs/data-model/.
+type otlpRecord struct {
+	scope      string
+	time       time.Time
+	observed   time.Time
+	severity   int64
+	level      string
+	body       string
+	attributes []otlpKeyValue
+	traceID    []byte
+	spanID     []byte
+	flags      uint32
+}
+
+// otlpCore converts entries to LogRecords: the message is the body, the
+// caller, stack trace and fields are attributes, and the logger name is the
+// instrumentation scope. The trace_id, span_id and trace_flags fields of
+// context loggers set the trace context of the record instead.
+type otlpCore struct {
+	context []zapcore.Field
+	client  *otlpClient
+}
+
+func newOTLPCore(client *otlpClient) *otlpCore {
+	return &otlpCore{client: client}
+}
+
+// Enabled accepts every level: levels are controlled by the logger wide
+// filter, see LevelHandler.
+func (c *otlpCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *otlpCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.context = append(c.context[:len(c.context):len(c.context)], fields...)
+
+	return &clone
+}
+
+func (c *otlpCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checked.AddCore(entry, c)
+}
+
+func (c *otlpCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	record := otlpRecord{
+		scope:    entry.LoggerName,
+		time:     entry.Time,
+		observed: time.Now(),
+		severity: otlpSeverities[entry.Level],
+		level:    entry.Level.String(),
+		body:     entry.Message,
+	}
+	if record.scope == "" {
+		record.scope = otlpScope
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	if entry.Caller.Defined {
+		enc.AddString("code.filepath", entry.Caller.File)
+		enc.AddInt("code.lineno", entry.Caller.Line)
+	}
+	if entry.Stack != "" {
+		enc.AddString("exception.stacktrace", entry.Stack)
+	}
+
+	for _, field := range append(c.context[:len(c.context):len(c.context)], fields...) {
+		if !record.setTraceContext(field) {
+			field.AddTo(enc)
+		}
+	}
+	record.attributes = otlpAttributes(enc.Fields)
+
+	return c.client.add(record, 0)
+}
+
+func (c *otlpCore) Sync() error {
+	return c.client.sync()
+}
+
+// setTraceContext sets the trace context of the record from the fields of
+// context loggers, and reports whether field was one of them.
+func (r *otlpRecord) setTraceContext(field zapcore.Field) bool {
+	if field.Type != zapcore.StringType {
+		return false
+	}
+
+	switch field.Key {
+	case w3cTraceIDKey:
+		if id, err := hex.DecodeString(field.String); err == nil && len(id) == 16 {
+			r.traceID = id
+			return true
+		}
+	case w3cSpanIDKey:
+		if id, err := hex.DecodeString(field.String); err == nil && len(id) == 8 {
+			r.spanID = id
+			return true
+		}
+	case w3cTraceFlagsKey:
+		if flags, err := strconv.ParseUint(field.String, 16, 8); err == nil {
+			r.flags = uint32(flags)
+			return true
+		}
+	}
+
+	return false
+}
+
+// otlpAttributes converts the fields of a MapObjectEncoder to attributes,
+// sorted by key.
+func otlpAttributes(fields map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]otlpKeyValue, len(keys))
+	for i, key := range keys {
+		attributes[i] = otlpKeyValue{key, otlpValue(fields[key])}
+	}
+
+	return attributes
+}
+
+// otlpValue converts a value of a MapObjectEncoder to the value of an
+// attribute.
+func otlpValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, bool, int64, float64, []byte:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case uint:
+		return otlpUint(uint64(v))
+	case uint64:
+		return otlpUint(v)
+	case uint32:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uintptr:
+		return otlpUint(uint64(v))
+	case float32:
+		return float64(v)
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = otlpValue(value)
+		}
+		return values
+	case map[string]interface{}:
+		return otlpAttributes(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	// Reflected values go through their JSON representation.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return string(b)
+	}
+
+	return otlpValue(decoded)
+}
+
+// otlpUint converts a uint64 to an int64 attribute, or to a string beyond
+// the range of int64.
+func otlpUint(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return strconv.FormatUint(v, 10)
+	}
+
+	return int64(v)
+}
+
+// otlpClient exports batches of records with OTLP/HTTP.
+type otlpClient struct {
+	*batcher
+
+	opts     otlpOptions
+	http     *http.Client
+	resource []otlpKeyValue
+}
+
+func newOTLPClient(opts otlpOptions, appName string, env string) *otlpClient {
+	c := &otlpClient{
+		opts: opts,
+		http: &http.Client{
+			Timeout: opts.timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.skipVerify},
+			},
+		},
+	}
+	if appName != "" {
+		c.resource = append(c.resource, otlpKeyValue{"service.name", appName})
+	}
+	if env != "" {
+		c.resource = append(c.resource, otlpKeyValue{"deployment.environment", env})
+	}
+	c.batcher = newBatcher("otlp "+opts.endpoint, opts.batch, 0, opts.flushInterval, c.export)
+
+	return c
+}
+
+func (c *otlpClient) export(items []interface{}) error {
+	records := make([]otlpRecord, len(items))
+	for i, item := range items {
+		records[i] = item.(otlpRecord)
+	}
+
+	var body []byte
+	contentType := "application/x-protobuf"
+	if c.opts.encoding == otlpEncodingJSON {
+		var err error
+		if body, err = encodeOTLPJSON(c.resource, records); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	} else {
+		body = encodeOTLPProtobuf(c.resource, records)
+	}
+
+	if c.opts.gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(body)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	_, err := c.opts.retry.do(c.http, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", c.opts.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "gzap")
+		if c.opts.gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+
+		return req, nil
+	})
+
+	return err
+}
+
+// otlpScopes groups records per scope, in the order of their first record.
+func otlpScopes(records []otlpRecord) ([]string, map[string][]otlpRecord) {
+	var scopes []string
+	grouped := map[string][]otlpRecord{}
+	for _, record := range records {
+		if _, ok := grouped[record.scope]; !ok {
+			scopes = append(scopes, record.scope)
+		}
+		grouped[record.scope] = append(grouped[record.scope], record)
+	}
+
+	return scopes, grouped
+}
+
+// encodeOTLPProtobuf encodes an ExportLogsServiceRequest, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/logs/v1/logs.proto.
+func encodeOTLPProtobuf(resource []otlpKeyValue, records []otlpRecord) []byte {
+	var resourceMsg []byte
+	for _, kv := range resource {
+		resourceMsg = appendProtoMessage(resourceMsg, 1, appendOTLPKeyValue(nil, kv))
+	}
+
+	resourceLogs := appendProtoMessage(nil, 1, resourceMsg)
+
+	scopes, grouped := otlpScopes(records)
+	for _, scope := range scopes {
+		scopeLogs := appendProtoMessage(nil, 1, appendProtoString(nil, 1, scope))
+		for _, r := range grouped[scope] {
+			var record []byte
+			record = appendProtoFixed64(record, 1, uint64(r.time.UnixNano()))
+			record = appendProtoInt(record, 2, r.severity)
+			record = appendProtoString(record, 3, r.level)
+			record = appendProtoMessage(record, 5, appendOTLPAnyValue(nil, r.body))
+			for _, kv := range r.attributes {
+				record = appendProtoMessage(record, 6, appendOTLPKeyValue(nil, kv))
+			}
+			record = appendProtoFixed32(record, 8, r.flags)
+			record = appendProtoBytes(record, 9, r.traceID)
+			record = appendProtoBytes(record, 10, r.spanID)
+			record = appendProtoFixed64(record, 11, uint64(r.observed.UnixNano()))
+
+			scopeLogs = appendProtoMessage(scopeLogs, 2, record)
+		}
+		resourceLogs = appendProtoMessage(resourceLogs, 2, scopeLogs)
+	}
+
+	return appendProtoMessage(nil, 1, resourceLogs)
+}
+
+// appendOTLPKeyValue appends the fields of a KeyValue message.
+func appendOTLPKeyValue(b []byte, kv otlpKeyValue) []byte {
+	b = appendProtoString(b, 1, kv.key)
+	return appendProtoMessage(b, 2, appendOTLPAnyValue(nil, kv.value))
+}
+
+// appendOTLPAnyValue appends the fields of an AnyValue message. As a oneof,
+// its value is written even when zero.
+func appendOTLPAnyValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return appendProtoMessage(b, 1, []byte(v))
+	case bool:
+		value := uint64(0)
+		if v {
+			value = 1
+		}
+		return appendProtoVarint(appendProtoKey(b, 2, protoVarint), value)
+	case int64:
+		return appendProtoVarint(appendProtoKey(b, 3, protoVarint), uint64(v))
+	case float64:
+		return appendProtoUint64(appendProtoKey(b, 4, protoFixed64), math.Float64bits(v))
+	case []interface{}:
+		var array []byte
+		for _, value := range v {
+			array = appendProtoMessage(array, 1, appendOTLPAnyValue(nil, value))
+		}
+		return appendProtoMessage(b, 5, array)
+	case []otlpKeyValue:
+		var list []byte
+		for _, kv := range v {
+			list = appendProtoMessage(list, 1, appendOTLPKeyValue(nil, kv))
+		}
+		return appendProtoMessage(b, 6, list)
+	case []byte:
+		return appendProtoMessage(b, 7, v)
+	}
+
+	// An empty AnyValue for nil.
+	return b
+}
+
+// encodeOTLPJSON encodes an ExportLogsServiceRequest with the JSON mapping
+// of OTLP: lowerCamelCase names, 64-bit integers as strings, and trace and
+// span IDs in hex.
+func encodeOTLPJSON(resource []otlpKeyValue, records []otlpRecord) ([]byte, error) {
+	type record struct {
+		TimeUnixNano         string        `json:"timeUnixNano"`
+		ObservedTimeUnixNano string        `json:"observedTimeUnixNano"`
+		SeverityNumber       int64         `json:"severityNumber,omitempty"`
+		SeverityText         string        `json:"severityText,omitempty"`
+		Body                 interface{}   `json:"body"`
+		Attributes           []interface{} `json:"attributes,omitempty"`
+		Flags                uint32        `json:"flags,omitempty"`
+		TraceID              string        `json:"traceId,omitempty"`
+		SpanID               string        `json:"spanId,omitempty"`
+	}
+	type scopeLogs struct {
+		Scope      map[string]string `json:"scope"`
+		LogRecords []record          `json:"logRecords"`
+	}
+
+	var resourceLogs struct {
+		Resource struct {
+			Attributes []interface{} `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []scopeLogs `json:"scopeLogs"`
+	}
+	resourceLogs.Resource.Attributes = otlpJSONKeyValues(resource)
+
+	scopes, grouped := otlpScopes(records)
+	for _, scope := range scopes {
+		s := scopeLogs{Scope: map[string]string{"name": scope}}
+		for _, r := range grouped[scope] {
+			s.LogRecords = append(s.LogRecords, record{
+				TimeUnixNano:         strconv.FormatInt(r.time.UnixNano(), 10),
+				ObservedTimeUnixNano: strconv.FormatInt(r.observed.UnixNano(), 10),
+				SeverityNumber:       r.severity,
+				SeverityText:         r.level,
+				Body:                 otlpJSONAnyValue(r.body),
+				Attributes:           otlpJSONKeyValues(r.attributes),
+				Flags:                r.flags,
+				TraceID:              hex.EncodeToString(r.traceID),
+				SpanID:               hex.EncodeToString(r.spanID),
+			})
+		}
+		resourceLogs.ScopeLogs = append(resourceLogs.ScopeLogs, s)
+	}
+
+	return json.Marshal(map[string]interface{}{"resourceLogs": []interface{}{resourceLogs}})
+}
+
+func otlpJSONKeyValues(kvs []otlpKeyValue) []interface{} {
+	values := make([]interface{}, len(kvs))
+	for i, kv := range kvs {
+		values[i] = map[string]interface{}{"key": kv.key, "value": otlpJSONAnyValue(kv.value)}
+	}
+
+	return values
+}
+
+func otlpJSONAnyValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		// JSON has no NaN nor infinities, which the mapping writes as strings.
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]interface{}{"doubleValue": strconv.FormatFloat(v, 'g', -1, 64)}
+		}
+		return map[string]interface{}{"doubleValue": v}
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = otlpJSONAnyValue(value)
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case []otlpKeyValue:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": otlpJSONKeyValues(v)}}
+	case []byte:
+		return map[string]interface{}{"bytesValue": v}
+	}
+
+	return map[string]interface{}{}
+}
@@ -0,0 +1,235 @@
+package gzap
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// otlpCollector is a stand-in OTLP/HTTP receiver, such as the logs endpoint
+// of an OpenTelemetry Collector.
+type otlpCollector struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	headers []http.Header
+	bodies  [][]byte
+}
+
+func newOTLPCollector() *otlpCollector {
+	c := &otlpCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		b, _ := ioutil.ReadAll(body)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.headers = append(c.headers, r.Header)
+		c.bodies = append(c.bodies, b)
+	}))
+
+	return c
+}
+
+func (c *otlpCollector) opts(encoding string) otlpOptions {
+	return otlpOptions{
+		endpoint:      c.URL + defaultOTLPPath,
+		encoding:      encoding,
+		batch:         100,
+		flushInterval: time.Hour,
+		timeout:       time.Second,
+		retry:         httpRetry{retries: 1, minBackoff: time.Millisecond, maxBackoff: time.Millisecond},
+	}
+}
+
+// protoGet returns the values of a field of a message.
+func protoGet(t *testing.T, msg interface{}, field int) []interface{} {
+	fields, err := decodeProto(msg.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []interface{}
+	for _, f := range fields {
+		if f.field == field {
+			values = append(values, f.value)
+		}
+	}
+
+	return values
+}
+
+// writeOTLPEntries writes the entries checked by the OTLP tests: one of the
+// root logger in a trace, and one of the db logger.
+func writeOTLPEntries(t *testing.T, core zapcore.Core) {
+	ctx := WithTraceContext(context.Background(), TraceContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}, Flags: 1})
+	logger := zap.New(core).With(traceContextFields(ctx)...)
+	logger.Warn("slow request", zap.Int("status", 200), zap.Bool("cached", false), zap.Strings("tags", []string{"a"}))
+
+	entry := zapcore.Entry{Level: zapcore.ErrorLevel, Time: time.Unix(1514905445, 123456789), LoggerName: "db", Message: "failed", Stack: "main.main()"}
+	if err := core.Write(entry, []zapcore.Field{zap.Error(errors.New("timeout"))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOTLPCore_Protobuf(t *testing.T) {
+	collector := newOTLPCollector()
+	defer collector.Close()
+
+	client := newOTLPClient(collector.opts(otlpEncodingProtobuf), "my-app", "3")
+	defer client.Close()
+
+	writeOTLPEntries(t, newOTLPCore(client))
+
+	expect(t, len(collector.bodies), 1)
+	expect(t, collector.headers[0].Get("Content-Type"), "application/x-protobuf")
+
+	resourceLogs := protoGet(t, collector.bodies[0], 1)
+	expect(t, len(resourceLogs), 1)
+
+	resource := protoGet(t, protoGet(t, resourceLogs[0], 1)[0], 1)
+	expect(t, len(resource), 2)
+	expect(t, string(protoGet(t, resource[0], 1)[0].([]byte)), "service.name")
+	expect(t, string(protoGet(t, protoGet(t, resource[0], 2)[0], 1)[0].([]byte)), "my-app")
+	expect(t, string(protoGet(t, resource[1], 1)[0].([]byte)), "deployment.environment")
+
+	scopeLogs := protoGet(t, resourceLogs[0], 2)
+	expect(t, len(scopeLogs), 2)
+	expect(t, string(protoGet(t, protoGet(t, scopeLogs[0], 1)[0], 1)[0].([]byte)), otlpScope)
+	expect(t, string(protoGet(t, protoGet(t, scopeLogs[1], 1)[0], 1)[0].([]byte)), "db")
+
+	record := protoGet(t, scopeLogs[0], 2)[0]
+	expect(t, protoGet(t, record, 2)[0], uint64(13))
+	expect(t, string(protoGet(t, record, 3)[0].([]byte)), "warn")
+	expect(t, string(protoGet(t, protoGet(t, record, 5)[0], 1)[0].([]byte)), "slow request")
+	expect(t, protoGet(t, record, 8)[0], uint32(1))
+	expect(t, reflect.DeepEqual(protoGet(t, record, 9)[0], []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}), true)
+	expect(t, reflect.DeepEqual(protoGet(t, record, 10)[0], []byte{2, 0, 0, 0, 0, 0, 0, 0}), true)
+
+	// Attributes are sorted by key, and the trace context is not one of them.
+	attributes := protoGet(t, record, 6)
+	expect(t, len(attributes), 3)
+	expect(t, string(protoGet(t, attributes[0], 1)[0].([]byte)), "cached")
+	expect(t, protoGet(t, protoGet(t, attributes[0], 2)[0], 2)[0], uint64(0))
+	expect(t, string(protoGet(t, attributes[1], 1)[0].([]byte)), "status")
+	expect(t, protoGet(t, protoGet(t, attributes[1], 2)[0], 3)[0], uint64(200))
+	tags := protoGet(t, protoGet(t, protoGet(t, attributes[2], 2)[0], 5)[0], 1)
+	expect(t, string(protoGet(t, tags[0], 1)[0].([]byte)), "a")
+
+	record = protoGet(t, scopeLogs[1], 2)[0]
+	expect(t, protoGet(t, record, 1)[0], uint64(1514905445123456789))
+	expect(t, protoGet(t, record, 2)[0], uint64(17))
+	expect(t, len(protoGet(t, record, 9)), 0)
+	attributes = protoGet(t, record, 6)
+	expect(t, string(protoGet(t, attributes[0], 1)[0].([]byte)), "error")
+	expect(t, string(protoGet(t, attributes[1], 1)[0].([]byte)), "exception.stacktrace")
+}
+
+func TestOTLPCore_JSON(t *testing.T) {
+	collector := newOTLPCollector()
+	defer collector.Close()
+
+	opts := collector.opts(otlpEncodingJSON)
+	opts.gzip = true
+	client := newOTLPClient(opts, "my-app", "3")
+	defer client.Close()
+
+	writeOTLPEntries(t, newOTLPCore(client))
+
+	expect(t, len(collector.bodies), 1)
+	expect(t, collector.headers[0].Get("Content-Type"), "application/json")
+	expect(t, collector.headers[0].Get("Content-Encoding"), "gzip")
+
+	var request struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []map[string]interface{} `json:"attributes"`
+			} `json:"resource"`
+			ScopeLogs []struct {
+				Scope      map[string]string        `json:"scope"`
+				LogRecords []map[string]interface{} `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	if err := json.Unmarshal(collector.bodies[0], &request); err != nil {
+		t.Fatal(err)
+	}
+
+	resourceLogs := request.ResourceLogs[0]
+	want := map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "my-app"}}
+	if !reflect.DeepEqual(resourceLogs.Resource.Attributes[0], want) {
+		t.Errorf("resource attribute = %v, want %v", resourceLogs.Resource.Attributes[0], want)
+	}
+
+	expect(t, resourceLogs.ScopeLogs[0].Scope["name"], otlpScope)
+	record := resourceLogs.ScopeLogs[0].LogRecords[0]
+	expect(t, record["severityNumber"], 13.0)
+	expect(t, record["severityText"], "warn")
+	expect(t, record["traceId"], "01000000000000000000000000000000")
+	expect(t, record["spanId"], "0200000000000000")
+	expect(t, record["flags"], 1.0)
+	if !reflect.DeepEqual(record["body"], map[string]interface{}{"stringValue": "slow request"}) {
+		t.Errorf("unexpected body %v", record["body"])
+	}
+	status := record["attributes"].([]interface{})[1]
+	if !reflect.DeepEqual(status, map[string]interface{}{"key": "status", "value": map[string]interface{}{"intValue": "200"}}) {
+		t.Errorf("unexpected attribute %v", status)
+	}
+
+	expect(t, resourceLogs.ScopeLogs[1].Scope["name"], "db")
+	expect(t, resourceLogs.ScopeLogs[1].LogRecords[0]["timeUnixNano"], "1514905445123456789")
+}
+
+func TestOTLPValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"a string", "a", "a"},
+		{"an int", 3, int64(3)},
+		{"a uint32", uint32(3), int64(3)},
+		{"a uint64 beyond int64", uint64(math.MaxUint64), "18446744073709551615"},
+		{"a float32", float32(1.5), 1.5},
+		{"a duration", time.Second, "1s"},
+		{"a time", time.Unix(0, 0).UTC(), "1970-01-01T00:00:00Z"},
+		{"an array", []interface{}{1, "a"}, []interface{}{int64(1), "a"}},
+		{"an object", map[string]interface{}{"b": 2, "a": true}, []otlpKeyValue{{"a", true}, {"b", int64(2)}}},
+		{"a reflected struct", struct {
+			ID    int
+			Ratio float64
+		}{42, 0.5}, []otlpKeyValue{{"ID", int64(42)}, {"Ratio", 0.5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := otlpValue(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("otlpValue(%v) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package gzap
+
+// Protocol Buffers encoding, see https://developers.google.com/protocol-buffers/docs/encoding.
+// Messages are written field by field by the sinks, which know their schema.
+
+// Wire types of the fields of a message.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+func appendProtoVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+
+	return append(b, byte(v))
+}
+
+func appendProtoKey(b []byte, field int, wireType int) []byte {
+	return appendProtoVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendProtoUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+// appendProtoInt appends an int32, int64, uint32, uint64, bool or enum field,
+// omitted when zero as proto3 does.
+func appendProtoInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	return appendProtoVarint(appendProtoKey(b, field, protoVarint), uint64(v))
+}
+
+// appendProtoFixed64 appends a fixed64 field, omitted when zero.
+func appendProtoFixed64(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	return appendProtoUint64(appendProtoKey(b, field, protoFixed64), v)
+}
+
+// appendProtoFixed32 appends a fixed32 field, omitted when zero.
+func appendProtoFixed32(b []byte, field int, v uint32) []byte {
+	if v == 0 {
+		return b
+	}
+
+	return append(appendProtoKey(b, field, protoFixed32), byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// appendProtoString appends a string field, omitted when empty.
+func appendProtoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = appendProtoVarint(appendProtoKey(b, field, protoBytes), uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendProtoBytes appends a bytes field, omitted when empty.
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	return appendProtoMessage(b, field, v)
+}
+
+// appendProtoMessage appends an embedded message, even when empty, as the
+// presence of a message field is meaningful.
+func appendProtoMessage(b []byte, field int, v []byte) []byte {
+	b = appendProtoVarint(appendProtoKey(b, field, protoBytes), uint64(len(v)))
+	return append(b, v...)
+}
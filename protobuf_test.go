@@ -0,0 +1,100 @@
+package gzap
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// protoField is a field decoded by decodeProto: a uint64 for varints and
+// fixed64, a uint32 for fixed32, or a []byte for strings, bytes and embedded
+// messages.
+type protoField struct {
+	field int
+	value interface{}
+}
+
+// decodeProto decodes the fields of a message, to check the encoded output.
+func decodeProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := readProtoVarint(b)
+		if n == 0 {
+			return nil, errors.New("truncated key")
+		}
+		b = b[n:]
+
+		switch key & 0x07 {
+		case protoFixed64:
+			if len(b) < 8 {
+				return nil, errors.New("truncated fixed64")
+			}
+			fields = append(fields, protoField{int(key >> 3), binary.LittleEndian.Uint64(b)})
+			b = b[8:]
+		case protoFixed32:
+			if len(b) < 4 {
+				return nil, errors.New("truncated fixed32")
+			}
+			fields = append(fields, protoField{int(key >> 3), binary.LittleEndian.Uint32(b)})
+			b = b[4:]
+		case protoVarint:
+			v, n := readProtoVarint(b)
+			if n == 0 {
+				return nil, errors.New("truncated varint")
+			}
+			fields = append(fields, protoField{int(key >> 3), v})
+			b = b[n:]
+		case protoBytes:
+			length, n := readProtoVarint(b)
+			if n == 0 || uint64(len(b)-n) < length {
+				return nil, errors.New("truncated bytes")
+			}
+			fields = append(fields, protoField{int(key >> 3), b[n : n+int(length)]})
+			b = b[n+int(length):]
+		default:
+			return nil, errors.New("unexpected wire type")
+		}
+	}
+
+	return fields, nil
+}
+
+func readProtoVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+
+	return 0, 0
+}
+
+func TestAppendProto(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"a small int", appendProtoInt(nil, 1, 150), "089601"},
+		{"a zero int", appendProtoInt(nil, 1, 0), ""},
+		{"a negative int", appendProtoInt(nil, 2, -1), "10ffffffffffffffffff01"},
+		{"a string", appendProtoString(nil, 2, "testing"), "120774657374696e67"},
+		{"an empty string", appendProtoString(nil, 2, ""), ""},
+		{"a message", appendProtoMessage(nil, 3, appendProtoInt(nil, 1, 150)), "1a03089601"},
+		{"an empty message", appendProtoMessage(nil, 3, nil), "1a00"},
+		{"a fixed64", appendProtoFixed64(nil, 1, 1514905445123456789), "09157fe3dc79060615"},
+		{"a zero fixed64", appendProtoFixed64(nil, 1, 0), ""},
+		{"a fixed32", appendProtoFixed32(nil, 8, 1), "4501000000"},
+		{"bytes", appendProtoBytes(nil, 9, []byte{0xab}), "4a01ab"},
+		{"empty bytes", appendProtoBytes(nil, 9, nil), ""},
+		{"a large field number", appendProtoInt(nil, 16, 1), "800101"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, hex.EncodeToString(tt.b), tt.want)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package gzap
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// redactedValue replaces the value of redacted fields.
+const redactedValue = "[REDACTED]"
+
+// redactRules matches field keys whose values must never leave the process.
+// Rules are case insensitive, and may use the wildcards of path.Match, e.g.
+// "*token*".
+type redactRules []string
+
+func newRedactRules(rules []string) redactRules {
+	normalized := make(redactRules, 0, len(rules))
+	for _, rule := range rules {
+		normalized = append(normalized, strings.ToLower(rule))
+	}
+
+	return normalized
+}
+
+func (r redactRules) match(key string) bool {
+	key = strings.ToLower(key)
+	for _, rule := range r {
+		if rule == key {
+			return true
+		}
+
+		if matched, _ := path.Match(rule, key); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply returns fields with the values of every matching key redacted. The
+// given slice is never modified.
+func (r redactRules) apply(fields []zapcore.Field) []zapcore.Field {
+	if len(r) == 0 {
+		return fields
+	}
+
+	var redacted []zapcore.Field
+	for i, field := range fields {
+		if !r.match(field.Key) {
+			continue
+		}
+
+		if redacted == nil {
+			redacted = make([]zapcore.Field, len(fields))
+			copy(redacted, fields)
+		}
+		redacted[i] = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: redactedValue}
+	}
+
+	if redacted == nil {
+		return fields
+	}
+
+	return redacted
+}
+
+// redactCore wraps a sink core, redacting fields before they reach it.
+//
+// Fields given at the log site are passed by zap straight to the cores added
+// in Check. The wrapped core is checked first, so that a tee or a sampler
+// returned by a sink factory still decides which of its cores log the entry,
+// and the redacting core added in its place writes to those.
+type redactCore struct {
+	zapcore.Core
+	rules redactRules
+}
+
+func newRedactCore(core zapcore.Core, rules redactRules) zapcore.Core {
+	if len(rules) == 0 {
+		return core
+	}
+
+	return redactCore{Core: core, rules: rules}
+}
+
+// With adds redacted structured context to the wrapped core.
+func (c redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{Core: c.Core.With(c.rules.apply(fields)), rules: c.rules}
+}
+
+// Check determines whether the supplied entry should be logged.
+func (c redactCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	checked := c.Core.Check(entry, nil)
+	if checked == nil {
+		return checkedEntry
+	}
+
+	return checkedEntry.AddCore(entry, redactCheckedCore{Core: c.Core, checked: checked, rules: c.rules})
+}
+
+// Write redacts fields and writes them to the wrapped core.
+func (c redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(entry, c.rules.apply(fields))
+}
+
+// redactCheckedCore writes redacted fields to the cores that the wrapped core
+// added in Check.
+type redactCheckedCore struct {
+	zapcore.Core
+	checked *zapcore.CheckedEntry
+	rules   redactRules
+}
+
+// Write redacts fields and writes them to the checked cores. The entry
+// carries the caller and stack trace that the logger adds after Check.
+func (c redactCheckedCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	var errs checkedErrors
+	c.checked.Entry = entry
+	c.checked.ErrorOutput = &errs
+	c.checked.Write(c.rules.apply(fields)...)
+
+	return errs.err
+}
+
+// checkedErrors collects the errors that a CheckedEntry reports to its
+// ErrorOutput, e.g. "2006-01-02 15:04:05 write error: ...".
+type checkedErrors struct {
+	err error
+}
+
+func (e *checkedErrors) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	if i := strings.Index(msg, "write error: "); i >= 0 {
+		msg = msg[i+len("write error: "):]
+	}
+	e.err = errors.New(msg)
+
+	return len(p), nil
+}
+
+func (e *checkedErrors) Sync() error {
+	return nil
+}
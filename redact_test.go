@@ -0,0 +1,56 @@
+package gzap
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedactCore(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	rules := newRedactRules([]string{"password", "*TOKEN*"})
+	l := zap.New(newRedactCore(core, rules)).With(String("auth_token", "abc"))
+
+	fields := []zapcore.Field{String("user", "jane"), String("Password", "hunter2")}
+	l.Info("login", fields...)
+	l.Debug("filtered", String("password", "hunter2"))
+
+	expect(t, logs.Len(), 1)
+
+	ctx := logs.All()[0].ContextMap()
+	expect(t, ctx["user"], "jane")
+	expect(t, ctx["Password"], redactedValue)
+	expect(t, ctx["auth_token"], redactedValue)
+
+	// The caller's fields must be left untouched.
+	expect(t, fields[1].String, "hunter2")
+}
+
+func TestRedactCore_Check(t *testing.T) {
+	debugCore, debugLogs := observer.New(zapcore.DebugLevel)
+	errorCore, errorLogs := observer.New(zapcore.ErrorLevel)
+	sampledCore, sampledLogs := observer.New(zapcore.DebugLevel)
+
+	// Sink factories may return cores filtering entries in Check.
+	core := zapcore.NewTee(
+		debugCore,
+		errorCore,
+		zapcore.NewSampler(sampledCore, time.Minute, 1, 100),
+	)
+	l := zap.New(newRedactCore(core, newRedactRules([]string{"password"})), zap.AddCaller())
+
+	l.Info("login", String("password", "hunter2"))
+	l.Info("login", String("password", "hunter2"))
+
+	expect(t, debugLogs.Len(), 2)
+	expect(t, errorLogs.Len(), 0)
+	expect(t, sampledLogs.Len(), 1)
+
+	entry := debugLogs.All()[0]
+	expect(t, entry.ContextMap()["password"], redactedValue)
+	expect(t, entry.Caller.Defined, true)
+	expect(t, sampledLogs.All()[0].ContextMap()["password"], redactedValue)
+}
@@ -0,0 +1,434 @@
+package gzap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultPollInterval = time.Second * 5
+	defaultDrainTimeout = time.Second * 5
+)
+
+// coreGeneration is one set of cores swapped into a reloadableCore, along
+// with what must be closed once it has been replaced.
+type coreGeneration struct {
+	core   zapcore.Core
+	closer func() error
+}
+
+// drain gives in-flight entries time to reach the replaced cores, then flushes
+// and closes them.
+func (g *coreGeneration) drain(timeout time.Duration) {
+	time.Sleep(timeout)
+	g.close()
+}
+
+func (g *coreGeneration) close() error {
+	err := g.core.Sync()
+	if g.closer != nil {
+		if closeErr := g.closer(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// reloadState is shared by a reloadableCore and every core derived from it.
+type reloadState struct {
+	mu           sync.Mutex
+	current      atomic.Value // *coreGeneration
+	drainTimeout int64
+}
+
+// derivedCore caches the current generation with the context of a derived
+// reloadableCore applied.
+type derivedCore struct {
+	gen  *coreGeneration
+	core zapcore.Core
+}
+
+// reloadableCore is a zapcore.Core whose underlying cores can be atomically
+// swapped. Cores derived through With keep their context across swaps.
+type reloadableCore struct {
+	state   *reloadState
+	fields  []zapcore.Field
+	derived *atomic.Value // *derivedCore
+}
+
+func newReloadableCore() *reloadableCore {
+	state := &reloadState{drainTimeout: int64(defaultDrainTimeout)}
+	state.current.Store(&coreGeneration{core: zapcore.NewNopCore()})
+
+	return &reloadableCore{state: state, derived: &atomic.Value{}}
+}
+
+// swap replaces the underlying cores, and drains the previous ones in the
+// background.
+func (c *reloadableCore) swap(core zapcore.Core, closer func() error) {
+	previous := c.exchange(&coreGeneration{core: core, closer: closer})
+
+	go previous.drain(time.Duration(atomic.LoadInt64(&c.state.drainTimeout)))
+}
+
+// close replaces the underlying cores with a no-op core, and closes them right
+// away.
+func (c *reloadableCore) close() error {
+	return c.exchange(&coreGeneration{core: zapcore.NewNopCore()}).close()
+}
+
+func (c *reloadableCore) exchange(next *coreGeneration) *coreGeneration {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	previous := c.state.current.Load().(*coreGeneration)
+	c.state.current.Store(next)
+
+	return previous
+}
+
+func (c *reloadableCore) setDrainTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.state.drainTimeout, int64(timeout))
+}
+
+func (c *reloadableCore) core() zapcore.Core {
+	gen := c.state.current.Load().(*coreGeneration)
+	if len(c.fields) == 0 {
+		return gen.core
+	}
+
+	if d, ok := c.derived.Load().(*derivedCore); ok && d.gen == gen {
+		return d.core
+	}
+
+	d := &derivedCore{gen: gen, core: gen.core.With(c.fields)}
+	c.derived.Store(d)
+
+	return d.core
+}
+
+// Enabled determines whether the current cores log at the given level.
+func (c *reloadableCore) Enabled(level zapcore.Level) bool {
+	return c.core().Enabled(level)
+}
+
+// With adds structured context, which survives later swaps.
+func (c *reloadableCore) With(fields []zapcore.Field) zapcore.Core {
+	combined := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	combined = append(combined, c.fields...)
+	combined = append(combined, fields...)
+
+	return &reloadableCore{state: c.state, fields: combined, derived: &atomic.Value{}}
+}
+
+// Check lets the current cores decide whether to log the entry.
+func (c *reloadableCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.core().Check(entry, checkedEntry)
+}
+
+// Write writes to the current cores.
+func (c *reloadableCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.core().Write(entry, fields)
+}
+
+// Sync flushes the current cores.
+func (c *reloadableCore) Sync() error {
+	return c.core().Sync()
+}
+
+// WatchOptions configures Handle.Watch and WatchConfig.
+type WatchOptions struct {
+	// File is an optional file of KEY=VALUE lines setting gzap environment
+	// variables, re-read on every reload. Variables set in the process
+	// environment take precedence over the file.
+	File string
+
+	// PollInterval is how often File is checked for changes, 5 seconds by
+	// default.
+	PollInterval time.Duration
+
+	// DrainTimeout is how long replaced cores are kept open for in-flight
+	// entries before being flushed and closed, 5 seconds by default.
+	DrainTimeout time.Duration
+}
+
+// WatchConfig reloads the configuration of the global Logger, see Handle.Watch.
+func WatchConfig(opts WatchOptions) (func(), error) {
+	return global.Watch(opts)
+}
+
+// Watch reloads the configuration of the logger whenever the
+// process receives SIGHUP, or the watched file changes. When File is set it is
+// applied right away.
+//
+// The configuration is read again from where it came from: the environment
+// for the global Logger and configurations built by NewEnvConfig, the file
+// for LoadConfigFile. Configurations built by NewConfig, or implemented
+// outside of gzap, can't be watched.
+//
+// The console and Graylog cores are rebuilt and atomically swapped into the
+// logger, and every logger derived from it. Levels changed at runtime
+// through the LevelHandler are kept. A configuration that fails to validate,
+// or a Graylog endpoint that can't be reached, is logged and leaves the
+// current cores in place.
+//
+// The returned func stops watching.
+func (h *Handle) Watch(opts WatchOptions) (func(), error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = defaultDrainTimeout
+	}
+	source, ok := h.configSource()
+	if !ok {
+		return nil, errors.New("gzap: the configuration of the logger was not read from the environment or a file")
+	}
+	h.core.setDrainTimeout(opts.DrainTimeout)
+
+	w := &configWatcher{
+		handle:   h,
+		source:   source,
+		opts:     opts,
+		signals:  make(chan os.Signal, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		versions: map[string]string{},
+	}
+
+	w.configFile = source.file
+	if cfg, ok := h.config().(*EnvConfig); ok && source.env {
+		w.configFile = cfg.configFile
+	}
+	if opts.File != "" {
+		if _, err := os.Stat(opts.File); err != nil {
+			return nil, err
+		}
+
+		if err := w.reload("watch started"); err != nil {
+			return nil, err
+		}
+	}
+	w.changed()
+
+	signal.Notify(w.signals, syscall.SIGHUP)
+	go w.run()
+
+	return w.stop, nil
+}
+
+type configWatcher struct {
+	handle   *Handle
+	source   configSource
+	opts     WatchOptions
+	signals  chan os.Signal
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// versions identifies the last seen content of every watched file.
+	versions map[string]string
+
+	mu         sync.Mutex
+	configFile string
+}
+
+func (w *configWatcher) run() {
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+	defer close(w.stopped)
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case <-w.signals:
+			w.reload("SIGHUP")
+
+		case <-ticker.C:
+			if changed := w.changed(); len(changed) > 0 {
+				w.reload(fmt.Sprintf("%s changed", strings.Join(changed, ", ")))
+			}
+		}
+	}
+}
+
+// changed returns the watched files modified since the last call.
+func (w *configWatcher) changed() []string {
+	w.mu.Lock()
+	files := []string{w.opts.File, w.configFile}
+	w.mu.Unlock()
+
+	changed := []string{}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+
+		version := fileVersion(info)
+		if previous, ok := w.versions[file]; ok && previous != version {
+			changed = append(changed, file)
+		}
+		w.versions[file] = version
+	}
+
+	return changed
+}
+
+func (w *configWatcher) stop() {
+	w.stopOnce.Do(func() {
+		signal.Stop(w.signals)
+		close(w.done)
+		<-w.stopped
+	})
+}
+
+func (w *configWatcher) reload(reason string) error {
+	err := w.apply(reason)
+	if err != nil {
+		w.handle.Logger().Error("gzap configuration reload failed, keeping the current configuration",
+			String("reason", reason),
+			Error(err),
+		)
+	}
+
+	return err
+}
+
+func (w *configWatcher) apply(reason string) error {
+	layers := []configLayer{}
+	if w.opts.File != "" {
+		layer, err := envFileLayer(w.opts.File)
+		if err != nil {
+			return err
+		}
+		layers = append(layers, layer)
+	}
+
+	cfg, err := newEnvConfig(w.source.resolver(layers...))
+	if err != nil {
+		return err
+	}
+
+	changes, err := w.handle.reload(cfg)
+	if err != nil {
+		return err
+	}
+
+	if w.source.env {
+		w.mu.Lock()
+		w.configFile = cfg.configFile
+		w.mu.Unlock()
+	}
+
+	w.handle.Logger().Info("gzap configuration reloaded",
+		String("reason", reason),
+		Strings("changed", changes),
+	)
+
+	return nil
+}
+
+// fileVersion identifies the content of a watched file without reading it.
+func fileVersion(info os.FileInfo) string {
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// readEnvFile parses a file of KEY=VALUE lines. Blank lines and lines
+// starting with # are ignored, and values may be quoted.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+
+		key := strings.TrimSpace(strings.TrimPrefix(line[:i], "export "))
+		value := strings.TrimSpace(line[i+1:])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		vars[key] = value
+	}
+
+	return vars, scanner.Err()
+}
+
+// describeConfig lists the settings of cfg that are safe to log.
+func describeConfig(cfg Config) map[string]string {
+	if envConfig, ok := cfg.(*EnvConfig); ok {
+		return envConfig.Report().values()
+	}
+
+	return map[string]string{}
+}
+
+// diffConfigs describes every setting that differs between two configs, as
+// "NAME: old -> new".
+func diffConfigs(previous Config, next Config) []string {
+	before := describeConfig(previous)
+	after := describeConfig(next)
+
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	changes := []string{}
+	for name := range names {
+		if before[name] != after[name] {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", name, before[name], after[name]))
+		}
+	}
+	sort.Strings(changes)
+
+	return changes
+}
+
+// levelsChanged reports whether a reload changed the configured levels, in
+// which case they replace the levels set at runtime.
+func levelsChanged(changes []string) bool {
+	for _, change := range changes {
+		if strings.HasPrefix(change, "GZAP_LEVEL:") || strings.HasPrefix(change, "GZAP_LOGGER_LEVELS:") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,222 @@
+package gzap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestReloadableCore(t *testing.T) {
+	core := newReloadableCore()
+	core.setDrainTimeout(0)
+
+	before, beforeLogs := observer.New(zapcore.DebugLevel)
+	core.swap(before, nil)
+
+	l := zap.New(core).With(String("request_id", "abc"))
+	l.Info("before swap")
+
+	after, afterLogs := observer.New(zapcore.InfoLevel)
+	core.swap(after, nil)
+
+	l.Info("after swap")
+	l.Debug("filtered by the new core")
+
+	expect(t, beforeLogs.Len(), 1)
+	expect(t, afterLogs.Len(), 1)
+	expect(t, afterLogs.All()[0].ContextMap()["request_id"], "abc")
+}
+
+// newEnvTestHandle returns a Handle configured by the environment.
+func newEnvTestHandle(t *testing.T) *Handle {
+	cfg, err := NewEnvConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func TestHandle_Watch(t *testing.T) {
+	restore := setEnv(map[string]string{})
+	defer restore()
+
+	h := newEnvTestHandle(t)
+	defer h.Close()
+
+	dir, err := ioutil.TempDir("", "gzap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "gzap.env")
+	if err := ioutil.WriteFile(file, []byte("# gzap\nGRAYLOG_APP_NAME=before\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stop, err := h.Watch(WatchOptions{File: file, PollInterval: time.Millisecond * 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, h.config().getGraylogAppName(), "before")
+
+	// Make sure the modification time moves on, even on coarse filesystems.
+	time.Sleep(time.Millisecond * 20)
+	if err := ioutil.WriteFile(file, []byte("GRAYLOG_APP_NAME=\"after the change\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	stop()
+	expect(t, h.config().getGraylogAppName(), "after the change")
+
+	// Invalid configurations are rejected, and keep the current one.
+	if err := ioutil.WriteFile(file, []byte("GRAYLOG_HANDLER_TYPE=pigeon\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stop, err = h.Watch(WatchOptions{File: file})
+	if err == nil {
+		stop()
+		t.Fatal("Watch() expected an error for an invalid configuration")
+	}
+	expect(t, h.config().getGraylogAppName(), "after the change")
+}
+
+func TestHandle_Watch_SIGHUP(t *testing.T) {
+	restore := setEnv(map[string]string{"GRAYLOG_APP_NAME": "before"})
+	defer restore()
+
+	h := newEnvTestHandle(t)
+	defer h.Close()
+
+	stop, err := h.Watch(WatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("GRAYLOG_APP_NAME", "after")
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	time.Sleep(time.Millisecond * 200)
+
+	stop()
+	expect(t, h.config().getGraylogAppName(), "after")
+}
+
+func TestHandle_Watch_ConfigFile(t *testing.T) {
+	restore := setEnv(map[string]string{"GZAP_LEVEL": "debug", "GZAP_FIELDS": "team:env"})
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "gzap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "audit.json")
+	if err := ioutil.WriteFile(file, []byte(`{"level": "warn"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfigFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	stop, err := h.Watch(WatchOptions{PollInterval: time.Millisecond * 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	time.Sleep(time.Millisecond * 200)
+
+	// The environment must not leak into a logger configured by a file.
+	expect(t, h.config().getLevel(), zapcore.WarnLevel)
+	expect(t, len(h.config().getStaticFields()), 0)
+
+	if err := ioutil.WriteFile(file, []byte(`{"level": "error"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	stop()
+	expect(t, h.config().getLevel(), zapcore.ErrorLevel)
+	expect(t, len(h.config().getStaticFields()), 0)
+}
+
+func TestHandle_Watch_WithoutSource(t *testing.T) {
+	h := newTestHandle(t)
+	defer h.Close()
+
+	if stop, err := h.Watch(WatchOptions{}); err == nil {
+		stop()
+		t.Error("Watch() expected an error for a configuration built by NewConfig")
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gzap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "gzap.env")
+	ioutil.WriteFile(file, []byte("export GRAYLOG_HOST=graylog\n\nGRAYLOG_ENV = 3\nnot a variable\n"), 0600)
+
+	if _, err := readEnvFile(file); err == nil {
+		t.Error("readEnvFile() expected an error for a malformed line")
+	}
+
+	ioutil.WriteFile(file, []byte("export GRAYLOG_HOST=graylog\n\nGRAYLOG_ENV = 3\n"), 0600)
+	vars, err := readEnvFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, vars["GRAYLOG_HOST"], "graylog")
+	expect(t, vars["GRAYLOG_ENV"], "3")
+}
+
+func TestDiffConfigs(t *testing.T) {
+	config := func(settings ...ConfigSetting) *EnvConfig {
+		return &EnvConfig{report: ConfigReport{Settings: settings}}
+	}
+
+	previous := config(
+		ConfigSetting{Var: "GZAP_LEVEL", Value: "info", Source: "env"},
+		ConfigSetting{Var: "GZAP_SINKS", Value: "stdout", Source: "env"},
+	)
+	next := config(
+		ConfigSetting{Var: "GZAP_LEVEL", Value: "debug", Source: "env"},
+		ConfigSetting{Var: "GZAP_FIELDS", Value: "team=search", Source: "env"},
+	)
+
+	changes := diffConfigs(previous, next)
+	want := []string{
+		`GZAP_FIELDS: "" -> "team=search"`,
+		`GZAP_LEVEL: "info" -> "debug"`,
+		`GZAP_SINKS: "stdout" -> ""`,
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("diffConfigs() = %q, want %q", changes, want)
+	}
+}
@@ -0,0 +1,541 @@
+package gzap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultSentryRate    = 1.0
+	defaultSentryBurst   = 10
+	defaultSentryTimeout = 5 * time.Second
+
+	// defaultSentryRetryAfter is how long events are dropped after a 429
+	// without Retry-After.
+	defaultSentryRetryAfter = time.Minute
+
+	// sentryMaxTag is the maximum length of the value of a tag.
+	sentryMaxTag = 200
+)
+
+// sentryOptions configures a Sentry sink.
+type sentryOptions struct {
+	// endpoint is the URL of the envelope endpoint, and key the public key of
+	// the DSN.
+	endpoint string
+	dsn      string
+	key      string
+
+	release string
+
+	// rate is the number of events sent per second on average, up to burst
+	// at once. Events beyond are dropped.
+	rate  float64
+	burst int
+
+	timeout    time.Duration
+	skipVerify bool
+}
+
+// parseSentrySink parses a Sentry DSN prefixed with "sentry+", e.g.
+// "sentry+https://<key>@o0.ingest.sentry.io/<project>", with the release,
+// rate, burst, timeout and skip_verify options. The release defaults to
+// DD_VERSION.
+func parseSentrySink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+	allowed := []string{"release", "rate", "burst", "timeout"}
+	if u.Scheme == "sentry+https" {
+		allowed = append(allowed, "skip_verify")
+	}
+	if err := checkSinkParams(query, allowed...); err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("no public key, expected as the user of the DSN")
+	}
+
+	project := path.Base(u.Path)
+	if _, err := strconv.ParseUint(project, 10, 64); err != nil {
+		return nil, fmt.Errorf("no project ID, expected at the end of the path of the DSN")
+	}
+
+	scheme := strings.TrimPrefix(u.Scheme, "sentry+")
+	dsn := url.URL{Scheme: scheme, User: url.User(u.User.Username()), Host: u.Host, Path: u.Path}
+	endpoint := url.URL{Scheme: scheme, Host: u.Host, Path: path.Join(path.Dir(u.Path), "api", project, "envelope") + "/"}
+
+	opts := sentryOptions{
+		endpoint: endpoint.String(),
+		dsn:      dsn.String(),
+		key:      u.User.Username(),
+		release:  query.Get("release"),
+		rate:     defaultSentryRate,
+		burst:    defaultSentryBurst,
+		timeout:  defaultSentryTimeout,
+	}
+
+	if text := query.Get("rate"); text != "" {
+		rate, err := strconv.ParseFloat(text, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("rate: could not parse %q as a number of events per second", text)
+		}
+		opts.rate = rate
+	}
+
+	if text := query.Get("burst"); text != "" {
+		burst, err := strconv.Atoi(text)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("burst: could not parse %q as a number of events", text)
+		}
+		opts.burst = burst
+	}
+
+	if err := parseSinkDuration(query, "timeout", &opts.timeout); err != nil {
+		return nil, err
+	}
+
+	if err := parseSinkBool(query, "skip_verify", &opts.skipVerify); err != nil {
+		return nil, err
+	}
+
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		opts := opts
+		datadog := cfg.getConsoleOptions().datadog
+		if opts.release == "" {
+			opts.release = datadog.version
+		}
+
+		client := newSentryClient(opts)
+		core := newSentryCore(client, cfg.getGraylogLogEnvName(), opts.release, datadog.host)
+
+		return core, client.Close, nil
+	}, nil
+}
+
+// sentryCore reports Error and above entries as Sentry events: the message
+// and error of an entry are its exception, the stack trace of the entry its
+// stack trace, and its fields are tags, or extra data when structured.
+//
+// Events are grouped by their fingerprint, the logger name, message and
+// first in-app function of the entry, rather than by their stack trace, so
+// that entries logged at the same place with different fields are one issue.
+type sentryCore struct {
+	environment string
+	release     string
+	serverName  string
+
+	context []zapcore.Field
+	client  *sentryClient
+}
+
+func newSentryCore(client *sentryClient, environment string, release string, serverName string) *sentryCore {
+	return &sentryCore{environment: environment, release: release, serverName: serverName, client: client}
+}
+
+// Enabled only accepts Error and above, which the level of the sink may raise.
+func (c *sentryCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.ErrorLevel
+}
+
+func (c *sentryCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.context = append(c.context[:len(c.context):len(c.context)], fields...)
+
+	return &clone
+}
+
+func (c *sentryCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+func (c *sentryCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if !c.Enabled(entry.Level) {
+		return nil
+	}
+
+	eventID, err := newSentryEventID()
+	if err != nil {
+		return err
+	}
+
+	event := sentryEvent{
+		EventID:     eventID,
+		Timestamp:   entry.Time.UTC().Format(time.RFC3339Nano),
+		Platform:    "go",
+		Level:       sentryLevel(entry.Level),
+		Logger:      entry.LoggerName,
+		Message:     map[string]string{"formatted": entry.Message},
+		Environment: c.environment,
+		Release:     c.release,
+		ServerName:  c.serverName,
+		SDK:         map[string]string{"name": "gzap", "version": "1"},
+	}
+
+	// Without an error, the exception is named after the logger, so that
+	// events are not titled with their message twice.
+	exception := sentryException{Type: "Error", Value: entry.Message}
+	if entry.LoggerName != "" {
+		exception.Type = entry.LoggerName
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range append(c.context[:len(c.context):len(c.context)], fields...) {
+		if err, ok := field.Interface.(error); ok && field.Type == zapcore.ErrorType && field.Key == "error" {
+			exception.Type, exception.Value = fmt.Sprintf("%T", err), err.Error()
+		}
+		field.AddTo(enc)
+	}
+	event.Tags, event.Extra = sentryTags(enc.Fields)
+
+	frames := parseSentryFrames(entry.Stack)
+	if len(frames) > 0 {
+		exception.Stacktrace = &sentryStacktrace{Frames: frames}
+	}
+	event.Exception = &sentryExceptions{Values: []sentryException{exception}}
+
+	function := ""
+	for i := len(frames) - 1; i >= 0; i-- {
+		if frames[i].InApp {
+			function = frames[i].Module + "." + frames[i].Function
+			break
+		}
+	}
+	event.Fingerprint = []string{entry.LoggerName, entry.Message, function}
+
+	return c.client.add(event, 0)
+}
+
+func (c *sentryCore) Sync() error {
+	return c.client.sync()
+}
+
+func sentryLevel(level zapcore.Level) string {
+	if level >= zapcore.PanicLevel {
+		return "fatal"
+	}
+
+	return "error"
+}
+
+// newSentryEventID returns a random UUID, as 32 hex digits.
+func newSentryEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return hex.EncodeToString(b), nil
+}
+
+// sentryTags splits the fields of a MapObjectEncoder into tags, which values
+// are strings of up to 200 characters, and extra data for structured fields.
+func sentryTags(fields map[string]interface{}) (map[string]string, map[string]interface{}) {
+	tags := map[string]string{}
+	extra := map[string]interface{}{}
+	for key, value := range fields {
+		switch value := value.(type) {
+		case map[string]interface{}, []interface{}:
+			extra[key] = value
+		default:
+			tag := fmt.Sprint(value)
+			if len(tag) > sentryMaxTag {
+				tag = tag[:sentryMaxTag]
+			}
+			tags[key] = tag
+		}
+	}
+
+	return tags, extra
+}
+
+// sentryEvent is an event of the Sentry protocol, see
+// https://develop.sentry.dev/sdk/event-payloads/.
+type sentryEvent struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   string                 `json:"timestamp"`
+	Platform    string                 `json:"platform"`
+	Level       string                 `json:"level"`
+	Logger      string                 `json:"logger,omitempty"`
+	Message     map[string]string      `json:"message"`
+	Exception   *sentryExceptions      `json:"exception,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	ServerName  string                 `json:"server_name,omitempty"`
+	Fingerprint []string               `json:"fingerprint"`
+	SDK         map[string]string      `json:"sdk"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module"`
+	AbsPath  string `json:"abs_path"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// parseSentryFrames parses a stack trace taken by zap, a function and its
+// tab-indented file:line per frame, into frames ordered from the outermost
+// call, as Sentry expects.
+func parseSentryFrames(stack string) []sentryFrame {
+	lines := strings.Split(strings.TrimSpace(stack), "\n")
+
+	var frames []sentryFrame
+	for i := 0; i+1 < len(lines); i += 2 {
+		function := strings.TrimSpace(lines[i])
+		location := strings.TrimSpace(lines[i+1])
+
+		colon := strings.LastIndex(location, ":")
+		if colon < 0 {
+			continue
+		}
+		line, err := strconv.Atoi(location[colon+1:])
+		if err != nil {
+			continue
+		}
+		file := location[:colon]
+
+		// The package path ends at the first dot after its last slash, e.g.
+		// github.com/dailymuse/gzap.(*Logger).Info.
+		module, name := "", function
+		if dot := strings.Index(function[strings.LastIndex(function, "/")+1:], "."); dot >= 0 {
+			dot += strings.LastIndex(function, "/") + 1
+			module, name = function[:dot], function[dot+1:]
+		}
+
+		frames = append(frames, sentryFrame{
+			Function: name,
+			Module:   module,
+			AbsPath:  file,
+			Filename: path.Base(file),
+			Lineno:   line,
+			InApp:    isSentryInApp(module),
+		})
+	}
+
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+
+	return frames
+}
+
+// isSentryInApp reports whether a frame is part of the application, rather
+// than of the runtime, the standard library or the logger.
+func isSentryInApp(module string) bool {
+	if module == "" || module == "github.com/dailymuse/gzap" || strings.HasPrefix(module, "go.uber.org/zap") {
+		return false
+	}
+
+	// Packages of the standard library have no dot in their first element.
+	first := module
+	if slash := strings.Index(module, "/"); slash >= 0 {
+		first = module[:slash]
+	}
+
+	return strings.Contains(first, ".") || module == "main"
+}
+
+// sentryClient posts events to the envelope endpoint of Sentry, in the
+// background, dropping them beyond its rate or while Sentry asked to back off.
+type sentryClient struct {
+	*batcher
+
+	opts sentryOptions
+	http *http.Client
+
+	mu sync.Mutex
+	// tokens is the number of events that may be sent at once, refilled at
+	// opts.rate per second since refilled.
+	tokens   float64
+	refilled time.Time
+	// disabledUntil is when events may be sent again after a 429.
+	disabledUntil time.Time
+
+	// now is time.Now, overridden in tests.
+	now func() time.Time
+}
+
+func newSentryClient(opts sentryOptions) *sentryClient {
+	c := &sentryClient{
+		opts: opts,
+		http: &http.Client{
+			Timeout: opts.timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.skipVerify},
+			},
+		},
+		tokens: float64(opts.burst),
+		now:    time.Now,
+	}
+	c.refilled = c.now()
+	c.batcher = newBatcher("sentry "+opts.endpoint, 1, 0, time.Second, c.post)
+
+	return c
+}
+
+// add queues an event, unless it is rate limited.
+func (c *sentryClient) add(event sentryEvent, size int) error {
+	if !c.allow() {
+		return nil
+	}
+
+	return c.batcher.add(event, size)
+}
+
+// allow reports whether an event may be sent, taking a token if so.
+func (c *sentryClient) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Before(c.disabledUntil) {
+		return false
+	}
+
+	c.tokens += now.Sub(c.refilled).Seconds() * c.opts.rate
+	if c.tokens > float64(c.opts.burst) {
+		c.tokens = float64(c.opts.burst)
+	}
+	c.refilled = now
+
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+
+	return true
+}
+
+func (c *sentryClient) post(items []interface{}) error {
+	for _, item := range items {
+		if err := c.postEvent(item.(sentryEvent)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *sentryClient) postEvent(event sentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(map[string]string{
+		"event_id": event.EventID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339),
+		"dsn":      c.opts.dsn,
+	})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	body.Write(header)
+	fmt.Fprintf(&body, "\n{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest("POST", c.opts.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("User-Agent", "gzap")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=gzap/1, sentry_key=%s", c.opts.key))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if retryAfter, ok := sentryRetryAfter(resp); ok {
+		c.mu.Lock()
+		c.disabledUntil = c.now().Add(retryAfter)
+		c.mu.Unlock()
+	}
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+// sentryRetryAfter returns how long to stop sending errors, from the
+// X-Sentry-Rate-Limits header, or the Retry-After header of a 429, see
+// https://develop.sentry.dev/sdk/rate-limiting/.
+func sentryRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if limits := resp.Header.Get("X-Sentry-Rate-Limits"); limits != "" {
+		var retryAfter time.Duration
+		for _, limit := range strings.Split(limits, ",") {
+			parts := strings.Split(strings.TrimSpace(limit), ":")
+			seconds, err := strconv.Atoi(parts[0])
+			if err != nil || len(parts) < 2 {
+				continue
+			}
+
+			applies := parts[1] == ""
+			for _, category := range strings.Split(parts[1], ";") {
+				applies = applies || category == "error"
+			}
+			if d := time.Duration(seconds) * time.Second; applies && d > retryAfter {
+				retryAfter = d
+			}
+		}
+
+		return retryAfter, retryAfter > 0
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	return defaultSentryRetryAfter, true
+}
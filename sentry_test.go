@@ -0,0 +1,310 @@
+package gzap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// sentryServer is a stand-in envelope endpoint, answering with the queued
+// responses before accepting events.
+type sentryServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter)
+	auth      []string
+	headers   []map[string]interface{}
+	events    []map[string]interface{}
+}
+
+func newSentryServer(responses ...func(w http.ResponseWriter)) *sentryServer {
+	s := &sentryServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if r.URL.Path != "/api/42/envelope/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if len(s.responses) > 0 {
+			respond := s.responses[0]
+			s.responses = s.responses[1:]
+			respond(w)
+			return
+		}
+
+		reader := bufio.NewReader(r.Body)
+		var header, item, event map[string]interface{}
+		for _, v := range []*map[string]interface{}{&header, &item, &event} {
+			line, _ := reader.ReadBytes('\n')
+			if err := json.Unmarshal(line, v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if item["type"] != "event" {
+			http.Error(w, "not an event", http.StatusBadRequest)
+			return
+		}
+
+		s.auth = append(s.auth, r.Header.Get("X-Sentry-Auth"))
+		s.headers = append(s.headers, header)
+		s.events = append(s.events, event)
+	}))
+
+	return s
+}
+
+func (s *sentryServer) opts() sentryOptions {
+	return sentryOptions{
+		endpoint: s.URL + "/api/42/envelope/",
+		dsn:      "http://abc123@" + strings.TrimPrefix(s.URL, "http://") + "/42",
+		key:      "abc123",
+		rate:     1,
+		burst:    10,
+		timeout:  time.Second,
+	}
+}
+
+const sentryTestStack = `github.com/dailymuse/gzap.Error
+	/go/src/github.com/dailymuse/gzap/global.go:42
+github.com/acme/shop/orders.(*Service).Create
+	/go/src/github.com/acme/shop/orders/service.go:87
+main.main
+	/go/src/github.com/acme/shop/main.go:12
+runtime.main
+	/usr/local/go/src/runtime/proc.go:250`
+
+func TestSentryCore(t *testing.T) {
+	server := newSentryServer()
+	defer server.Close()
+
+	client := newSentryClient(server.opts())
+	defer client.Close()
+
+	logger := zap.New(newSentryCore(client, "3", "1.2.3", "web-1")).Named("orders").With(zap.String("tenant", "acme"))
+	logger.Info("ignored")
+	logger.Warn("ignored")
+
+	entry := zapcore.Entry{Level: zapcore.ErrorLevel, Time: time.Unix(1514905445, 0), LoggerName: "orders", Message: "could not create order", Stack: sentryTestStack}
+	fields := []zapcore.Field{zap.Error(errors.New("out of stock")), zap.Int("items", 3), zap.Any("cart", map[string]interface{}{"id": 7})}
+	core := newSentryCore(client, "3", "1.2.3", "web-1").With([]zapcore.Field{zap.String("tenant", "acme")})
+	if err := core.Write(entry, fields); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.events), 1)
+	expect(t, strings.Contains(server.auth[0], "sentry_key=abc123"), true)
+
+	event := server.events[0]
+	expect(t, server.headers[0]["event_id"], event["event_id"])
+	expect(t, len(event["event_id"].(string)), 32)
+	expect(t, event["timestamp"], "2018-01-02T15:04:05Z")
+	expect(t, event["level"], "error")
+	expect(t, event["logger"], "orders")
+	expect(t, event["environment"], "3")
+	expect(t, event["release"], "1.2.3")
+	expect(t, event["server_name"], "web-1")
+
+	wantTags := map[string]interface{}{"tenant": "acme", "items": "3", "error": "out of stock"}
+	if !reflect.DeepEqual(event["tags"], wantTags) {
+		t.Errorf("tags = %v, want %v", event["tags"], wantTags)
+	}
+	if !reflect.DeepEqual(event["extra"], map[string]interface{}{"cart": map[string]interface{}{"id": 7.0}}) {
+		t.Errorf("unexpected extra %v", event["extra"])
+	}
+
+	wantFingerprint := []interface{}{"orders", "could not create order", "github.com/acme/shop/orders.(*Service).Create"}
+	if !reflect.DeepEqual(event["fingerprint"], wantFingerprint) {
+		t.Errorf("fingerprint = %v, want %v", event["fingerprint"], wantFingerprint)
+	}
+
+	exception := event["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})
+	expect(t, exception["type"], "*errors.errorString")
+	expect(t, exception["value"], "out of stock")
+	frames := exception["stacktrace"].(map[string]interface{})["frames"].([]interface{})
+	expect(t, len(frames), 4)
+	expect(t, frames[0].(map[string]interface{})["function"], "main")
+	expect(t, frames[3].(map[string]interface{})["module"], "github.com/dailymuse/gzap")
+}
+
+func TestSentryCore_WithoutError(t *testing.T) {
+	tests := []struct {
+		name     string
+		logger   string
+		wantType string
+	}{
+		{"the root logger", "", "Error"},
+		{"a named logger", "orders", "orders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSentryServer()
+			defer server.Close()
+
+			restore := setEnv(map[string]string{"GZAP_SINKS": "sentry+" + server.opts().dsn})
+			defer restore()
+
+			cfg, err := NewEnvConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger, handle, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logger.Named(tt.logger).Error("could not create order")
+			if err := handle.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			expect(t, len(server.events), 1)
+			exception := server.events[0]["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})
+			expect(t, exception["type"], tt.wantType)
+			expect(t, exception["value"], "could not create order")
+
+			// The stack trace is recorded for Sentry without any GELF sink.
+			stacktrace, ok := exception["stacktrace"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("expected a stack trace in %v", exception)
+			}
+			frames := stacktrace["frames"].([]interface{})
+			function := frames[len(frames)-1].(map[string]interface{})["function"]
+			expect(t, strings.HasPrefix(function.(string), "TestSentryCore_WithoutError"), true)
+		})
+	}
+}
+
+func TestParseSentryFrames(t *testing.T) {
+	want := []sentryFrame{
+		{Function: "main", Module: "runtime", AbsPath: "/usr/local/go/src/runtime/proc.go", Filename: "proc.go", Lineno: 250},
+		{Function: "main", Module: "main", AbsPath: "/go/src/github.com/acme/shop/main.go", Filename: "main.go", Lineno: 12, InApp: true},
+		{Function: "(*Service).Create", Module: "github.com/acme/shop/orders", AbsPath: "/go/src/github.com/acme/shop/orders/service.go", Filename: "service.go", Lineno: 87, InApp: true},
+		{Function: "Error", Module: "github.com/dailymuse/gzap", AbsPath: "/go/src/github.com/dailymuse/gzap/global.go", Filename: "global.go", Lineno: 42},
+	}
+	if got := parseSentryFrames(sentryTestStack); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSentryFrames() = %+v, want %+v", got, want)
+	}
+
+	expect(t, len(parseSentryFrames("")), 0)
+}
+
+func TestSentryClient_RateLimit(t *testing.T) {
+	server := newSentryServer()
+	defer server.Close()
+
+	opts := server.opts()
+	opts.burst = 2
+	client := newSentryClient(opts)
+	defer client.Close()
+
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	core := newSentryCore(client, "", "", "")
+	write := func() {
+		if err := core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Time: now, Message: "failed"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The burst is sent, the next events are dropped until tokens refill.
+	for i := 0; i < 4; i++ {
+		write()
+	}
+	now = now.Add(time.Second)
+	write()
+	write()
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.events), 3)
+}
+
+func TestSentryClient_Backoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		respond   func(w http.ResponseWriter)
+		wantSent  []int
+		wantError bool
+	}{
+		{
+			"a 429 with Retry-After",
+			func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			[]int{0, 0, 1},
+			true,
+		},
+		{
+			"a 429 without Retry-After",
+			func(w http.ResponseWriter) { w.WriteHeader(http.StatusTooManyRequests) },
+			[]int{0, 0, 0},
+			true,
+		},
+		{
+			"a rate limit of errors",
+			func(w http.ResponseWriter) {
+				w.Header().Set("X-Sentry-Rate-Limits", "30:error;transaction:organization, 5::key")
+			},
+			[]int{0, 0, 1},
+			false,
+		},
+		{
+			"a rate limit of other categories",
+			func(w http.ResponseWriter) {
+				w.Header().Set("X-Sentry-Rate-Limits", "60:transaction:organization")
+			},
+			[]int{1, 2, 3},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSentryServer(tt.respond)
+			defer server.Close()
+
+			client := newSentryClient(server.opts())
+			defer client.Close()
+
+			start := time.Now()
+			now := start
+			client.now = func() time.Time { return now }
+
+			core := newSentryCore(client, "", "", "")
+			core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Time: now, Message: "first"}, nil)
+			if err := core.Sync(); (err != nil) != tt.wantError {
+				t.Fatalf("Sync() error = %v, wantError %v", err, tt.wantError)
+			}
+
+			// Events are dropped while Sentry asked to back off.
+			for i, elapsed := range []time.Duration{time.Second, 25 * time.Second, 31 * time.Second} {
+				now = start.Add(elapsed)
+				core.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Time: now, Message: "next"}, nil)
+				if err := core.Sync(); err != nil {
+					t.Fatal(err)
+				}
+				expect(t, len(server.events), tt.wantSent[i])
+			}
+		})
+	}
+}
@@ -0,0 +1,582 @@
+package gzap
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	graylog "github.com/Devatoria/go-graylog"
+	"go.uber.org/zap/zapcore"
+)
+
+// sinkLevelParam is the query parameter setting the minimum level of any
+// sink.
+const sinkLevelParam = "level"
+
+// SinkFactory builds the core of a sink from its URL in GZAP_SINKS, and
+// returns it along with a func closing it, which may be nil.
+//
+// The level query parameter is handled by gzap, and removed from the URL
+// given to the factory. Any other parameter belongs to the sink.
+type SinkFactory func(u *url.URL) (zapcore.Core, func() error, error)
+
+// sinkOpener opens a sink whose URL has been validated, see sinkScheme.
+type sinkOpener func(cfg Config) (zapcore.Core, func() error, error)
+
+// sinkScheme validates the URL of a sink when the configuration is read, and
+// returns the func opening it once the logger is built.
+type sinkScheme func(u *url.URL) (sinkOpener, error)
+
+var (
+	sinkSchemesMu sync.RWMutex
+	sinkSchemes   = map[string]sinkScheme{
+		"stdout":              parseStreamSink,
+		"stderr":              parseStreamSink,
+		"file":                parseFileSink,
+		"syslog+udp":          parseSyslogSink,
+		"syslog+tcp":          parseSyslogSink,
+		"syslog+unix":         parseSyslogSink,
+		"syslog+unixgram":     parseSyslogSink,
+		"gelf+udp":            parseGelfSink,
+		"gelf+tls":            parseGelfSink,
+		"fluent+tcp":          parseFluentSink,
+		"fluent+unix":         parseFluentSink,
+		"loki+http":           parseLokiSink,
+		"loki+https":          parseLokiSink,
+		"splunk+http":         parseSplunkSink,
+		"splunk+https":        parseSplunkSink,
+		"opensearch+http":     parseOpenSearchSink,
+		"opensearch+https":    parseOpenSearchSink,
+		"elasticsearch+http":  parseOpenSearchSink,
+		"elasticsearch+https": parseOpenSearchSink,
+		"otlp+http":           parseOTLPSink,
+		"otlp+https":          parseOTLPSink,
+		"sentry+http":         parseSentrySink,
+		"sentry+https":        parseSentrySink,
+	}
+)
+
+// RegisterSink registers a factory building the sinks of a URL scheme, so
+// that they can be listed in GZAP_SINKS. The scheme is case insensitive, and
+// must not be registered already:
+//
+//	func init() {
+//		gzap.RegisterSink("kafka", func(u *url.URL) (zapcore.Core, func() error, error) {
+//			producer, err := newProducer(u.Host, strings.TrimPrefix(u.Path, "/"))
+//			if err != nil {
+//				return nil, nil, err
+//			}
+//			return zapcore.NewCore(encoder, producer, zapcore.DebugLevel), producer.Close, nil
+//		})
+//	}
+//
+// Sinks are validated along with the rest of the configuration, so schemes
+// must be registered before calling NewEnvConfig or InitLogger. The global
+// Logger built when gzap is initialized skips the sinks whose scheme is not
+// registered yet, since the packages registering them are initialized later:
+// call InitLogger once they are to open them.
+func RegisterSink(scheme string, factory SinkFactory) error {
+	scheme = strings.ToLower(scheme)
+	if !isValidScheme(scheme) {
+		return fmt.Errorf("gzap: invalid sink scheme %q", scheme)
+	}
+	if factory == nil {
+		return fmt.Errorf("gzap: no factory for sink scheme %q", scheme)
+	}
+
+	sinkSchemesMu.Lock()
+	defer sinkSchemesMu.Unlock()
+
+	if _, ok := sinkSchemes[scheme]; ok {
+		return fmt.Errorf("gzap: sink scheme %q already registered", scheme)
+	}
+
+	sinkSchemes[scheme] = func(u *url.URL) (sinkOpener, error) {
+		return func(Config) (zapcore.Core, func() error, error) {
+			return factory(u)
+		}, nil
+	}
+
+	return nil
+}
+
+// isValidScheme reports whether scheme is a URL scheme, see RFC 3986.
+func isValidScheme(scheme string) bool {
+	for i := 0; i < len(scheme); i++ {
+		c := scheme[i]
+		switch {
+		case 'a' <= c && c <= 'z':
+		case '0' <= c && c <= '9' || c == '+' || c == '-' || c == '.':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return scheme != ""
+}
+
+// sinkConfig is a sink listed in GZAP_SINKS.
+type sinkConfig struct {
+	// url is the URL of the sink, without its password, if any.
+	url    string
+	scheme string
+	level  zapcore.Level
+	open   sinkOpener
+}
+
+// parseSink parses and validates the URL of a sink. Schemes without any
+// host or path, such as stdout, may be given without "://":
+// "stdout?format=json".
+func parseSink(text string) (sinkConfig, error) {
+	u, err := url.Parse(text)
+	if err != nil {
+		// The error quotes the URL, password included.
+		return sinkConfig{}, fmt.Errorf("invalid sink URL: %v", err.(*url.Error).Err)
+	}
+
+	if u.Scheme == "" && u.Host == "" && !strings.Contains(u.Path, "/") {
+		u.Scheme, u.Path = strings.ToLower(u.Path), ""
+	}
+
+	sink := sinkConfig{url: u.Redacted(), scheme: u.Scheme, level: zapcore.DebugLevel}
+
+	sinkSchemesMu.RLock()
+	scheme, ok := sinkSchemes[u.Scheme]
+	sinkSchemesMu.RUnlock()
+	if !ok {
+		return sinkConfig{}, unknownSchemeError{url: sink.url, scheme: u.Scheme}
+	}
+
+	query := u.Query()
+	if text := query.Get(sinkLevelParam); text != "" {
+		if err := sink.level.UnmarshalText([]byte(text)); err != nil {
+			return sinkConfig{}, fmt.Errorf("sink %s: %v", sink.url, err)
+		}
+	}
+	query.Del(sinkLevelParam)
+	u.RawQuery = query.Encode()
+
+	sink.open, err = scheme(u)
+	if err != nil {
+		return sinkConfig{}, fmt.Errorf("sink %s: %v", sink.url, err)
+	}
+
+	return sink, nil
+}
+
+// redactSinkURLs removes the passwords from a comma separated list of sink
+// URLs, e.g. the token of a Splunk HEC, so that GZAP_SINKS can be reported.
+// URLs that can't be parsed are hidden entirely.
+func redactSinkURLs(value string) string {
+	list := parseList(value)
+	for i, text := range list {
+		u, err := url.Parse(text)
+		if err != nil {
+			list[i] = "******"
+			continue
+		}
+		list[i] = u.Redacted()
+	}
+
+	return strings.Join(list, ",")
+}
+
+// unknownSchemeError reports a sink whose scheme is not registered.
+type unknownSchemeError struct {
+	url    string
+	scheme string
+}
+
+func (e unknownSchemeError) Error() string {
+	return fmt.Sprintf("sink %s: unknown scheme %q", e.url, e.scheme)
+}
+
+// isGelfSink reports whether a sink ships to Graylog.
+func isGelfSink(sink sinkConfig) bool {
+	return strings.HasPrefix(sink.scheme, "gelf+")
+}
+
+// sinkNeedsStacktrace reports whether a sink expects the stack trace of
+// errors: Graylog shows it along with the message, and Sentry builds the
+// frames of its events from it.
+func sinkNeedsStacktrace(sink sinkConfig) bool {
+	return isGelfSink(sink) || strings.HasPrefix(sink.scheme, "sentry+")
+}
+
+// openSinks opens every sink, and returns a tee of their cores along with a
+// func closing them. GELF sinks are skipped when disableGraylog is set.
+func openSinks(cfg Config, sinks []sinkConfig, levels *levelRegistry, disableGraylog bool) (zapcore.Core, func() error, error) {
+	rules := newRedactRules(cfg.getRedactRules())
+
+	cores := []zapcore.Core{}
+	closers := []func() error{}
+	for _, sink := range sinks {
+		if disableGraylog && isGelfSink(sink) {
+			continue
+		}
+
+		core, closer, err := sink.open(cfg)
+		if err != nil {
+			closeAll(closers...)()
+			return nil, nil, fmt.Errorf("sink %s: %v", sink.url, err)
+		}
+
+		core = newSinkLevelCore(newRedactCore(core, rules), sink.level)
+		if isGelfSink(sink) {
+			core = newGraylogLevelCore(core, levels)
+		}
+
+		cores = append(cores, core)
+		closers = append(closers, closer)
+	}
+
+	return zapcore.NewTee(cores...), closeAll(closers...), nil
+}
+
+// sinkLevelCore only passes down the entries at or above the level of a
+// sink. Entries are filtered by the logger wide levels first, see
+// levelFilterCore.
+type sinkLevelCore struct {
+	zapcore.Core
+	level zapcore.Level
+}
+
+func newSinkLevelCore(core zapcore.Core, level zapcore.Level) zapcore.Core {
+	if level <= zapcore.DebugLevel {
+		return core
+	}
+
+	return sinkLevelCore{Core: core, level: level}
+}
+
+func (c sinkLevelCore) Enabled(lvl zapcore.Level) bool {
+	return c.level.Enabled(lvl) && c.Core.Enabled(lvl)
+}
+
+func (c sinkLevelCore) With(fields []zapcore.Field) zapcore.Core {
+	return sinkLevelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c sinkLevelCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return checkedEntry
+	}
+
+	return c.Core.Check(entry, checkedEntry)
+}
+
+// checkSinkParams returns an error naming the first query parameter that is
+// not allowed, so that misspelled options are not silently ignored.
+func checkSinkParams(query url.Values, allowed ...string) error {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		known := false
+		for _, param := range allowed {
+			known = known || name == param
+		}
+		if !known {
+			return fmt.Errorf("unknown option %q", name)
+		}
+	}
+
+	return nil
+}
+
+// parseSinkBool parses an optional boolean query parameter.
+func parseSinkBool(query url.Values, name string, value *bool) error {
+	text := query.Get(name)
+	if text == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(text)
+	if err != nil {
+		return fmt.Errorf("%s: could not parse %q as a boolean", name, text)
+	}
+	*value = b
+
+	return nil
+}
+
+// parseSinkDuration parses an optional, positive duration query parameter.
+func parseSinkDuration(query url.Values, name string, value *time.Duration) error {
+	text := query.Get(name)
+	if text == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(text)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("%s: could not parse %q as a duration, e.g. \"5s\"", name, text)
+	}
+	*value = d
+
+	return nil
+}
+
+// parseStreamSink parses "stdout" and "stderr", which write every entry to
+// the stream with the console encoder. Their format and color default to the
+// ones of the console, and are set by the format and color options.
+func parseStreamSink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+	if err := checkSinkParams(query, "format", "color"); err != nil {
+		return nil, err
+	}
+
+	format := query.Get("format")
+	if format != "" {
+		if err := validateFormat(format); err != nil {
+			return nil, err
+		}
+	}
+
+	var color *bool
+	if query.Get("color") != "" {
+		color = new(bool)
+		if err := parseSinkBool(query, "color", color); err != nil {
+			return nil, err
+		}
+	}
+
+	scheme := u.Scheme
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		opts := cfg.getConsoleOptions()
+		if format != "" {
+			opts.format = format
+		}
+
+		colored := cfg.useColoredConsolelogs()
+		if color != nil {
+			colored = *color
+		}
+
+		stream := os.Stdout
+		if scheme == "stderr" {
+			stream = os.Stderr
+		}
+
+		return zapcore.NewCore(newConsoleEncoder(opts, colored), zapcore.Lock(stream), zapcore.DebugLevel), nil, nil
+	}, nil
+}
+
+// parseFileSink parses "file:///var/log/app.log", with the rotate, max_age,
+// backups, compress, mode and format options of the rotating file. Relative
+// paths are given without the leading slash, "file://logs/app.log".
+func parseFileSink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+	if err := checkSinkParams(query, "rotate", "max_age", "backups", "compress", "mode", "format"); err != nil {
+		return nil, err
+	}
+
+	opts := fileOptions{
+		path:       u.Path,
+		maxSize:    defaultFileMaxSize,
+		maxBackups: defaultFileMaxBackups,
+		mode:       defaultFileMode,
+		format:     consoleFormatJSON,
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		opts.path = u.Host + u.Path
+	}
+	if opts.path == "" {
+		return nil, fmt.Errorf("no file path")
+	}
+
+	if text := query.Get("rotate"); text != "" {
+		size, err := parseSize(text)
+		if err != nil {
+			return nil, fmt.Errorf("rotate: %v", err)
+		}
+		opts.maxSize = size
+	}
+
+	if text := query.Get("max_age"); text != "" {
+		age, err := parseMaxAge(text)
+		if err != nil {
+			return nil, fmt.Errorf("max_age: %v", err)
+		}
+		opts.maxAge = age
+	}
+
+	if text := query.Get("backups"); text != "" {
+		backups, err := parseMaxBackups(text)
+		if err != nil {
+			return nil, fmt.Errorf("backups: %v", err)
+		}
+		opts.maxBackups = backups
+	}
+
+	if err := parseSinkBool(query, "compress", &opts.compress); err != nil {
+		return nil, err
+	}
+
+	if text := query.Get("mode"); text != "" {
+		mode, err := parseFileMode(text)
+		if err != nil {
+			return nil, fmt.Errorf("mode: %v", err)
+		}
+		opts.mode = mode
+	}
+
+	if format := query.Get("format"); format != "" {
+		if err := validateFormat(format); err != nil {
+			return nil, err
+		}
+		opts.format = format
+	}
+
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		return openFileCore(cfg, opts)
+	}, nil
+}
+
+// parseSyslogSink parses "syslog+udp://host:514", "syslog+tcp://host:601",
+// "syslog+unix:///dev/log" or "syslog+unixgram:///dev/log", with the
+// facility and sd_id options.
+func parseSyslogSink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+	if err := checkSinkParams(query, "facility", "sd_id"); err != nil {
+		return nil, err
+	}
+
+	address := url.URL{Scheme: strings.TrimPrefix(u.Scheme, "syslog+"), Host: u.Host, Path: u.Path}
+	network, addr, err := parseSyslogAddress(address.String())
+	if err != nil {
+		return nil, err
+	}
+
+	opts := syslogOptions{
+		network:  network,
+		address:  addr,
+		facility: syslogFacilities["user"],
+		sdID:     defaultSyslogSDID,
+	}
+
+	if name := query.Get("facility"); name != "" {
+		if opts.facility, err = parseSyslogFacility(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if sdID := query.Get("sd_id"); sdID != "" {
+		if err := validateSyslogSDID(sdID); err != nil {
+			return nil, err
+		}
+		opts.sdID = sdID
+	}
+
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		writer := newSyslogWriter(opts.network, opts.address)
+		return newSyslogCore(opts, cfg.getGraylogAppName(), writer), writer.Close, nil
+	}, nil
+}
+
+// parseGelfSink parses "gelf+udp://graylog:12201" or
+// "gelf+tls://graylog:12201", with the timeout and skip_verify options of
+// TLS. The app name and environment are the ones of GRAYLOG_APP_NAME and
+// GRAYLOG_ENV.
+func parseGelfSink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+
+	sinkCfg := gelfSinkConfig{
+		transport: graylog.TCP,
+		host:      u.Hostname(),
+		port:      defaultGraylogPort,
+		timeout:   defaultGraylogTLSTimeout,
+	}
+	if u.Scheme == "gelf+udp" {
+		sinkCfg.transport = graylog.UDP
+		if err := checkSinkParams(query); err != nil {
+			return nil, err
+		}
+	} else if err := checkSinkParams(query, "timeout", "skip_verify"); err != nil {
+		return nil, err
+	}
+
+	if sinkCfg.host == "" {
+		return nil, fmt.Errorf("no host")
+	}
+
+	if text := u.Port(); text != "" {
+		port, err := strconv.ParseUint(text, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("could not properly parse Graylog port: %s", text)
+		}
+		sinkCfg.port = uint(port)
+	}
+
+	if err := parseSinkDuration(query, "timeout", &sinkCfg.timeout); err != nil {
+		return nil, err
+	}
+
+	if err := parseSinkBool(query, "skip_verify", &sinkCfg.skipVerify); err != nil {
+		return nil, err
+	}
+
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		sinkCfg := sinkCfg
+		sinkCfg.Config = cfg
+
+		graylog, err := NewGraylog(sinkCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Levels are controlled by the logger wide filter, see LevelHandler.
+		gelfCore := NewGelfCore(sinkCfg, graylog)
+		gelfCore.level = zapcore.DebugLevel
+
+		core := gelfCore.With([]zapcore.Field{String("env", cfg.getGraylogLogEnvName())})
+
+		return core, graylog.Close, nil
+	}, nil
+}
+
+// gelfSinkConfig overrides the Graylog endpoint of a Config with the one of a
+// GELF sink, so that NewGraylog and the retries of GelfCore connect to it.
+type gelfSinkConfig struct {
+	Config
+
+	transport  graylog.Transport
+	host       string
+	port       uint
+	timeout    time.Duration
+	skipVerify bool
+}
+
+func (c gelfSinkConfig) getGraylogHandlerType() graylog.Transport {
+	return c.transport
+}
+
+func (c gelfSinkConfig) getGraylogHost() string {
+	return c.host
+}
+
+func (c gelfSinkConfig) getGraylogPort() uint {
+	return c.port
+}
+
+func (c gelfSinkConfig) getGraylogTLSTimeout() time.Duration {
+	return c.timeout
+}
+
+func (c gelfSinkConfig) getGraylogSkipInsecureSkipVerify() bool {
+	return c.skipVerify
+}
+
+func (c gelfSinkConfig) useTLS() bool {
+	return c.transport == graylog.TCP
+}
@@ -0,0 +1,200 @@
+//go:build go1.21
+// +build go1.21
+
+package gzap
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SlogHandler returns an slog.Handler writing to the cores of the global
+// logger, so that slog and zap entries are shipped and formatted alike:
+//
+//	slog.SetDefault(slog.New(gzap.SlogHandler()))
+//
+// The handler follows InitLogger and configuration reloads. A logger
+// installed later by ReplaceGlobals is not picked up.
+func SlogHandler() slog.Handler {
+	return NewSlogHandler(L().Core())
+}
+
+// NewSlogHandler returns an slog.Handler writing to core, e.g. the core of a
+// logger built by New.
+//
+// Groups are flattened into dotted keys, "group.key", which Graylog and
+// Datadog both index as is. slog levels are mapped onto the closest zap level
+// at or below them, Error and above being logged at the Error level, with the
+// stack trace of the caller as zap.AddStacktrace would add it.
+func NewSlogHandler(core zapcore.Core) slog.Handler {
+	return &slogHandler{core: core}
+}
+
+type slogHandler struct {
+	core zapcore.Core
+
+	// fields are added by WithAttrs. They are written along with the fields
+	// of every record rather than through core.With, since GelfCore only
+	// keeps the string values of context fields.
+	fields []zapcore.Field
+
+	// prefix is the dotted path of the groups opened by WithGroup.
+	prefix string
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.core.Enabled(slogToZapLevel(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	entry := zapcore.Entry{
+		Level:   slogToZapLevel(record.Level),
+		Time:    record.Time,
+		Message: record.Message,
+	}
+
+	if record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		entry.Caller = zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true)
+	}
+
+	checked := h.core.Check(entry, nil)
+	if checked == nil {
+		return nil
+	}
+	if entry.Level >= zapcore.ErrorLevel {
+		checked.Entry.Stack = slogStacktrace(record.PC)
+	}
+
+	fields := make([]zapcore.Field, 0, len(h.fields)+record.NumAttrs())
+	fields = append(fields, h.fields...)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendSlogAttr(fields, h.prefix, attr)
+		return true
+	})
+
+	checked.Write(fields...)
+
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	fields := make([]zapcore.Field, 0, len(h.fields)+len(attrs))
+	fields = append(fields, h.fields...)
+	for _, attr := range attrs {
+		fields = appendSlogAttr(fields, h.prefix, attr)
+	}
+
+	return &slogHandler{core: h.core, fields: fields, prefix: h.prefix}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &slogHandler{core: h.core, fields: h.fields, prefix: h.prefix + name + "."}
+}
+
+// slogStacktrace formats the stack of the goroutine calling Handle as zap
+// does, starting at the caller of the slog.Logger, pc, or after the frames of
+// log/slog if pc is not on the stack, e.g. when the record was built
+// elsewhere.
+func slogStacktrace(pc uintptr) string {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(3, pcs)]
+
+	found := false
+	for i := range pcs {
+		if pcs[i] == pc {
+			pcs, found = pcs[i:], true
+			break
+		}
+	}
+
+	var lines []string
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if !found && strings.HasPrefix(frame.Function, "log/slog.") {
+			lines = lines[:0]
+		} else {
+			lines = append(lines, frame.Function+"\n\t"+frame.File+":"+strconv.Itoa(frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// slogToZapLevel maps an slog level onto the closest zap level at or below
+// it. Levels above Error are capped, as zap's Panic and Fatal levels would
+// end the process.
+func slogToZapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return zapcore.DebugLevel
+	case level < slog.LevelWarn:
+		return zapcore.InfoLevel
+	case level < slog.LevelError:
+		return zapcore.WarnLevel
+	}
+
+	return zapcore.ErrorLevel
+}
+
+// appendSlogAttr converts attr to fields, flattening groups into dotted keys.
+func appendSlogAttr(fields []zapcore.Field, prefix string, attr slog.Attr) []zapcore.Field {
+	value := attr.Value.Resolve()
+	if attr.Key == "" && value.Kind() != slog.KindGroup {
+		// Empty attributes are ignored, as with the standard handlers.
+		return fields
+	}
+
+	key := prefix + attr.Key
+
+	switch value.Kind() {
+	case slog.KindGroup:
+		// Groups without a key are inlined.
+		if attr.Key != "" {
+			prefix = key + "."
+		}
+		for _, member := range value.Group() {
+			fields = appendSlogAttr(fields, prefix, member)
+		}
+		return fields
+
+	case slog.KindString:
+		return append(fields, zap.String(key, value.String()))
+	case slog.KindInt64:
+		return append(fields, zap.Int64(key, value.Int64()))
+	case slog.KindUint64:
+		return append(fields, zap.Uint64(key, value.Uint64()))
+	case slog.KindFloat64:
+		return append(fields, zap.Float64(key, value.Float64()))
+	case slog.KindBool:
+		return append(fields, zap.Bool(key, value.Bool()))
+	case slog.KindDuration:
+		return append(fields, zap.Duration(key, value.Duration()))
+	case slog.KindTime:
+		return append(fields, zap.Time(key, value.Time()))
+	}
+
+	if err, ok := value.Any().(error); ok {
+		return append(fields, zap.NamedError(key, err))
+	}
+
+	return append(fields, zap.Any(key, value.Any()))
+}
@@ -0,0 +1,110 @@
+//go:build go1.21
+// +build go1.21
+
+package gzap
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSlogHandler(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := slog.New(NewSlogHandler(core))
+
+	logger.Debug("filtered out")
+	logger.
+		With("request_id", "abc").
+		WithGroup("db").
+		With("table", "users").
+		WithGroup("query").
+		Info("query done",
+			slog.Int("rows", 3),
+			slog.Duration("took", time.Millisecond),
+			slog.Group("conn", slog.String("host", "db1")),
+			slog.Group("", slog.Bool("inlined", true)),
+			slog.Any("err", errors.New("boom")),
+			slog.Attr{},
+		)
+	logger.WithGroup("empty").Warn("no attrs")
+
+	all := logs.AllUntimed()
+	expect(t, len(all), 2)
+
+	entry := all[0]
+	expect(t, entry.Level, zapcore.InfoLevel)
+	expect(t, entry.Message, "query done")
+	if !strings.HasSuffix(entry.Caller.File, "slog_test.go") {
+		t.Errorf("NewSlogHandler() expected the caller to be slog_test.go; got %q", entry.Caller.File)
+	}
+
+	fields := entry.ContextMap()
+	expect(t, fields["request_id"], "abc")
+	expect(t, fields["db.table"], "users")
+	expect(t, fields["db.query.rows"], int64(3))
+	expect(t, fields["db.query.took"], time.Millisecond)
+	expect(t, fields["db.query.conn.host"], "db1")
+	expect(t, fields["db.query.inlined"], true)
+	expect(t, fields["db.query.err"], "boom")
+	expect(t, len(fields), 7)
+
+	expect(t, all[1].Level, zapcore.WarnLevel)
+	expect(t, len(all[1].ContextMap()), 0)
+}
+
+func TestSlogToZapLevel(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  zapcore.Level
+	}{
+		{slog.LevelDebug - 4, zapcore.DebugLevel},
+		{slog.LevelDebug, zapcore.DebugLevel},
+		{slog.LevelInfo, zapcore.InfoLevel},
+		{slog.LevelInfo + 2, zapcore.InfoLevel},
+		{slog.LevelWarn, zapcore.WarnLevel},
+		{slog.LevelError, zapcore.ErrorLevel},
+		{slog.LevelError + 4, zapcore.ErrorLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			expect(t, slogToZapLevel(tt.level), tt.want)
+		})
+	}
+}
+
+func TestSlogHandler_Stacktrace(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := slog.New(NewSlogHandler(core))
+
+	logger.Info("no stack")
+	logger.Error("failed")
+
+	all := logs.AllUntimed()
+	expect(t, len(all), 2)
+	expect(t, all[0].Stack, "")
+
+	// As with zap.AddStacktrace, the stack starts at the caller.
+	stack := all[1].Stack
+	if !strings.HasPrefix(stack, "github.com/dailymuse/gzap.TestSlogHandler_Stacktrace\n\t") {
+		t.Errorf("expected the stack trace to start at the test, got %q", stack)
+	}
+	if strings.Contains(stack, "log/slog.") {
+		t.Errorf("expected no slog frames in %q", stack)
+	}
+
+	// Records handled directly start at the caller of Handle.
+	record := slog.NewRecord(time.Now(), slog.LevelError, "handled", 0)
+	if err := NewSlogHandler(core).Handle(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	if stack := logs.AllUntimed()[2].Stack; !strings.HasPrefix(stack, "github.com/dailymuse/gzap.TestSlogHandler_Stacktrace\n\t") {
+		t.Errorf("expected the stack trace to start at the test, got %q", stack)
+	}
+}
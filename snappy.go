@@ -0,0 +1,98 @@
+package gzap
+
+import (
+	"encoding/binary"
+)
+
+// Snappy block compression, see
+// https://github.com/google/snappy/blob/master/format_description.txt.
+// Only the encoder is implemented: a greedy matcher over a hash table of
+// 4-byte sequences, which is all the push APIs need.
+
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy2   = 0x02
+
+	// snappyMaxOffset is the farthest back a 2-byte offset copy can reach.
+	snappyMaxOffset = 1<<16 - 1
+
+	snappyHashBits = 14
+)
+
+// snappyEncode compresses src as a single snappy block, preceded by its
+// uncompressed length.
+func snappyEncode(src []byte) []byte {
+	dst := appendProtoVarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+	if len(src) < 4 {
+		return appendSnappyLiteral(dst, src)
+	}
+
+	var table [1 << snappyHashBits]int32
+	for i := range table {
+		table[i] = -1
+	}
+
+	literal := 0
+	for i := 0; i+4 <= len(src); {
+		h := snappyHash(binary.LittleEndian.Uint32(src[i:]))
+		candidate := int(table[h])
+		table[h] = int32(i)
+
+		if candidate < 0 || i-candidate > snappyMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+
+		length := 4
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+
+		dst = appendSnappyLiteral(dst, src[literal:i])
+		dst = appendSnappyCopy(dst, i-candidate, length)
+		i += length
+		literal = i
+	}
+
+	return appendSnappyLiteral(dst, src[literal:])
+}
+
+func snappyHash(v uint32) uint32 {
+	return (v * 0x1e35a7bd) >> (32 - snappyHashBits)
+}
+
+func appendSnappyLiteral(dst []byte, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+
+	switch n := uint32(len(lit) - 1); {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+
+	return append(dst, lit...)
+}
+
+// appendSnappyCopy appends copies of up to 64 bytes each, with 2-byte
+// offsets.
+func appendSnappyCopy(dst []byte, offset int, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > 64 {
+			n = 64
+		}
+		dst = append(dst, byte(n-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= n
+	}
+
+	return dst
+}
@@ -0,0 +1,105 @@
+package gzap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// snappyDecode decompresses a snappy block, to check the encoder output.
+func snappyDecode(src []byte) ([]byte, error) {
+	n, read := binary.Uvarint(src)
+	if read <= 0 {
+		return nil, errors.New("invalid length preamble")
+	}
+	src = src[read:]
+
+	dst := make([]byte, 0, n)
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 0x03 {
+		case snappyTagLiteral:
+			length := int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errors.New("truncated literal length")
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+			if len(src) < length {
+				return nil, errors.New("truncated literal")
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+		case snappyTagCopy2:
+			if len(src) < 3 {
+				return nil, errors.New("truncated copy")
+			}
+			length := int(tag>>2) + 1
+			offset := int(binary.LittleEndian.Uint16(src[1:]))
+			if offset == 0 || offset > len(dst) {
+				return nil, errors.New("invalid copy offset")
+			}
+			for i := 0; i < length; i++ {
+				dst = append(dst, dst[len(dst)-offset])
+			}
+			src = src[3:]
+		default:
+			return nil, errors.New("unexpected tag")
+		}
+	}
+
+	if uint64(len(dst)) != n {
+		return nil, errors.New("length mismatch")
+	}
+
+	return dst, nil
+}
+
+func TestSnappyEncode(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"empty", nil},
+		{"shorter than a match", []byte("abc")},
+		{"a literal", []byte("hello, world")},
+		{"a long literal", random[:300]},
+		{"a repetition", []byte(strings.Repeat("a", 1000))},
+		{"repeated lines", []byte(strings.Repeat(`{"msg":"request served","status":200}`+"\n", 500))},
+		{"random data", random},
+		{"matches beyond the maximum offset", append(append(append([]byte{}, random[:70000]...), random[:1000]...), random[:100]...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := snappyEncode(tt.src)
+			decoded, err := snappyDecode(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, tt.src) {
+				t.Error("decoded data does not match the source")
+			}
+		})
+	}
+}
+
+func TestSnappyEncode_Compresses(t *testing.T) {
+	src := []byte(strings.Repeat(`{"msg":"request served","status":200}`+"\n", 500))
+	if encoded := snappyEncode(src); len(encoded) > len(src)/10 {
+		t.Errorf("expected repeated lines to compress, got %d bytes out of %d", len(encoded), len(src))
+	}
+}
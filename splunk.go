@@ -0,0 +1,464 @@
+package gzap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultSplunkPath          = "/services/collector/event"
+	defaultSplunkSourceType    = "_json"
+	defaultSplunkBatch         = 100
+	defaultSplunkFlushInterval = time.Second
+	defaultSplunkRetries       = 3
+	defaultSplunkAckTimeout    = 30 * time.Second
+
+	// splunkBatchBytes keeps requests below the 1MB default maximum content
+	// length of HEC.
+	splunkBatchBytes = 800 << 10
+)
+
+// Where a Splunk sink puts the fields of an entry.
+const (
+	splunkFieldsEvent   = "event"
+	splunkFieldsIndexed = "indexed"
+)
+
+// splunkOptions configures a Splunk HTTP Event Collector sink.
+type splunkOptions struct {
+	// endpoint is the URL of the event endpoint, without credentials.
+	endpoint string
+	token    string
+
+	host       string
+	source     string
+	sourceType string
+	index      string
+
+	// fields is splunkFieldsEvent to put fields in the event along with the
+	// message, or splunkFieldsIndexed to send them as indexed fields.
+	fields string
+
+	batch         int
+	flushInterval time.Duration
+	gzip          bool
+
+	// ack waits for every batch to be acknowledged by the indexers, polling
+	// every ackInterval for up to ackTimeout.
+	ack         bool
+	ackInterval time.Duration
+	ackTimeout  time.Duration
+
+	timeout    time.Duration
+	skipVerify bool
+	retry      httpRetry
+}
+
+// parseSplunkSink parses "splunk+https://x:<token>@splunk:8088" or
+// "splunk+http://...", the token being the password of the URL, with the
+// host, source, sourcetype, index, fields, batch, flush_interval, gzip, ack,
+// ack_timeout, retries, and the timeout and skip_verify options of TLS. The
+// path defaults to the event endpoint.
+func parseSplunkSink(u *url.URL) (sinkOpener, error) {
+	query := u.Query()
+	allowed := []string{"host", "source", "sourcetype", "index", "fields", "batch", "flush_interval", "gzip", "ack", "ack_timeout", "retries", "timeout"}
+	if u.Scheme == "splunk+https" {
+		allowed = append(allowed, "skip_verify")
+	}
+	if err := checkSinkParams(query, allowed...); err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host")
+	}
+
+	opts := splunkOptions{
+		host:          query.Get("host"),
+		source:        query.Get("source"),
+		sourceType:    query.Get("sourcetype"),
+		index:         query.Get("index"),
+		fields:        splunkFieldsEvent,
+		batch:         defaultSplunkBatch,
+		flushInterval: defaultSplunkFlushInterval,
+		ackInterval:   time.Second,
+		ackTimeout:    defaultSplunkAckTimeout,
+		timeout:       defaultGraylogTLSTimeout,
+		retry:         httpRetry{retries: defaultSplunkRetries, minBackoff: 500 * time.Millisecond, maxBackoff: 30 * time.Second},
+	}
+	if opts.sourceType == "" {
+		opts.sourceType = defaultSplunkSourceType
+	}
+
+	if u.User != nil {
+		opts.token, _ = u.User.Password()
+	}
+	if opts.token == "" {
+		return nil, fmt.Errorf("no token, expected as the password of the URL")
+	}
+
+	endpoint := url.URL{Scheme: strings.TrimPrefix(u.Scheme, "splunk+"), Host: u.Host, Path: u.Path}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = defaultSplunkPath
+	}
+	opts.endpoint = endpoint.String()
+
+	switch fields := query.Get("fields"); fields {
+	case "":
+	case splunkFieldsEvent, splunkFieldsIndexed:
+		opts.fields = fields
+	default:
+		return nil, fmt.Errorf("fields: unknown value %q, expected %q or %q", fields, splunkFieldsEvent, splunkFieldsIndexed)
+	}
+
+	if text := query.Get("batch"); text != "" {
+		batch, err := strconv.Atoi(text)
+		if err != nil || batch < 1 {
+			return nil, fmt.Errorf("batch: could not parse %q as a number of entries", text)
+		}
+		opts.batch = batch
+	}
+
+	if text := query.Get("retries"); text != "" {
+		retries, err := strconv.Atoi(text)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("retries: could not parse %q as a number of retries", text)
+		}
+		opts.retry.retries = retries
+	}
+
+	for name, value := range map[string]*bool{"gzip": &opts.gzip, "ack": &opts.ack, "skip_verify": &opts.skipVerify} {
+		if err := parseSinkBool(query, name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, value := range map[string]*time.Duration{"flush_interval": &opts.flushInterval, "ack_timeout": &opts.ackTimeout, "timeout": &opts.timeout} {
+		if err := parseSinkDuration(query, name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(cfg Config) (zapcore.Core, func() error, error) {
+		opts := opts
+		if opts.host == "" {
+			opts.host, _ = os.Hostname()
+		}
+		if opts.source == "" {
+			opts.source = cfg.getGraylogAppName()
+		}
+
+		client, err := newSplunkClient(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		core := newSplunkCore(opts, client).With([]zapcore.Field{String("env", cfg.getGraylogLogEnvName())})
+
+		return core, client.Close, nil
+	}, nil
+}
+
+// splunkCore writes entries as HEC events. The message, level, logger,
+// caller and stack trace of an entry always go in the event, and its fields
+// either in the event or as indexed fields.
+type splunkCore struct {
+	opts    splunkOptions
+	encoder zapcore.Encoder
+
+	// context holds the fields added with With when they are indexed.
+	context []zapcore.Field
+
+	client *splunkClient
+}
+
+func newSplunkCore(opts splunkOptions, client *splunkClient) *splunkCore {
+	return &splunkCore{
+		opts: opts,
+		encoder: zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+			LevelKey:       "level",
+			NameKey:        "logger",
+			CallerKey:      "caller",
+			MessageKey:     "message",
+			StacktraceKey:  "stacktrace",
+			EncodeLevel:    zapcore.LowercaseLevelEncoder,
+			EncodeTime:     zapcore.ISO8601TimeEncoder,
+			EncodeDuration: zapcore.StringDurationEncoder,
+			EncodeCaller:   zapcore.ShortCallerEncoder,
+		}),
+		client: client,
+	}
+}
+
+// Enabled accepts every level: levels are controlled by the logger wide
+// filter, see LevelHandler.
+func (c *splunkCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *splunkCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	if c.opts.fields == splunkFieldsIndexed {
+		clone.context = append(c.context[:len(c.context):len(c.context)], fields...)
+		return &clone
+	}
+
+	clone.encoder = c.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(clone.encoder)
+	}
+
+	return &clone
+}
+
+func (c *splunkCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checked.AddCore(entry, c)
+}
+
+func (c *splunkCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	var indexed map[string]interface{}
+	if c.opts.fields == splunkFieldsIndexed {
+		indexed = splunkIndexedFields(append(c.context[:len(c.context):len(c.context)], fields...))
+		fields = nil
+	}
+
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	event := struct {
+		Time       json.Number            `json:"time"`
+		Host       string                 `json:"host,omitempty"`
+		Source     string                 `json:"source,omitempty"`
+		SourceType string                 `json:"sourcetype,omitempty"`
+		Index      string                 `json:"index,omitempty"`
+		Event      json.RawMessage        `json:"event"`
+		Fields     map[string]interface{} `json:"fields,omitempty"`
+	}{
+		Time:       formatSplunkTime(entry.Time),
+		Host:       c.opts.host,
+		Source:     c.opts.source,
+		SourceType: c.opts.sourceType,
+		Index:      c.opts.index,
+		Event:      json.RawMessage(bytes.TrimSpace(buf.Bytes())),
+		Fields:     indexed,
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return c.client.add(b, len(b))
+}
+
+func (c *splunkCore) Sync() error {
+	return c.client.sync()
+}
+
+// formatSplunkTime formats the time of an event as epoch seconds, with
+// milliseconds.
+func formatSplunkTime(t time.Time) json.Number {
+	ms := t.UnixNano() / int64(time.Millisecond)
+	return json.Number(fmt.Sprintf("%d.%03d", ms/1000, ms%1000))
+}
+
+// splunkIndexedFields encodes fields as indexed fields, which values are
+// strings or arrays of strings: other values are formatted, objects as JSON.
+func splunkIndexedFields(fields []zapcore.Field) map[string]interface{} {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	indexed := make(map[string]interface{}, len(enc.Fields))
+	for key, value := range enc.Fields {
+		if values, ok := value.([]interface{}); ok {
+			strs := make([]string, len(values))
+			for i, v := range values {
+				strs[i] = formatSplunkField(v)
+			}
+			indexed[key] = strs
+			continue
+		}
+		indexed[key] = formatSplunkField(value)
+	}
+
+	return indexed
+}
+
+func formatSplunkField(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// splunkClient posts batches of events to HEC.
+type splunkClient struct {
+	*batcher
+
+	opts   splunkOptions
+	http   *http.Client
+	ackURL string
+
+	// channel identifies the client to the indexer acknowledgement API.
+	channel string
+}
+
+func newSplunkClient(opts splunkOptions) (*splunkClient, error) {
+	channel, err := newSplunkChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	ackURL, err := url.Parse(opts.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	ackURL.Path = path.Join(path.Dir(ackURL.Path), "ack")
+
+	c := &splunkClient{
+		opts:   opts,
+		ackURL: ackURL.String(),
+		http: &http.Client{
+			Timeout: opts.timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.skipVerify},
+			},
+		},
+		channel: channel,
+	}
+	c.batcher = newBatcher("splunk "+opts.endpoint, opts.batch, splunkBatchBytes, opts.flushInterval, c.post)
+
+	return c, nil
+}
+
+// newSplunkChannel returns a random UUID.
+func newSplunkChannel() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// post sends a batch of events, and waits for it to be acknowledged if acks
+// are enabled.
+func (c *splunkClient) post(items []interface{}) error {
+	var body bytes.Buffer
+	if c.opts.gzip {
+		w := gzip.NewWriter(&body)
+		for _, item := range items {
+			w.Write(item.([]byte))
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	} else {
+		for _, item := range items {
+			body.Write(item.([]byte))
+		}
+	}
+
+	resp, err := c.opts.retry.do(c.http, func() (*http.Request, error) {
+		req, err := c.newRequest(c.opts.endpoint, body.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if c.opts.gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+
+		return req, nil
+	})
+	if err != nil || !c.opts.ack {
+		return err
+	}
+
+	var result struct {
+		AckID *int64 `json:"ackId"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("could not parse the response: %v", err)
+	}
+	if result.AckID == nil {
+		return fmt.Errorf("no ackId in the response, is indexer acknowledgement enabled for the token?")
+	}
+
+	return c.waitAck(*result.AckID)
+}
+
+// waitAck polls the indexer acknowledgement API until the request with ID
+// ackID is indexed.
+func (c *splunkClient) waitAck(ackID int64) error {
+	body, err := json.Marshal(map[string][]int64{"acks": {ackID}})
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.opts.ackTimeout)
+	for {
+		resp, err := c.opts.retry.do(c.http, func() (*http.Request, error) {
+			return c.newRequest(c.ackURL, body)
+		})
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			Acks map[string]bool `json:"acks"`
+		}
+		if err := json.Unmarshal(resp, &result); err != nil {
+			return fmt.Errorf("could not parse the acknowledgement: %v", err)
+		}
+		if result.Acks[strconv.FormatInt(ackID, 10)] {
+			return nil
+		}
+
+		if time.Now().Add(c.opts.ackInterval).After(deadline) {
+			return fmt.Errorf("request %d not acknowledged after %s", ackID, c.opts.ackTimeout)
+		}
+		time.Sleep(c.opts.ackInterval)
+	}
+}
+
+func (c *splunkClient) newRequest(url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Splunk "+c.opts.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gzap")
+	req.Header.Set("X-Splunk-Request-Channel", c.channel)
+
+	return req, nil
+}
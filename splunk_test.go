@@ -0,0 +1,262 @@
+package gzap
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// splunkServer is a stand-in HEC, over TLS, acknowledging requests once
+// polled pendingAcks times.
+type splunkServer struct {
+	*httptest.Server
+
+	ack         bool
+	pendingAcks int
+
+	mu     sync.Mutex
+	events []map[string]interface{}
+	header http.Header
+	polls  int
+}
+
+func newSplunkServer(ack bool, pendingAcks int) *splunkServer {
+	s := &splunkServer{ack: ack, pendingAcks: pendingAcks}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+func (s *splunkServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Splunk token" {
+		http.Error(w, `{"text":"Invalid token","code":4}`, http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path == "/services/collector/ack" {
+		var request struct {
+			Acks []int64 `json:"acks"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		s.polls++
+		acked := s.polls > s.pendingAcks
+		fmt.Fprintf(w, `{"acks":{"%d":%t}}`, request.Acks[0], acked)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+
+	s.header = r.Header
+	decoder := json.NewDecoder(body)
+	for decoder.More() {
+		var event map[string]interface{}
+		if err := decoder.Decode(&event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.events = append(s.events, event)
+	}
+
+	if s.ack {
+		fmt.Fprint(w, `{"text":"Success","code":0,"ackId":7}`)
+		return
+	}
+	fmt.Fprint(w, `{"text":"Success","code":0}`)
+}
+
+func (s *splunkServer) opts() splunkOptions {
+	return splunkOptions{
+		endpoint:      s.URL + defaultSplunkPath,
+		token:         "token",
+		host:          "web-1",
+		source:        "my-app",
+		sourceType:    defaultSplunkSourceType,
+		index:         "audit",
+		fields:        splunkFieldsEvent,
+		batch:         100,
+		flushInterval: time.Hour,
+		ack:           s.ack,
+		ackInterval:   time.Millisecond,
+		ackTimeout:    time.Second,
+		timeout:       time.Second,
+		skipVerify:    true,
+		retry:         httpRetry{retries: 1, minBackoff: time.Millisecond, maxBackoff: time.Millisecond},
+	}
+}
+
+func TestSplunkCore_Event(t *testing.T) {
+	server := newSplunkServer(false, 0)
+	defer server.Close()
+
+	client, err := newSplunkClient(server.opts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	core := newSplunkCore(server.opts(), client).With([]zapcore.Field{zap.String("env", "3")})
+	entry := zapcore.Entry{Level: zapcore.WarnLevel, Time: time.Unix(1514905445, 123456789), LoggerName: "audit", Message: "login failed"}
+	if err := core.Write(entry, []zapcore.Field{zap.String("user", "bob"), zap.Int("attempts", 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(server.events), 1)
+	expect(t, server.header.Get("Content-Type"), "application/json")
+	if server.header.Get("X-Splunk-Request-Channel") == "" {
+		t.Error("expected a request channel")
+	}
+
+	event := server.events[0]
+	expect(t, event["time"], 1514905445.123)
+	expect(t, event["host"], "web-1")
+	expect(t, event["source"], "my-app")
+	expect(t, event["sourcetype"], "_json")
+	expect(t, event["index"], "audit")
+	if _, ok := event["fields"]; ok {
+		t.Error("expected no indexed fields")
+	}
+
+	want := map[string]interface{}{"level": "warn", "logger": "audit", "message": "login failed", "env": "3", "user": "bob", "attempts": 3.0}
+	if !reflect.DeepEqual(event["event"], want) {
+		t.Errorf("event = %v, want %v", event["event"], want)
+	}
+}
+
+func TestSplunkCore_IndexedFields(t *testing.T) {
+	server := newSplunkServer(false, 0)
+	defer server.Close()
+
+	opts := server.opts()
+	opts.fields = splunkFieldsIndexed
+	opts.gzip = true
+	opts.batch = 2
+	client, err := newSplunkClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	logger := zap.New(newSplunkCore(opts, client)).With(zap.String("env", "3"))
+	logger.Info("first", zap.Int("status", 200), zap.Strings("roles", []string{"admin", "ops"}), zap.Any("user", map[string]interface{}{"id": 1}))
+	logger.Info("second")
+	if err := client.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, server.header.Get("Content-Encoding"), "gzip")
+	expect(t, len(server.events), 2)
+
+	want := map[string]interface{}{"env": "3", "status": "200", "roles": []interface{}{"admin", "ops"}, "user": `{"id":1}`}
+	if !reflect.DeepEqual(server.events[0]["fields"], want) {
+		t.Errorf("fields = %v, want %v", server.events[0]["fields"], want)
+	}
+	if !reflect.DeepEqual(server.events[0]["event"], map[string]interface{}{"level": "info", "message": "first"}) {
+		t.Errorf("unexpected event %v", server.events[0]["event"])
+	}
+}
+
+func TestSplunkClient_Ack(t *testing.T) {
+	tests := []struct {
+		name        string
+		pendingAcks int
+		ackTimeout  time.Duration
+		wantErr     bool
+	}{
+		{"an acknowledged request", 2, time.Second, false},
+		{"an unacknowledged request", 1000, 20 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSplunkServer(true, tt.pendingAcks)
+			defer server.Close()
+
+			opts := server.opts()
+			opts.ackTimeout = tt.ackTimeout
+			client, err := newSplunkClient(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			core := newSplunkCore(opts, client)
+			if err := core.Write(zapcore.Entry{Time: time.Now(), Message: "audited"}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			err = core.Sync()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				expect(t, server.polls, 3)
+			}
+		})
+	}
+}
+
+func TestSplunkClient_Errors(t *testing.T) {
+	server := newSplunkServer(false, 0)
+	defer server.Close()
+
+	opts := server.opts()
+	opts.token = "wrong"
+	client, err := newSplunkClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.add([]byte(`{"event":"lost"}`), 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.sync(); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected a 403 error, got %v", err)
+	}
+
+	// Certificates are verified unless skip_verify is set.
+	opts = server.opts()
+	opts.skipVerify = false
+	client, err = newSplunkClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.add([]byte(`{"event":"lost"}`), 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.sync(); err == nil {
+		t.Error("expected a certificate error")
+	}
+}
+
+func TestFormatSplunkTime(t *testing.T) {
+	expect(t, formatSplunkTime(time.Unix(1514905445, 7000000)), json.Number("1514905445.007"))
+	expect(t, formatSplunkTime(time.Unix(0, 0)), json.Number("0.000"))
+}
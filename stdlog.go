@@ -0,0 +1,122 @@
+package gzap
+
+import (
+	"log"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// stdLogName is the logger name of entries written through the standard
+// library's log package.
+const stdLogName = "stdlib"
+
+// stdLogCallerSkip skips stdLogWriter.Write, log.(*Logger).output and
+// log.Printf, so that entries are attributed to the code calling the standard
+// library's logger.
+const stdLogCallerSkip = 3
+
+// stdLogLevels maps the level prefixes commonly found in messages written to
+// the standard library's logger onto zap levels. Fatal and panic prefixes are
+// logged as errors, since the log package already handles them.
+var stdLogLevels = map[string]zapcore.Level{
+	"debug":   zapcore.DebugLevel,
+	"trace":   zapcore.DebugLevel,
+	"info":    zapcore.InfoLevel,
+	"notice":  zapcore.InfoLevel,
+	"warn":    zapcore.WarnLevel,
+	"warning": zapcore.WarnLevel,
+	"err":     zapcore.ErrorLevel,
+	"error":   zapcore.ErrorLevel,
+	"crit":    zapcore.ErrorLevel,
+	"fatal":   zapcore.ErrorLevel,
+	"panic":   zapcore.ErrorLevel,
+}
+
+// RedirectStdLog redirects the output of the standard library's global logger
+// to the global logger, at the info level unless the message starts with a
+// level prefix such as "[WARN]" or "error:". Entries are named "stdlib".
+//
+// The returned func restores the previous output, prefix and flags of the
+// standard library's logger.
+func RedirectStdLog() func() {
+	flags := log.Flags()
+	prefix := log.Prefix()
+	output := log.Writer()
+
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(&stdLogWriter{level: zapcore.InfoLevel})
+
+	return func() {
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+		log.SetOutput(output)
+	}
+}
+
+// NewStdLogAt returns a standard library logger writing to the global logger,
+// at level unless the message starts with a level prefix. Entries are named
+// "stdlib". It is meant for libraries expecting a *log.Logger:
+//
+//	server := &http.Server{
+//		ErrorLog: gzap.NewStdLogAt(zapcore.ErrorLevel),
+//	}
+func NewStdLogAt(level zapcore.Level) *log.Logger {
+	return log.New(&stdLogWriter{level: level}, "", 0)
+}
+
+// stdLogWriter writes every line it receives to the global logger, as it is
+// when the line is written.
+type stdLogWriter struct {
+	level zapcore.Level
+}
+
+func (w *stdLogWriter) Write(p []byte) (int, error) {
+	level, msg := parseStdLogLevel(strings.TrimSuffix(string(p), "\n"), w.level)
+
+	logger := L().Named(stdLogName).WithOptions(zap.AddCallerSkip(stdLogCallerSkip))
+	if checked := logger.Check(level, msg); checked != nil {
+		checked.Write()
+	}
+
+	return len(p), nil
+}
+
+// parseStdLogLevel picks the level of msg from its prefix, "[warn] ...",
+// "warn: ..." or "WARN ...", and strips it. Messages without a known prefix
+// are logged at level.
+func parseStdLogLevel(msg string, level zapcore.Level) (zapcore.Level, string) {
+	trimmed := strings.TrimLeft(msg, " ")
+
+	var word, rest string
+	if strings.HasPrefix(trimmed, "[") {
+		end := strings.IndexByte(trimmed, ']')
+		if end < 0 {
+			return level, msg
+		}
+		word, rest = trimmed[1:end], trimmed[end+1:]
+	} else {
+		end := strings.IndexAny(trimmed, ": ")
+		if end < 0 {
+			return level, msg
+		}
+		word, rest = trimmed[:end], trimmed[end:]
+
+		// A bare word only counts as a prefix when upper case, so that
+		// messages such as "Error connecting to ..." are left untouched.
+		if strings.HasPrefix(rest, ":") {
+			rest = rest[1:]
+		} else if word != strings.ToUpper(word) {
+			return level, msg
+		}
+	}
+
+	parsed, ok := stdLogLevels[strings.ToLower(word)]
+	if !ok {
+		return level, msg
+	}
+
+	return parsed, strings.TrimLeft(rest, " ")
+}
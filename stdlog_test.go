@@ -0,0 +1,93 @@
+package gzap
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedirectStdLog(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	undo := ReplaceGlobals(zap.New(core, zap.AddCaller()))
+	defer undo()
+
+	var previous bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&previous)
+
+	restore := RedirectStdLog()
+	log.Print("[WARN] disk almost full")
+	log.Println("server started")
+	restore()
+
+	log.Print("after restore")
+
+	all := logs.AllUntimed()
+	expect(t, len(all), 2)
+
+	expect(t, all[0].Level, zapcore.WarnLevel)
+	expect(t, all[0].Message, "disk almost full")
+	expect(t, all[0].LoggerName, stdLogName)
+	if !strings.HasSuffix(all[0].Caller.File, "stdlog_test.go") {
+		t.Errorf("RedirectStdLog() expected the caller to be stdlog_test.go; got %q", all[0].Caller.File)
+	}
+
+	expect(t, all[1].Level, zapcore.InfoLevel)
+	expect(t, all[1].Message, "server started")
+
+	if !strings.Contains(previous.String(), "after restore") {
+		t.Errorf("RedirectStdLog() expected restore to bring back the previous output; got %q", previous.String())
+	}
+}
+
+func TestNewStdLogAt(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	undo := ReplaceGlobals(zap.New(core, zap.AddCaller()))
+	defer undo()
+
+	logger := NewStdLogAt(zapcore.ErrorLevel)
+	logger.Printf("http: TLS handshake error from %s: EOF", "10.0.0.1:5000")
+	logger.Print("info: client disconnected")
+
+	all := logs.AllUntimed()
+	expect(t, len(all), 2)
+	expect(t, all[0].Level, zapcore.ErrorLevel)
+	expect(t, all[0].Message, "http: TLS handshake error from 10.0.0.1:5000: EOF")
+	expect(t, all[0].LoggerName, stdLogName)
+	if !strings.HasSuffix(all[0].Caller.File, "stdlog_test.go") {
+		t.Errorf("NewStdLogAt() expected the caller to be stdlog_test.go; got %q", all[0].Caller.File)
+	}
+	expect(t, all[1].Level, zapcore.InfoLevel)
+	expect(t, all[1].Message, "client disconnected")
+}
+
+func TestParseStdLogLevel(t *testing.T) {
+	tests := []struct {
+		msg       string
+		wantLevel zapcore.Level
+		wantMsg   string
+	}{
+		{"[WARN] disk almost full", zapcore.WarnLevel, "disk almost full"},
+		{"[debug]cache miss", zapcore.DebugLevel, "cache miss"},
+		{"error: connection refused", zapcore.ErrorLevel, "connection refused"},
+		{"  WARNING: slow query", zapcore.WarnLevel, "slow query"},
+		{"ERROR connection refused", zapcore.ErrorLevel, "connection refused"},
+		{"FATAL: out of memory", zapcore.ErrorLevel, "out of memory"},
+		{"Error connecting to the database", zapcore.InfoLevel, "Error connecting to the database"},
+		{"[worker-1] started", zapcore.InfoLevel, "[worker-1] started"},
+		{"[unterminated", zapcore.InfoLevel, "[unterminated"},
+		{"started", zapcore.InfoLevel, "started"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			level, msg := parseStdLogLevel(tt.msg, zapcore.InfoLevel)
+			expect(t, level, tt.wantLevel)
+			expect(t, msg, tt.wantMsg)
+		})
+	}
+}
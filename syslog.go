@@ -0,0 +1,404 @@
+package gzap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultSyslogUDPPort = 514
+	defaultSyslogTCPPort = 601
+
+	// defaultSyslogSDID is the SD-ID of the STRUCTURED-DATA element holding the
+	// fields. 32473 is the enterprise number reserved for documentation, see
+	// RFC 5612.
+	defaultSyslogSDID = "fields@32473"
+
+	// syslogTimeout bounds connecting and writing to the syslog server, so
+	// that an unresponsive server can't hold up the queued messages.
+	syslogTimeout = 5 * time.Second
+
+	// syslogBatch and syslogFlushInterval bound how many messages are queued
+	// together, and for how long, before being written.
+	syslogBatch         = 100
+	syslogFlushInterval = 100 * time.Millisecond
+
+	// syslogTimeFormat is the TIMESTAMP of RFC 5424, at its maximum precision.
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogFacilities are the facilities accepted by GZAP_SYSLOG_FACILITY.
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// syslogOptions configures the syslog sink.
+type syslogOptions struct {
+	// network is "udp", "tcp", "unix" or "unixgram". Messages sent over
+	// stream networks are framed by octet counting, see RFC 6587.
+	network  string
+	address  string
+	facility int
+	sdID     string
+}
+
+// parseSyslogAddress parses the URL of a syslog server, "udp://host:514",
+// "tcp://host:601", "unix:///dev/log" or "unixgram:///dev/log".
+func parseSyslogAddress(text string) (string, string, error) {
+	u, err := url.Parse(text)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Hostname() == "" {
+			return "", "", fmt.Errorf("no host in %q", text)
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = strconv.Itoa(defaultSyslogUDPPort)
+			if u.Scheme == "tcp" {
+				port = strconv.Itoa(defaultSyslogTCPPort)
+			}
+		}
+
+		return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("no socket path in %q", text)
+		}
+
+		return u.Scheme, u.Path, nil
+	}
+
+	return "", "", fmt.Errorf("unknown scheme %q, expected \"udp\", \"tcp\", \"unix\" or \"unixgram\"", u.Scheme)
+}
+
+// enableSyslogLogging returns a core writing to the configured syslog server,
+// and a func closing its connection. It returns a nil core when no server is
+// configured.
+func enableSyslogLogging(cfg Config) (zapcore.Core, func() error, error) {
+	opts := cfg.getSyslogOptions()
+	if opts.address == "" {
+		return nil, nil, nil
+	}
+
+	writer := newSyslogWriter(opts.network, opts.address)
+	core := newRedactCore(newSyslogCore(opts, cfg.getGraylogAppName(), writer), newRedactRules(cfg.getRedactRules()))
+
+	return core, writer.Close, nil
+}
+
+// syslogCore writes entries as RFC 5424 messages. The app name is the
+// APP-NAME, the logger name the MSGID, and fields are written as the
+// parameters of a single STRUCTURED-DATA element.
+type syslogCore struct {
+	opts     syslogOptions
+	appName  string
+	hostname string
+	procID   string
+	writer   *syslogWriter
+	fields   []zapcore.Field
+}
+
+func newSyslogCore(opts syslogOptions, appName string, writer *syslogWriter) *syslogCore {
+	hostname, _ := os.Hostname()
+
+	return &syslogCore{
+		opts:     opts,
+		appName:  appName,
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+		writer:   writer,
+	}
+}
+
+// Enabled enables every level, which are controlled by the logger wide
+// filter, see LevelHandler.
+func (c *syslogCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+
+	return &clone
+}
+
+func (c *syslogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checked.AddCore(entry, c)
+}
+
+func (c *syslogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.writer.write(c.format(entry, fields))
+}
+
+// Sync waits for the queued messages to be written.
+func (c *syslogCore) Sync() error {
+	return c.writer.sync()
+}
+
+// format formats entry as an RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (c *syslogCore) format(entry zapcore.Entry, fields []zapcore.Field) []byte {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %s %s ",
+		c.opts.facility*8+int(zapToSyslog[entry.Level]),
+		entry.Time.Format(syslogTimeFormat),
+		syslogHeader(c.hostname, 255),
+		syslogHeader(c.appName, 48),
+		syslogHeader(c.procID, 128),
+		syslogHeader(entry.LoggerName, 32),
+	)
+
+	c.writeStructuredData(buf, entry, fields)
+
+	message := entry.Message
+	if entry.Stack != "" {
+		message += "\n" + entry.Stack
+	}
+	if message != "" {
+		// The BOM marks the message as UTF-8.
+		buf.WriteString(" \xef\xbb\xbf")
+		buf.WriteString(message)
+	}
+
+	return buf.Bytes()
+}
+
+// writeStructuredData writes the context and fields, and the caller, as the
+// parameters of a single SD-ELEMENT, sorted by name.
+func (c *syslogCore) writeStructuredData(buf *bytes.Buffer, entry zapcore.Entry, fields []zapcore.Field) {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	params := map[string]string{}
+	flattenSyslogParams(params, "", enc.Fields)
+	if entry.Caller.Defined {
+		params["caller"] = entry.Caller.TrimmedPath()
+	}
+
+	if len(params) == 0 {
+		buf.WriteByte('-')
+		return
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf.WriteByte('[')
+	buf.WriteString(c.opts.sdID)
+	for _, name := range names {
+		buf.WriteByte(' ')
+		buf.WriteString(syslogParamName(name))
+		buf.WriteString(`="`)
+		writeSyslogParamValue(buf, params[name])
+		buf.WriteByte('"')
+	}
+	buf.WriteByte(']')
+}
+
+// flattenSyslogParams flattens nested objects into dotted names, and formats
+// the other values as text.
+func flattenSyslogParams(params map[string]string, prefix string, values map[string]interface{}) {
+	for key, value := range values {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenSyslogParams(params, prefix+key+".", v)
+		case string:
+			params[prefix+key] = v
+		case time.Time:
+			params[prefix+key] = v.Format(time.RFC3339Nano)
+		case time.Duration:
+			params[prefix+key] = v.String()
+		case []interface{}:
+			data, err := json.Marshal(v)
+			if err != nil {
+				data = []byte(fmt.Sprint(v))
+			}
+			params[prefix+key] = string(data)
+		default:
+			params[prefix+key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// syslogHeader returns value as a header field: printable US-ASCII without
+// spaces, at most max characters long, or the NILVALUE "-" when empty.
+func syslogHeader(value string, max int) string {
+	header := make([]byte, 0, len(value))
+	for i := 0; i < len(value) && len(header) < max; i++ {
+		if value[i] >= 33 && value[i] <= 126 {
+			header = append(header, value[i])
+		} else {
+			header = append(header, '_')
+		}
+	}
+
+	if len(header) == 0 {
+		return "-"
+	}
+
+	return string(header)
+}
+
+// syslogParamName returns name as a PARAM-NAME, which excludes "=", "]" and
+// '"' and is at most 32 characters long.
+func syslogParamName(name string) string {
+	param := []byte(syslogHeader(name, 32))
+	for i, b := range param {
+		if b == '=' || b == ']' || b == '"' {
+			param[i] = '_'
+		}
+	}
+
+	return string(param)
+}
+
+// writeSyslogParamValue escapes '"', "\" and "]" in a PARAM-VALUE.
+func writeSyslogParamValue(buf *bytes.Buffer, value string) {
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"', '\\', ']':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(value[i])
+	}
+}
+
+// syslogWriter sends messages to a syslog server in the background, so that
+// an unresponsive server can't block logging: messages are queued, and
+// dropped once the queue is full. It connects lazily, and reconnects once
+// when a write fails, e.g. after the server restarted.
+type syslogWriter struct {
+	*batcher
+
+	network string
+	address string
+
+	// conn and retryAt are only used by the sender goroutine, and by Close
+	// once it stopped. No connection is attempted before retryAt, after one
+	// failed, so that the messages queued in the meantime are dropped
+	// without waiting for the server.
+	conn    net.Conn
+	retryAt time.Time
+}
+
+func newSyslogWriter(network string, address string) *syslogWriter {
+	w := &syslogWriter{network: network, address: address}
+	w.batcher = newBatcher("syslog "+network+"://"+address, syslogBatch, 0, syslogFlushInterval, w.send)
+
+	return w
+}
+
+// write queues a message.
+func (w *syslogWriter) write(msg []byte) error {
+	return w.add(msg, len(msg))
+}
+
+// send writes a batch of messages, dropping the rest of it on the first
+// failure.
+func (w *syslogWriter) send(items []interface{}) error {
+	for i, item := range items {
+		if err := w.writeMessage(item.([]byte)); err != nil {
+			return fmt.Errorf("could not write to syslog at %s://%s, dropped %d messages: %v", w.network, w.address, len(items)-i, err)
+		}
+	}
+
+	return nil
+}
+
+func (w *syslogWriter) writeMessage(msg []byte) error {
+	if w.isStream() {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if time.Now().Before(w.retryAt) {
+				return fmt.Errorf("server unreachable")
+			}
+
+			if w.conn, err = net.DialTimeout(w.network, w.address, syslogTimeout); err != nil {
+				w.conn = nil
+				w.retryAt = time.Now().Add(syslogTimeout)
+				return err
+			}
+		}
+
+		w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = w.conn.Write(msg); err == nil {
+			return nil
+		}
+
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	return err
+}
+
+// isStream reports whether messages need framing to be told apart.
+func (w *syslogWriter) isStream() bool {
+	return w.network == "tcp" || w.network == "unix"
+}
+
+// Close sends the queued messages, and closes the connection to the syslog
+// server.
+func (w *syslogWriter) Close() error {
+	err := w.batcher.Close()
+
+	if w.conn != nil {
+		if closeErr := w.conn.Close(); err == nil {
+			err = closeErr
+		}
+		w.conn = nil
+	}
+
+	return err
+}
@@ -0,0 +1,394 @@
+package gzap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseSyslogAddress(t *testing.T) {
+	tests := []struct {
+		text        string
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{"udp://rsyslog:1514", "udp", "rsyslog:1514", false},
+		{"udp://rsyslog", "udp", "rsyslog:514", false},
+		{"tcp://rsyslog", "tcp", "rsyslog:601", false},
+		{"tcp://[::1]:6514", "tcp", "[::1]:6514", false},
+		{"unix:///dev/log", "unix", "/dev/log", false},
+		{"unixgram:///dev/log", "unixgram", "/dev/log", false},
+		{"rsyslog:514", "", "", true},
+		{"udp://", "", "", true},
+		{"unix://", "", "", true},
+		{"http://rsyslog", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			network, address, err := parseSyslogAddress(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSyslogAddress(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			expect(t, network, tt.wantNetwork)
+			expect(t, address, tt.wantAddress)
+		})
+	}
+}
+
+func newTestSyslogCore(writer *syslogWriter) *syslogCore {
+	core := newSyslogCore(syslogOptions{facility: syslogFacilities["local0"], sdID: defaultSyslogSDID}, "my-app", writer)
+	core.hostname = "web-1"
+	core.procID = "42"
+
+	return core
+}
+
+func TestSyslogCore_Format(t *testing.T) {
+	entry := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Date(2018, time.January, 2, 15, 4, 5, 123456000, time.UTC),
+		LoggerName: "db",
+		Message:    "slow query",
+		Caller:     zapcore.NewEntryCaller(0, "/go/src/app/db/db.go", 42, true),
+	}
+
+	tests := []struct {
+		name    string
+		entry   func(entry zapcore.Entry) zapcore.Entry
+		context []zapcore.Field
+		fields  []zapcore.Field
+		want    string
+	}{
+		{
+			"format should write the header, fields and message",
+			func(entry zapcore.Entry) zapcore.Entry { return entry },
+			[]zapcore.Field{zap.String("request_id", "abc")},
+			[]zapcore.Field{zap.Duration("took", 2*time.Second), zap.Int("rows", 3)},
+			"<132>1 2018-01-02T15:04:05.123456Z web-1 my-app 42 db " +
+				`[fields@32473 caller="db/db.go:42" request_id="abc" rows="3" took="2s"]` +
+				" \xef\xbb\xbfslow query",
+		},
+		{
+			"format should flatten objects and escape values",
+			func(entry zapcore.Entry) zapcore.Entry {
+				entry.Caller = zapcore.EntryCaller{}
+				return entry
+			},
+			nil,
+			[]zapcore.Field{
+				zap.Namespace("sql"),
+				zap.String("query", `select "a" from [t] where b = '\'`),
+				zap.Strings("tables", []string{"t"}),
+			},
+			"<132>1 2018-01-02T15:04:05.123456Z web-1 my-app 42 db " +
+				`[fields@32473 sql.query="select \"a\" from [t\] where b = '\\'" sql.tables="[\"t\"\]"]` +
+				" \xef\xbb\xbfslow query",
+		},
+		{
+			"format should write nil values and the stack trace",
+			func(entry zapcore.Entry) zapcore.Entry {
+				entry.Level = zapcore.ErrorLevel
+				entry.LoggerName = ""
+				entry.Caller = zapcore.EntryCaller{}
+				entry.Stack = "main.main\n\t/go/src/app/main.go:12"
+				return entry
+			},
+			nil,
+			nil,
+			"<131>1 2018-01-02T15:04:05.123456Z web-1 my-app 42 - - " +
+				"\xef\xbb\xbfslow query\nmain.main\n\t/go/src/app/main.go:12",
+		},
+		{
+			"format should sanitize header fields and parameter names",
+			func(entry zapcore.Entry) zapcore.Entry {
+				entry.LoggerName = "http server.with a name longer than 32 characters"
+				entry.Caller = zapcore.EntryCaller{}
+				return entry
+			},
+			nil,
+			[]zapcore.Field{zap.String(`a="b"]`, "c")},
+			"<132>1 2018-01-02T15:04:05.123456Z web-1 my-app 42 http_server.with_a_name_longer_t " +
+				`[fields@32473 a__b__="c"]` +
+				" \xef\xbb\xbfslow query",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core := newTestSyslogCore(nil).With(tt.context).(*syslogCore)
+			expect(t, string(core.format(tt.entry(entry), tt.fields)), tt.want)
+		})
+	}
+}
+
+func TestSyslogCore_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writer := newSyslogWriter("udp", conn.LocalAddr().String())
+	defer writer.Close()
+
+	logger := zap.New(newTestSyslogCore(writer))
+	logger.Info("one")
+	logger.Info("two")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, want := range []string{"one", "two"} {
+		buf := make([]byte, 2048)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Datagrams are not framed.
+		message := string(buf[:n])
+		if !strings.HasPrefix(message, "<134>1 ") || !strings.HasSuffix(message, "\xef\xbb\xbf"+want) {
+			t.Errorf("unexpected message %q", message)
+		}
+	}
+}
+
+// readFrames reads n octet counted messages from the first connection
+// accepted by listener.
+func readFrames(listener net.Listener, n int) ([]string, error) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	frames := []string{}
+	for len(frames) < n {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame length %q", length)
+		}
+
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return nil, err
+		}
+		frames = append(frames, string(frame))
+	}
+
+	return frames, nil
+}
+
+func TestSyslogCore_Stream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gzap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		network string
+		address string
+	}{
+		{"tcp", "127.0.0.1:0"},
+		{"unix", filepath.Join(dir, "syslog.sock")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			listener, err := net.Listen(tt.network, tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			writer := newSyslogWriter(tt.network, listener.Addr().String())
+			defer writer.Close()
+
+			logger := zap.New(newTestSyslogCore(writer)).Named("db")
+			logger.Info("one")
+			logger.Info("two\nlines", zap.String("table", "users"))
+
+			frames, err := readFrames(listener, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect(t, strings.HasSuffix(frames[0], "db - \xef\xbb\xbfone"), true)
+			expect(t, strings.HasSuffix(frames[1], `db [fields@32473 table="users"] `+"\xef\xbb\xbftwo\nlines"), true)
+		})
+	}
+}
+
+func TestSyslogCore_Reconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	writer := newSyslogWriter("tcp", listener.Addr().String())
+	defer writer.Close()
+
+	core := newTestSyslogCore(writer)
+	entry := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "one"}
+	if err := core.Write(entry, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFrames(listener, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server closed the connection. The first writes after that may still
+	// succeed, until the reset of the connection is noticed.
+	type result struct {
+		frames []string
+		err    error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		frames, err := readFrames(listener, 1)
+		accepted <- result{frames, err}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entry.Message = "two"
+		if err := core.Write(entry, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case accepted := <-accepted:
+			if accepted.err != nil {
+				t.Fatal(accepted.err)
+			}
+			expect(t, strings.HasSuffix(accepted.frames[0], "\xef\xbb\xbftwo"), true)
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the writer did not reconnect")
+		}
+	}
+}
+
+func TestSyslogCore_Unreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	writer := newSyslogWriter("tcp", address)
+	defer writer.Close()
+
+	// Writes only queue the message, the failure is reported by Sync.
+	core := newTestSyslogCore(writer)
+	if err := core.Write(zapcore.Entry{Message: "lost"}, nil); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	err = core.Sync()
+	if err == nil || !strings.Contains(err.Error(), address) {
+		t.Fatalf("Sync() error = %v, want an error naming %s", err, address)
+	}
+}
+
+func TestSyslogCore_DoesNotBlock(t *testing.T) {
+	// The server accepts connections, but never reads from them.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	writer := newSyslogWriter("tcp", listener.Addr().String())
+	core := newTestSyslogCore(writer)
+
+	start := time.Now()
+	message := strings.Repeat("x", 8<<10)
+	dropped := false
+	for i := 0; i < 2*syslogBatch*batchQueueSize; i++ {
+		if err := core.Write(zapcore.Entry{Message: message}, nil); err != nil {
+			dropped = true
+		}
+	}
+	if elapsed := time.Since(start); elapsed > syslogTimeout/2 {
+		t.Errorf("writing took %s, want the writes not to wait for the server", elapsed)
+	}
+	if !dropped {
+		t.Error("expected messages to be dropped once the queue is full")
+	}
+
+	listener.Close()
+	writer.Close()
+}
+
+func TestEnableSyslogLogging(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cfg := &MockEnvConfig{}
+	cfg.On("getSyslogOptions").Return(syslogOptions{
+		network:  "udp",
+		address:  conn.LocalAddr().String(),
+		facility: syslogFacilities["user"],
+		sdID:     defaultSyslogSDID,
+	})
+	cfg.On("getGraylogAppName").Return("my-app")
+	cfg.On("getRedactRules").Return([]string{"password"})
+
+	core, closeSyslog, err := enableSyslogLogging(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSyslog()
+
+	zap.New(core).Debug("signed in", zap.String("password", "hunter2"))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := string(buf[:n])
+	if !strings.HasPrefix(message, "<15>1 ") || !strings.Contains(message, " my-app ") {
+		t.Errorf("unexpected header in %q", message)
+	}
+	if !strings.Contains(message, `password="[REDACTED\]"`) {
+		t.Errorf("expected the password to be redacted from %q", message)
+	}
+}
+
+func TestEnableSyslogLogging_Disabled(t *testing.T) {
+	cfg := &MockEnvConfig{}
+	cfg.On("getSyslogOptions").Return(syslogOptions{})
+
+	core, closeSyslog, err := enableSyslogLogging(cfg)
+	if core != nil || closeSyslog != nil || err != nil {
+		t.Fatalf("enableSyslogLogging() = %v, %v; want a nil core and closer", core, err)
+	}
+}
@@ -0,0 +1,116 @@
+package gzap
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Keys of the fields correlating entries with Datadog APM traces.
+const (
+	traceIDKey = "dd.trace_id"
+	spanIDKey  = "dd.span_id"
+)
+
+// Headers propagating a Datadog trace between services.
+const (
+	datadogTraceIDHeader  = "X-Datadog-Trace-Id"
+	datadogParentIDHeader = "X-Datadog-Parent-Id"
+)
+
+// traceKey is the key under which WithTrace stores the trace of a context.
+type traceKey struct{}
+
+// trace identifies the span of a Datadog APM trace.
+type trace struct {
+	traceID uint64
+	spanID  uint64
+}
+
+// TraceExtractor returns the trace and span IDs of the span carried by ctx,
+// if any.
+type TraceExtractor func(ctx context.Context) (traceID uint64, spanID uint64, ok bool)
+
+// traceExtractor holds the TraceExtractor set by SetTraceExtractor.
+var traceExtractor atomic.Value // TraceExtractor
+
+// SetTraceExtractor sets the func reading the span of a context, so that
+// entries logged with it carry its trace and span IDs. With dd-trace-go:
+//
+//	gzap.SetTraceExtractor(func(ctx context.Context) (uint64, uint64, bool) {
+//		span, ok := tracer.SpanFromContext(ctx)
+//		if !ok {
+//			return 0, 0, false
+//		}
+//		return span.Context().TraceID(), span.Context().SpanID(), true
+//	})
+//
+// The span found by the extractor takes precedence over one set by WithTrace.
+func SetTraceExtractor(extractor TraceExtractor) {
+	traceExtractor.Store(extractor)
+}
+
+// WithTrace returns a copy of ctx carrying a trace and span ID. Loggers
+// returned by FromContext add them to every entry, as dd.trace_id and
+// dd.span_id.
+func WithTrace(ctx context.Context, traceID uint64, spanID uint64) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace{traceID: traceID, spanID: spanID})
+}
+
+// TraceFromContext returns the trace and span IDs carried by ctx, as found by
+// the TraceExtractor or set by WithTrace.
+func TraceFromContext(ctx context.Context) (traceID uint64, spanID uint64, ok bool) {
+	if ctx == nil {
+		return 0, 0, false
+	}
+
+	if extractor, _ := traceExtractor.Load().(TraceExtractor); extractor != nil {
+		if traceID, spanID, ok := extractor(ctx); ok && traceID != 0 {
+			return traceID, spanID, true
+		}
+	}
+
+	if t, ok := ctx.Value(traceKey{}).(trace); ok {
+		return t.traceID, t.spanID, true
+	}
+
+	return 0, 0, false
+}
+
+// TraceFields returns the fields correlating entries with the traces carried
+// by ctx: dd.trace_id and dd.span_id for a Datadog trace, and trace_id,
+// span_id and trace_flags for a W3C trace context. It returns no fields when
+// ctx carries neither, and is meant for loggers that are not returned by
+// FromContext.
+func TraceFields(ctx context.Context) []zapcore.Field {
+	var fields []zapcore.Field
+
+	// IDs are written as strings, as GelfCore only keeps the string values
+	// of context fields, and as JSON numbers lose the precision of 64-bit
+	// integers.
+	if traceID, spanID, ok := TraceFromContext(ctx); ok {
+		fields = append(fields, String(traceIDKey, strconv.FormatUint(traceID, 10)))
+		if spanID != 0 {
+			fields = append(fields, String(spanIDKey, strconv.FormatUint(spanID, 10)))
+		}
+	}
+
+	return append(fields, traceContextFields(ctx)...)
+}
+
+// traceFromHeaders returns the trace propagated by the Datadog headers of a
+// request, the span being the one of the caller.
+func traceFromHeaders(header http.Header) (trace, bool) {
+	traceID, err := strconv.ParseUint(header.Get(datadogTraceIDHeader), 10, 64)
+	if err != nil || traceID == 0 {
+		return trace{}, false
+	}
+
+	// The parent ID is optional, e.g. when the trace starts at a proxy.
+	spanID, _ := strconv.ParseUint(header.Get(datadogParentIDHeader), 10, 64)
+
+	return trace{traceID: traceID, spanID: spanID}, true
+}
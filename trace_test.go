@@ -0,0 +1,183 @@
+package gzap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Devatoria/go-graylog"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type spanKey struct{}
+
+// withExtractor sets a TraceExtractor reading the span stored under spanKey,
+// and returns a func unsetting it.
+func withExtractor() func() {
+	SetTraceExtractor(func(ctx context.Context) (uint64, uint64, bool) {
+		span, ok := ctx.Value(spanKey{}).(trace)
+		return span.traceID, span.spanID, ok
+	})
+
+	return func() { SetTraceExtractor(nil) }
+}
+
+func TestTraceFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		ctx       func() context.Context
+		extractor bool
+		want      []zapcore.Field
+	}{
+		{
+			"TraceFields should return no fields without a trace",
+			context.Background,
+			false,
+			nil,
+		},
+		{
+			"TraceFields should return the trace set by WithTrace",
+			func() context.Context { return WithTrace(context.Background(), 1234, 5678) },
+			false,
+			[]zapcore.Field{String("dd.trace_id", "1234"), String("dd.span_id", "5678")},
+		},
+		{
+			"TraceFields should write IDs beyond the precision of JSON numbers",
+			func() context.Context { return WithTrace(context.Background(), 18446744073709551615, 1) },
+			false,
+			[]zapcore.Field{String("dd.trace_id", "18446744073709551615"), String("dd.span_id", "1")},
+		},
+		{
+			"TraceFields should omit a missing span",
+			func() context.Context { return WithTrace(context.Background(), 1234, 0) },
+			false,
+			[]zapcore.Field{String("dd.trace_id", "1234")},
+		},
+		{
+			"TraceFields should prefer the span found by the extractor",
+			func() context.Context {
+				ctx := WithTrace(context.Background(), 1234, 5678)
+				return context.WithValue(ctx, spanKey{}, trace{traceID: 42, spanID: 43})
+			},
+			true,
+			[]zapcore.Field{String("dd.trace_id", "42"), String("dd.span_id", "43")},
+		},
+		{
+			"TraceFields should fall back to WithTrace when the extractor finds no span",
+			func() context.Context { return WithTrace(context.Background(), 1234, 5678) },
+			true,
+			[]zapcore.Field{String("dd.trace_id", "1234"), String("dd.span_id", "5678")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.extractor {
+				defer withExtractor()()
+			}
+
+			got := TraceFields(tt.ctx())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TraceFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceFromHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		traceID  string
+		parentID string
+		want     trace
+		wantOK   bool
+	}{
+		{"both headers", "1234", "5678", trace{traceID: 1234, spanID: 5678}, true},
+		{"no parent", "1234", "", trace{traceID: 1234}, true},
+		{"invalid parent", "1234", "abc", trace{traceID: 1234}, true},
+		{"no trace", "", "5678", trace{}, false},
+		{"zero trace", "0", "5678", trace{}, false},
+		{"hexadecimal trace", "4d2", "5678", trace{}, false},
+		{"overflowing trace", "18446744073709551616", "5678", trace{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.traceID != "" {
+				header.Set("x-datadog-trace-id", tt.traceID)
+			}
+			if tt.parentID != "" {
+				header.Set("x-datadog-parent-id", tt.parentID)
+			}
+
+			got, ok := traceFromHeaders(header)
+			expect(t, ok, tt.wantOK)
+			expect(t, got, tt.want)
+		})
+	}
+}
+
+func TestFromContext_Trace(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	undo := ReplaceGlobals(zap.New(core))
+	defer undo()
+
+	ctx := WithContext(context.Background(), String("tenant", "acme"))
+	Ctx(WithTrace(ctx, 1, 2)).Info("first span")
+	// The logger cached for the fields must not keep the previous span.
+	Ctx(WithTrace(ctx, 1, 3)).Info("second span")
+	Ctx(ctx).Info("no span")
+
+	entries := logs.AllUntimed()
+	expect(t, len(entries), 3)
+
+	first := entries[0].ContextMap()
+	expect(t, first["tenant"], "acme")
+	expect(t, first["dd.trace_id"], "1")
+	expect(t, first["dd.span_id"], "2")
+
+	expect(t, entries[1].ContextMap()["dd.span_id"], "3")
+
+	_, ok := entries[2].ContextMap()["dd.trace_id"]
+	expect(t, ok, false)
+}
+
+func TestFromContext_TraceInDatadogJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	undo := ReplaceGlobals(zap.New(zapcore.NewCore(newDatadogEncoder(datadogOptions{}, false), zapcore.AddSync(buf), zapcore.DebugLevel)))
+	defer undo()
+
+	Ctx(WithTrace(context.Background(), 1234, 5678)).Info("traced")
+
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, got["dd.trace_id"], "1234")
+	expect(t, got["dd.span_id"], "5678")
+}
+
+func TestFromContext_TraceInGELF(t *testing.T) {
+	sent := make(chan graylog.Message, 1)
+	gl := NewMockGraylog()
+	gl.On("Send", mock.AnythingOfType("graylog.Message")).Run(func(args mock.Arguments) {
+		sent <- args.Get(0).(graylog.Message)
+	}).Return(nil)
+
+	cfg := &MockEnvConfig{}
+	cfg.On("getGraylogAppName").Return("my-app")
+
+	undo := ReplaceGlobals(zap.New(NewGelfCore(cfg, &gl)))
+	defer undo()
+
+	Ctx(WithTrace(context.Background(), 1234, 5678)).Info("traced")
+
+	msg := <-sent
+	expect(t, msg.Extra["dd.trace_id"], "1234")
+	expect(t, msg.Extra["dd.span_id"], "5678")
+}
@@ -0,0 +1,309 @@
+package gzap
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Headers of the W3C trace context, see https://www.w3.org/TR/trace-context/.
+const (
+	traceParentHeader = "Traceparent"
+	traceStateHeader  = "Tracestate"
+)
+
+// Keys of the fields correlating entries with a W3C trace context.
+const (
+	w3cTraceIDKey    = "trace_id"
+	w3cSpanIDKey     = "span_id"
+	w3cTraceFlagsKey = "trace_flags"
+)
+
+const (
+	// traceParentLength is the length of a version 00 traceparent,
+	// "00-<32 hex>-<16 hex>-<2 hex>".
+	traceParentLength = 55
+
+	// maxTraceStateMembers is the number of list members a tracestate may
+	// hold.
+	maxTraceStateMembers = 32
+)
+
+// traceContextKey is the key under which WithTraceContext stores the trace
+// context of a context.
+type traceContextKey struct{}
+
+// TraceContext is a W3C trace context, as propagated by the traceparent and
+// tracestate headers.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+
+	// State is the vendor specific tracestate, propagated as is.
+	State string
+}
+
+// Sampled reports whether the caller may have recorded the trace.
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&0x01 != 0
+}
+
+// TraceParent formats tc as a version 00 traceparent.
+func (tc TraceContext) TraceParent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// ParseTraceParent parses the traceparent and tracestate headers. traceparent
+// must strictly follow the W3C format, with lower case hexadecimal IDs that
+// are not all zeros. An invalid tracestate is dropped, as the specification
+// requires, rather than failing the trace context.
+func ParseTraceParent(traceparent string, tracestate string) (TraceContext, error) {
+	var tc TraceContext
+
+	if len(traceparent) < traceParentLength {
+		return tc, fmt.Errorf("gzap: invalid traceparent %q: too short", traceparent)
+	}
+
+	version := traceparent[0:2]
+	if !isLowerHex(version) || version == "ff" {
+		return tc, fmt.Errorf("gzap: invalid traceparent %q: invalid version", traceparent)
+	}
+
+	// Later versions may append fields, which are ignored.
+	if version == "00" && len(traceparent) != traceParentLength {
+		return tc, fmt.Errorf("gzap: invalid traceparent %q: too long", traceparent)
+	}
+	if len(traceparent) > traceParentLength && traceparent[traceParentLength] != '-' {
+		return tc, fmt.Errorf("gzap: invalid traceparent %q: invalid trailing field", traceparent)
+	}
+
+	if traceparent[2] != '-' || traceparent[35] != '-' || traceparent[52] != '-' {
+		return tc, fmt.Errorf("gzap: invalid traceparent %q: invalid separators", traceparent)
+	}
+
+	traceID, spanID, flags := traceparent[3:35], traceparent[36:52], traceparent[53:55]
+	if !isLowerHex(traceID) || traceID == strings.Repeat("0", 32) {
+		return tc, fmt.Errorf("gzap: invalid traceparent %q: invalid trace ID", traceparent)
+	}
+	if !isLowerHex(spanID) || spanID == strings.Repeat("0", 16) {
+		return tc, fmt.Errorf("gzap: invalid traceparent %q: invalid parent ID", traceparent)
+	}
+	if !isLowerHex(flags) {
+		return tc, fmt.Errorf("gzap: invalid traceparent %q: invalid flags", traceparent)
+	}
+
+	hex.Decode(tc.TraceID[:], []byte(traceID))
+	hex.Decode(tc.SpanID[:], []byte(spanID))
+
+	var flag [1]byte
+	hex.Decode(flag[:], []byte(flags))
+	tc.Flags = flag[0]
+
+	if isValidTraceState(tracestate) {
+		tc.State = tracestate
+	}
+
+	return tc, nil
+}
+
+// isLowerHex reports whether s only holds lower case hexadecimal digits.
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isValidTraceState reports whether tracestate is a list of at most 32
+// "key=value" members, with unique keys. Empty members are allowed.
+func isValidTraceState(tracestate string) bool {
+	if strings.TrimSpace(tracestate) == "" {
+		return false
+	}
+
+	keys := map[string]bool{}
+	for _, member := range strings.Split(tracestate, ",") {
+		member = strings.Trim(member, " \t")
+		if member == "" {
+			continue
+		}
+
+		eq := strings.IndexByte(member, '=')
+		if eq < 0 {
+			return false
+		}
+
+		key, value := member[:eq], member[eq+1:]
+		if !isValidTraceStateKey(key) || !isValidTraceStateValue(value) || keys[key] {
+			return false
+		}
+		keys[key] = true
+	}
+
+	return len(keys) > 0 && len(keys) <= maxTraceStateMembers
+}
+
+// isValidTraceStateKey validates a simple key, "vendor", or a multi-tenant
+// key, "tenant@vendor".
+func isValidTraceStateKey(key string) bool {
+	tenant, system := key, ""
+	if at := strings.IndexByte(key, '@'); at >= 0 {
+		tenant, system = key[:at], key[at+1:]
+		if len(tenant) == 0 || len(tenant) > 241 || len(system) == 0 || len(system) > 14 {
+			return false
+		}
+		if !isTraceStateKeyChars(system, false) || !isTraceStateKeyChars(tenant, true) {
+			return false
+		}
+
+		return true
+	}
+
+	return len(key) > 0 && len(key) <= 256 && isTraceStateKeyChars(key, false)
+}
+
+// isTraceStateKeyChars validates the characters of a key, which starts with a
+// lower case letter, or a digit for tenants.
+func isTraceStateKeyChars(key string, digitFirst bool) bool {
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'a' <= c && c <= 'z':
+		case '0' <= c && c <= '9':
+			if i == 0 && !digitFirst {
+				return false
+			}
+		case c == '_' || c == '-' || c == '*' || c == '/':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// isValidTraceStateValue validates up to 256 printable ASCII characters
+// other than "," and "=", not ending with a space.
+func isValidTraceStateValue(value string) bool {
+	if len(value) == 0 || len(value) > 256 || value[len(value)-1] == ' ' {
+		return false
+	}
+
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] > 0x7e || value[i] == ',' || value[i] == '=' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WithTraceContext returns a copy of ctx carrying tc. Loggers returned by
+// FromContext add its trace_id, span_id and trace_flags to every entry, and
+// TraceContextTransport propagates it to outbound requests.
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the trace context carried by ctx, see
+// WithTraceContext.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	if ctx == nil {
+		return TraceContext{}, false
+	}
+
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// traceContextFields returns the trace_id, span_id and trace_flags fields of
+// the trace context carried by ctx, if any.
+func traceContextFields(ctx context.Context) []zapcore.Field {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	return []zapcore.Field{
+		String(w3cTraceIDKey, hex.EncodeToString(tc.TraceID[:])),
+		String(w3cSpanIDKey, hex.EncodeToString(tc.SpanID[:])),
+		String(w3cTraceFlagsKey, fmt.Sprintf("%02x", tc.Flags)),
+	}
+}
+
+// traceContextFromHeaders returns the trace context of a request, if its
+// traceparent header is valid.
+func traceContextFromHeaders(header http.Header) (TraceContext, bool) {
+	// A traceparent sent more than once is invalid.
+	values := header[traceParentHeader]
+	if len(values) != 1 {
+		return TraceContext{}, false
+	}
+
+	// Multiple tracestate headers are combined, as with any list header.
+	tc, err := ParseTraceParent(values[0], strings.Join(header[traceStateHeader], ","))
+	if err != nil {
+		return TraceContext{}, false
+	}
+
+	return tc, true
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of the trace
+// context carried by ctx, if any. A traceparent that is already set is kept,
+// along with its tracestate.
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok || header.Get(traceParentHeader) != "" {
+		return
+	}
+
+	header.Set(traceParentHeader, tc.TraceParent())
+	if tc.State != "" {
+		header.Set(traceStateHeader, tc.State)
+	} else {
+		header.Del(traceStateHeader)
+	}
+}
+
+// TraceContextTransport returns an http.RoundTripper propagating the trace
+// context of every request's context, see InjectTraceContext. It uses
+// http.DefaultTransport when base is nil:
+//
+//	client := &http.Client{Transport: gzap.TraceContextTransport(nil)}
+//	req, _ := http.NewRequest("GET", url, nil)
+//	resp, err := client.Do(req.WithContext(r.Context()))
+func TraceContextTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &traceContextTransport{base: base}
+}
+
+type traceContextTransport struct {
+	base http.RoundTripper
+}
+
+func (t *traceContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := TraceContextFromContext(req.Context()); !ok || req.Header.Get(traceParentHeader) != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request it is given.
+	outbound := req.Clone(req.Context())
+	InjectTraceContext(req.Context(), outbound.Header)
+
+	return t.base.RoundTrip(outbound)
+}
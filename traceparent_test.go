@@ -0,0 +1,227 @@
+package gzap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		wantErr     bool
+		want        string
+	}{
+		{"a valid traceparent", testTraceParent, false, testTraceParent},
+		{"an unsampled trace", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{"a later version with more fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, testTraceParent},
+		{"a later version", "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09", false, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09"},
+		{"an empty header", "", true, ""},
+		{"a short header", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", true, ""},
+		{"version 00 with more fields", testTraceParent + "-extra", true, ""},
+		{"a later version with an invalid trailing field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01extra", true, ""},
+		{"the forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, ""},
+		{"an upper case trace ID", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true, ""},
+		{"an upper case version", "0A-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, ""},
+		{"a zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true, ""},
+		{"a zero parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true, ""},
+		{"non hexadecimal flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g", true, ""},
+		{"invalid separators", "00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01", true, ""},
+		{"a misplaced separator", "00-4bf92f3577b34da6a3ce929d0e0e473-600f067aa0ba902b7-01", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := ParseTraceParent(tt.traceparent, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceParent(%q) error = %v, wantErr %v", tt.traceparent, err, tt.wantErr)
+			}
+			if err == nil {
+				expect(t, tc.TraceParent(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTraceParent_TraceState(t *testing.T) {
+	tests := []struct {
+		name       string
+		tracestate string
+		want       string
+	}{
+		{"a single member", "congo=t61rcWkgMzE", "congo=t61rcWkgMzE"},
+		{"several members", "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"},
+		{"multi-tenant keys", "fw529a3039@dt=1,7tenant@vendor=2", "fw529a3039@dt=1,7tenant@vendor=2"},
+		{"empty members and spaces", "rojo=1 ,, \tcongo=2", "rojo=1 ,, \tcongo=2"},
+		{"no header", "", ""},
+		{"a duplicated key", "rojo=1,rojo=2", ""},
+		{"an upper case key", "Rojo=1", ""},
+		{"a key starting with a digit", "1rojo=1", ""},
+		{"a value with an equal sign", "rojo=a=b", ""},
+		{"a member without value", "rojo", ""},
+		{"a value ending with a space", "rojo=1 =", ""},
+		{"a system too long", "tenant@abcdefghijklmno=1", ""},
+		{"the maximum number of members", traceStateMembers(32), traceStateMembers(32)},
+		{"too many members", traceStateMembers(33), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := ParseTraceParent(testTraceParent, tt.tracestate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect(t, tc.State, tt.want)
+		})
+	}
+}
+
+// traceStateMembers returns a tracestate of n distinct members.
+func traceStateMembers(n int) string {
+	members := []string{}
+	for i := 0; i < n; i++ {
+		members = append(members, "k"+strconv.Itoa(i)+"=1")
+	}
+
+	return strings.Join(members, ",")
+}
+
+func TestTraceContext(t *testing.T) {
+	tc, err := ParseTraceParent(testTraceParent, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, tc.Sampled(), true)
+	expect(t, tc.TraceID[0], byte(0x4b))
+	expect(t, tc.SpanID[7], byte(0xb7))
+
+	tc.Flags = 0
+	expect(t, tc.Sampled(), false)
+}
+
+func TestTraceContextFromHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    http.Header
+		wantOK    bool
+		wantState string
+	}{
+		{
+			"a single traceparent",
+			http.Header{"Traceparent": {testTraceParent}},
+			true,
+			"",
+		},
+		{
+			"tracestate headers sent more than once",
+			http.Header{"Traceparent": {testTraceParent}, "Tracestate": {"rojo=1", "congo=2"}},
+			true,
+			"rojo=1,congo=2",
+		},
+		{
+			"a traceparent sent more than once",
+			http.Header{"Traceparent": {testTraceParent, testTraceParent}},
+			false,
+			"",
+		},
+		{
+			"an invalid traceparent",
+			http.Header{"Traceparent": {"00-xyz"}, "Tracestate": {"rojo=1"}},
+			false,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, ok := traceContextFromHeaders(tt.header)
+			expect(t, ok, tt.wantOK)
+			expect(t, tc.State, tt.wantState)
+		})
+	}
+}
+
+func TestFromContext_TraceContext(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	undo := ReplaceGlobals(zap.New(core))
+	defer undo()
+
+	tc, err := ParseTraceParent(testTraceParent, "rojo=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ctx(WithTraceContext(context.Background(), tc)).Info("traced")
+
+	entries := logs.AllUntimed()
+	expect(t, len(entries), 1)
+
+	fields := entries[0].ContextMap()
+	expect(t, fields["trace_id"], "4bf92f3577b34da6a3ce929d0e0e4736")
+	expect(t, fields["span_id"], "00f067aa0ba902b7")
+	expect(t, fields["trace_flags"], "01")
+	expect(t, len(fields), 3)
+}
+
+func TestTraceContextTransport(t *testing.T) {
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer server.Close()
+
+	tc, err := ParseTraceParent(testTraceParent, "rojo=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithTraceContext(context.Background(), tc)
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		header     http.Header
+		wantParent string
+		wantState  string
+	}{
+		{"a traced context", ctx, http.Header{}, testTraceParent, "rojo=1"},
+		{"an untraced context", context.Background(), http.Header{}, "", ""},
+		{
+			"a request with its own traceparent",
+			ctx,
+			http.Header{"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}},
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header = tt.header
+			req = req.WithContext(tt.ctx)
+
+			client := &http.Client{Transport: TraceContextTransport(nil)}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			header := <-received
+			expect(t, header.Get("traceparent"), tt.wantParent)
+			expect(t, header.Get("tracestate"), tt.wantState)
+
+			// The request given to the transport is left untouched.
+			expect(t, len(req.Header), len(tt.header))
+		})
+	}
+}